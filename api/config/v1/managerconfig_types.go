@@ -0,0 +1,225 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	cfg "sigs.k8s.io/controller-runtime/pkg/config/v1alpha1"
+)
+
+// ConflictPolicy decides what the controller does with a label that already
+// exists in the namespace and is not managed by any NamespaceLabel
+type ConflictPolicy string
+
+const (
+	// ConflictPolicySkip leaves the existing label untouched and reports it in the unSyncLabels status
+	ConflictPolicySkip ConflictPolicy = "Skip"
+	// ConflictPolicyOverwrite takes over the existing label and writes the NamespaceLabel value
+	ConflictPolicyOverwrite ConflictPolicy = "Overwrite"
+)
+
+// EnforcementConfig holds the defaults used when syncing NamespaceLabels to namespaces
+type EnforcementConfig struct {
+	// ConflictPolicy is applied to labels that already exist in the namespace, defaults to Skip
+	ConflictPolicy ConflictPolicy `json:"conflictPolicy,omitempty"`
+}
+
+// OrphanLabelPolicy decides what the sweeper does with managed labels that no NamespaceLabel claims anymore
+type OrphanLabelPolicy string
+
+const (
+	// OrphanLabelPolicyReport logs the orphaned labels and records a warning event on the namespace
+	OrphanLabelPolicyReport OrphanLabelPolicy = "Report"
+	// OrphanLabelPolicyRemove deletes the orphaned labels from the namespace
+	OrphanLabelPolicyRemove OrphanLabelPolicy = "Remove"
+)
+
+// OrphanLabelsConfig holds the settings of the orphaned labels sweeper
+type OrphanLabelsConfig struct {
+	// Policy is applied to every orphaned label found, defaults to Report
+	Policy OrphanLabelPolicy `json:"policy,omitempty"`
+
+	// SweepInterval is the time between two scans of all namespaces, the sweeper is disabled when zero
+	SweepInterval *metav1.Duration `json:"sweepInterval,omitempty"`
+}
+
+// ShardingConfig splits the namespaces between the replicas of the manager, every replica
+// reconciles its own slice instead of a single leader reconciling all of them
+type ShardingConfig struct {
+	// Enabled turns sharding on, leader election and the hub mode must be disabled
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Group names the replicas sharing the namespaces, defaults to namespacelabel
+	Group string `json:"group,omitempty"`
+
+	// LeaseNamespace holds the Leases of the replicas, defaults to the namespace of the manager pod
+	LeaseNamespace string `json:"leaseNamespace,omitempty"`
+
+	// LeaseDuration is the time a replica keeps its namespaces without renewing its Lease, defaults to 15s
+	LeaseDuration *metav1.Duration `json:"leaseDuration,omitempty"`
+
+	// RenewInterval is the time between two renewals of the Lease, defaults to 5s
+	RenewInterval *metav1.Duration `json:"renewInterval,omitempty"`
+
+	// SettlePeriod is the time the replicas must stay unchanged before namespaces move between them,
+	// it must be longer than the renew interval plus the longest reconcile. Defaults to 30s
+	SettlePeriod *metav1.Duration `json:"settlePeriod,omitempty"`
+}
+
+// HubConfig makes the manager a hub, it propagates the FederatedNamespaceLabels of its cluster
+// to the clusters registered by a kubeconfig Secret
+type HubConfig struct {
+	// Enabled turns the hub mode on, it relies on leader election and cannot be used with sharding
+	Enabled bool `json:"enabled,omitempty"`
+
+	// ClusterSecretNamespace holds the kubeconfig Secrets of the clusters, defaults to the namespace of the manager pod
+	ClusterSecretNamespace string `json:"clusterSecretNamespace,omitempty"`
+
+	// ResyncPeriod is the time between two reads of the status of the clusters, defaults to 1m
+	ResyncPeriod *metav1.Duration `json:"resyncPeriod,omitempty"`
+
+	// ClusterTimeout bounds every request sent to a cluster, defaults to 30s
+	ClusterTimeout *metav1.Duration `json:"clusterTimeout,omitempty"`
+
+	// WithdrawTimeout is how long a deleted FederatedNamespaceLabel waits for the clusters that can not be
+	// reached, or are not registered anymore, to delete their NamespaceLabel. Once it passed the
+	// FederatedNamespaceLabel is released and those NamespaceLabels are left behind, defaults to 10m
+	WithdrawTimeout *metav1.Duration `json:"withdrawTimeout,omitempty"`
+}
+
+// SnapshotsConfig holds the settings of the NamespaceLabelSnapshots the namespaces are rolled back to
+type SnapshotsConfig struct {
+	// Interval is the time between two rounds of snapshots of all namespaces, a namespace only gets a new
+	// snapshot when its labels changed. The snapshots are disabled when zero
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	// Retain is the number of snapshots kept per namespace, defaults to 48
+	Retain int32 `json:"retain,omitempty"`
+}
+
+// LivenessConfig holds the settings of the liveness check of the work queue
+type LivenessConfig struct {
+	// StuckThreshold is the time a reconcile may run, or requests may wait in the queue while no reconcile
+	// starts or ends, before the liveness probe fails and the manager is restarted. Never fails when zero
+	StuckThreshold *metav1.Duration `json:"stuckThreshold,omitempty"`
+}
+
+// ThrottlingConfig limits the writes of the controller to the namespaces. A write over the limits is
+// retried later and the NamespaceLabels of the namespace report a Throttled condition meanwhile
+type ThrottlingConfig struct {
+	// WritesPerSecond limits the writes to all the namespaces, unlimited when zero
+	WritesPerSecond int32 `json:"writesPerSecond,omitempty"`
+
+	// WriteBurst is the number of writes allowed at once over WritesPerSecond, defaults to WritesPerSecond
+	WriteBurst int32 `json:"writeBurst,omitempty"`
+
+	// NamespaceWriteInterval is the minimum time between two writes to the same namespace once
+	// its NamespaceWriteBurst is used, unlimited when empty
+	NamespaceWriteInterval *metav1.Duration `json:"namespaceWriteInterval,omitempty"`
+
+	// NamespaceWriteBurst is the number of writes allowed at once to a namespace, defaults to 1
+	NamespaceWriteBurst int32 `json:"namespaceWriteBurst,omitempty"`
+
+	// RetryBaseDelay and RetryMaxDelay bound the exponential backoff of the failed reconciles of
+	// a namespace, the defaults of controller-runtime are 5ms and 1000s
+	RetryBaseDelay *metav1.Duration `json:"retryBaseDelay,omitempty"`
+	RetryMaxDelay  *metav1.Duration `json:"retryMaxDelay,omitempty"`
+
+	// CircuitBreaker pauses all the writes when too many of them fail
+	CircuitBreaker CircuitBreakerConfig `json:"circuitBreaker,omitempty"`
+}
+
+// CircuitBreakerConfig opens the circuit breaker after MaxFailures failed writes within FailureWindow,
+// no write is done while it is open
+type CircuitBreakerConfig struct {
+	// MaxFailures is the number of failed writes that opens the breaker, the breaker is disabled when zero
+	MaxFailures int32 `json:"maxFailures,omitempty"`
+
+	// FailureWindow is the time the failures are counted in, defaults to 1m
+	FailureWindow *metav1.Duration `json:"failureWindow,omitempty"`
+
+	// OpenDuration is the time the writes are paused, defaults to 5m
+	OpenDuration *metav1.Duration `json:"openDuration,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ManagerConfig is the Schema for the namespacelabel manager configuration file
+type ManagerConfig struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// ControllerManagerConfigurationSpec returns the generic manager settings,
+	// metrics and probe addresses, leader election and the resync period (syncPeriod)
+	cfg.ControllerManagerConfigurationSpec `json:",inline"`
+
+	// ProtectedLabels is the list of label keys the controller never writes
+	ProtectedLabels []string `json:"protectedLabels,omitempty"`
+
+	// ProtectedLabelPrefixes is the list of key prefixes the controller never writes,
+	// e.g. "kubernetes.io/" protects every label in that domain
+	ProtectedLabelPrefixes []string `json:"protectedLabelPrefixes,omitempty"`
+
+	// ApprovalRequiredLabels is the list of label keys a NamespaceLabel only sets, changes or removes
+	// once a LabelChangeApproval approves its generation, e.g. istio-injection
+	ApprovalRequiredLabels []string `json:"approvalRequiredLabels,omitempty"`
+
+	// ApprovalRequiredLabelPrefixes is the list of key prefixes that require approval,
+	// e.g. "pod-security.kubernetes.io/"
+	ApprovalRequiredLabelPrefixes []string `json:"approvalRequiredLabelPrefixes,omitempty"`
+
+	// MaxConcurrentReconciles is the number of namespaces reconciled in parallel, all the
+	// NamespaceLabels of a namespace are reconciled together
+	MaxConcurrentReconciles int `json:"maxConcurrentReconciles,omitempty"`
+
+	// WatchNamespaces restricts the controller to the listed namespaces, the manager cache
+	// only holds them and their NamespaceLabels. All namespaces are watched when empty
+	WatchNamespaces []string `json:"watchNamespaces,omitempty"`
+
+	// NamespaceSelector restricts the controller to the namespaces matching the selector,
+	// together with WatchNamespaces when both are set. Controller instances with disjoint
+	// scopes and their own leader election resource name can serve different tenants
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// Enforcement holds the defaults used when syncing labels
+	Enforcement EnforcementConfig `json:"enforcement,omitempty"`
+
+	// Paused stops the writes of the controller to all the namespaces, like the namespacelabel.omer.io/paused
+	// annotation does for one namespace. The NamespaceLabels still report the labels that differ
+	Paused bool `json:"paused,omitempty"`
+
+	// OrphanLabels configures the periodic garbage collection of labels left behind by deleted NamespaceLabels
+	OrphanLabels OrphanLabelsConfig `json:"orphanLabels,omitempty"`
+
+	// Snapshots configures the periodic snapshots of the labels of every namespace
+	Snapshots SnapshotsConfig `json:"snapshots,omitempty"`
+
+	// Sharding splits the namespaces between the replicas of the manager
+	Sharding ShardingConfig `json:"sharding,omitempty"`
+
+	// Hub propagates FederatedNamespaceLabels to other clusters
+	Hub HubConfig `json:"hub,omitempty"`
+
+	// Throttling limits the writes to the namespaces
+	Throttling ThrottlingConfig `json:"throttling,omitempty"`
+
+	// Liveness configures when the liveness probe considers the controller stuck
+	Liveness LivenessConfig `json:"liveness,omitempty"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ManagerConfig{})
+}
@@ -0,0 +1,273 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// Default fills the enforcement settings that were left empty in the config file
+func (c *ManagerConfig) Default() {
+	if c.Enforcement.ConflictPolicy == "" {
+		c.Enforcement.ConflictPolicy = ConflictPolicySkip
+	}
+	if c.MaxConcurrentReconciles == 0 {
+		c.MaxConcurrentReconciles = 1
+	}
+	if c.OrphanLabels.Policy == "" {
+		c.OrphanLabels.Policy = OrphanLabelPolicyReport
+	}
+	if c.Snapshots.Retain == 0 {
+		c.Snapshots.Retain = 48
+	}
+	if c.Sharding.Enabled {
+		if c.Sharding.Group == "" {
+			c.Sharding.Group = "namespacelabel"
+		}
+		if c.Sharding.LeaseDuration == nil {
+			c.Sharding.LeaseDuration = &metav1.Duration{Duration: 15 * time.Second}
+		}
+		if c.Sharding.RenewInterval == nil {
+			c.Sharding.RenewInterval = &metav1.Duration{Duration: 5 * time.Second}
+		}
+		if c.Sharding.SettlePeriod == nil {
+			c.Sharding.SettlePeriod = &metav1.Duration{Duration: 30 * time.Second}
+		}
+	}
+	if c.Throttling.WritesPerSecond > 0 && c.Throttling.WriteBurst == 0 {
+		c.Throttling.WriteBurst = c.Throttling.WritesPerSecond
+	}
+	if c.Throttling.NamespaceWriteInterval != nil && c.Throttling.NamespaceWriteBurst == 0 {
+		c.Throttling.NamespaceWriteBurst = 1
+	}
+	if c.Throttling.CircuitBreaker.MaxFailures > 0 {
+		if c.Throttling.CircuitBreaker.FailureWindow == nil {
+			c.Throttling.CircuitBreaker.FailureWindow = &metav1.Duration{Duration: time.Minute}
+		}
+		if c.Throttling.CircuitBreaker.OpenDuration == nil {
+			c.Throttling.CircuitBreaker.OpenDuration = &metav1.Duration{Duration: 5 * time.Minute}
+		}
+	}
+	if c.Hub.Enabled {
+		if c.Hub.ResyncPeriod == nil {
+			c.Hub.ResyncPeriod = &metav1.Duration{Duration: time.Minute}
+		}
+		if c.Hub.ClusterTimeout == nil {
+			c.Hub.ClusterTimeout = &metav1.Duration{Duration: 30 * time.Second}
+		}
+		if c.Hub.WithdrawTimeout == nil {
+			c.Hub.WithdrawTimeout = &metav1.Duration{Duration: 10 * time.Minute}
+		}
+	}
+}
+
+// Validate checks the configuration, the manager refuses to start when it returns an error
+func (c *ManagerConfig) Validate() error {
+	var allErrs field.ErrorList
+
+	protectedPath := field.NewPath("protectedLabels")
+	for i, key := range c.ProtectedLabels {
+		for _, msg := range validation.IsQualifiedName(key) {
+			allErrs = append(allErrs, field.Invalid(protectedPath.Index(i), key, msg))
+		}
+	}
+
+	prefixPath := field.NewPath("protectedLabelPrefixes")
+	for i, prefix := range c.ProtectedLabelPrefixes {
+		if prefix == "" {
+			allErrs = append(allErrs, field.Invalid(prefixPath.Index(i), prefix, "must not be empty"))
+		}
+	}
+
+	approvalPath := field.NewPath("approvalRequiredLabels")
+	for i, key := range c.ApprovalRequiredLabels {
+		for _, msg := range validation.IsQualifiedName(key) {
+			allErrs = append(allErrs, field.Invalid(approvalPath.Index(i), key, msg))
+		}
+	}
+
+	approvalPrefixPath := field.NewPath("approvalRequiredLabelPrefixes")
+	for i, prefix := range c.ApprovalRequiredLabelPrefixes {
+		if prefix == "" {
+			allErrs = append(allErrs, field.Invalid(approvalPrefixPath.Index(i), prefix, "must not be empty"))
+		}
+	}
+
+	if c.MaxConcurrentReconciles < 0 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("maxConcurrentReconciles"), c.MaxConcurrentReconciles, "must not be negative"))
+	}
+
+	if c.SyncPeriod != nil && c.SyncPeriod.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("syncPeriod"), c.SyncPeriod.Duration.String(), "must be positive"))
+	}
+
+	watchPath := field.NewPath("watchNamespaces")
+	for i, namespace := range c.WatchNamespaces {
+		for _, msg := range validation.IsDNS1123Label(namespace) {
+			allErrs = append(allErrs, field.Invalid(watchPath.Index(i), namespace, msg))
+		}
+	}
+	if len(c.WatchNamespaces) > 0 && c.CacheNamespace != "" {
+		allErrs = append(allErrs, field.Forbidden(watchPath, "cannot be used together with cacheNamespace"))
+	}
+
+	if c.NamespaceSelector != nil {
+		allErrs = append(allErrs, metav1validation.ValidateLabelSelector(c.NamespaceSelector,
+			metav1validation.LabelSelectorValidationOptions{}, field.NewPath("namespaceSelector"))...)
+	}
+
+	switch c.Enforcement.ConflictPolicy {
+	case "", ConflictPolicySkip, ConflictPolicyOverwrite:
+	default:
+		allErrs = append(allErrs, field.NotSupported(field.NewPath("enforcement", "conflictPolicy"),
+			c.Enforcement.ConflictPolicy, []string{string(ConflictPolicySkip), string(ConflictPolicyOverwrite)}))
+	}
+
+	switch c.OrphanLabels.Policy {
+	case "", OrphanLabelPolicyReport, OrphanLabelPolicyRemove:
+	default:
+		allErrs = append(allErrs, field.NotSupported(field.NewPath("orphanLabels", "policy"),
+			c.OrphanLabels.Policy, []string{string(OrphanLabelPolicyReport), string(OrphanLabelPolicyRemove)}))
+	}
+	if c.OrphanLabels.SweepInterval != nil && c.OrphanLabels.SweepInterval.Duration < 0 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("orphanLabels", "sweepInterval"),
+			c.OrphanLabels.SweepInterval.Duration.String(), "must not be negative"))
+	}
+	if c.Snapshots.Interval != nil && c.Snapshots.Interval.Duration < 0 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("snapshots", "interval"),
+			c.Snapshots.Interval.Duration.String(), "must not be negative"))
+	}
+	if c.Snapshots.Retain < 0 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("snapshots", "retain"), c.Snapshots.Retain, "must not be negative"))
+	}
+	if c.Liveness.StuckThreshold != nil && c.Liveness.StuckThreshold.Duration < 0 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("liveness", "stuckThreshold"),
+			c.Liveness.StuckThreshold.Duration.String(), "must not be negative"))
+	}
+
+	if c.Sharding.Enabled {
+		allErrs = append(allErrs, c.Sharding.validate(c, field.NewPath("sharding"))...)
+	}
+
+	if c.Hub.Enabled {
+		allErrs = append(allErrs, c.Hub.validate(field.NewPath("hub"))...)
+	}
+
+	allErrs = append(allErrs, c.Throttling.validate(field.NewPath("throttling"))...)
+
+	return allErrs.ToAggregate()
+}
+
+// the sharding settings are checked once defaulted, a replica must renew its Lease before it expires
+// and wait for the others to see a membership change before namespaces move
+func (s *ShardingConfig) validate(c *ManagerConfig, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if c.LeaderElection != nil && c.LeaderElection.LeaderElect != nil && *c.LeaderElection.LeaderElect {
+		allErrs = append(allErrs, field.Forbidden(path.Child("enabled"), "cannot be used together with leader election"))
+	}
+	//without a leader every replica would run the hub and push the FederatedNamespaceLabels to every cluster
+	if c.Hub.Enabled {
+		allErrs = append(allErrs, field.Forbidden(path.Child("enabled"), "cannot be used together with the hub mode"))
+	}
+	for _, msg := range validation.IsDNS1123Label(s.Group) {
+		allErrs = append(allErrs, field.Invalid(path.Child("group"), s.Group, msg))
+	}
+	if s.LeaseNamespace == "" {
+		allErrs = append(allErrs, field.Required(path.Child("leaseNamespace"), "the manager pod namespace is unknown"))
+	}
+	if s.LeaseDuration == nil || s.RenewInterval == nil || s.SettlePeriod == nil {
+		return append(allErrs, field.Required(path, "durations must be defaulted"))
+	}
+	if s.RenewInterval.Duration <= 0 || s.RenewInterval.Duration >= s.LeaseDuration.Duration {
+		allErrs = append(allErrs, field.Invalid(path.Child("renewInterval"), s.RenewInterval.Duration.String(),
+			"must be positive and shorter than the lease duration"))
+	}
+	if s.SettlePeriod.Duration <= s.RenewInterval.Duration {
+		allErrs = append(allErrs, field.Invalid(path.Child("settlePeriod"), s.SettlePeriod.Duration.String(),
+			"must be longer than the renew interval"))
+	}
+	return allErrs
+}
+
+// the hub settings are checked once defaulted
+func (h *HubConfig) validate(path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if h.ClusterSecretNamespace == "" {
+		allErrs = append(allErrs, field.Required(path.Child("clusterSecretNamespace"), "the manager pod namespace is unknown"))
+	} else {
+		for _, msg := range validation.IsDNS1123Label(h.ClusterSecretNamespace) {
+			allErrs = append(allErrs, field.Invalid(path.Child("clusterSecretNamespace"), h.ClusterSecretNamespace, msg))
+		}
+	}
+	if h.ResyncPeriod == nil || h.ClusterTimeout == nil || h.WithdrawTimeout == nil {
+		return append(allErrs, field.Required(path, "durations must be defaulted"))
+	}
+	if h.ResyncPeriod.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(path.Child("resyncPeriod"), h.ResyncPeriod.Duration.String(), "must be positive"))
+	}
+	if h.ClusterTimeout.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(path.Child("clusterTimeout"), h.ClusterTimeout.Duration.String(), "must be positive"))
+	}
+	if h.WithdrawTimeout.Duration < 0 {
+		allErrs = append(allErrs, field.Invalid(path.Child("withdrawTimeout"), h.WithdrawTimeout.Duration.String(), "must not be negative"))
+	}
+	return allErrs
+}
+
+// the throttling settings are checked once defaulted, every limit is optional
+func (t *ThrottlingConfig) validate(path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	//the fields are checked in their order in the type, so the errors come in the same order every time
+	for _, limit := range []struct {
+		path  *field.Path
+		value int32
+	}{
+		{path.Child("writesPerSecond"), t.WritesPerSecond},
+		{path.Child("writeBurst"), t.WriteBurst},
+		{path.Child("namespaceWriteBurst"), t.NamespaceWriteBurst},
+		{path.Child("circuitBreaker", "maxFailures"), t.CircuitBreaker.MaxFailures},
+	} {
+		if limit.value < 0 {
+			allErrs = append(allErrs, field.Invalid(limit.path, limit.value, "must not be negative"))
+		}
+	}
+	for _, interval := range []struct {
+		path     *field.Path
+		duration *metav1.Duration
+	}{
+		{path.Child("namespaceWriteInterval"), t.NamespaceWriteInterval},
+		{path.Child("retryBaseDelay"), t.RetryBaseDelay},
+		{path.Child("retryMaxDelay"), t.RetryMaxDelay},
+		{path.Child("circuitBreaker", "failureWindow"), t.CircuitBreaker.FailureWindow},
+		{path.Child("circuitBreaker", "openDuration"), t.CircuitBreaker.OpenDuration},
+	} {
+		if interval.duration != nil && interval.duration.Duration <= 0 {
+			allErrs = append(allErrs, field.Invalid(interval.path, interval.duration.Duration.String(), "must be positive"))
+		}
+	}
+	if (t.RetryBaseDelay == nil) != (t.RetryMaxDelay == nil) {
+		allErrs = append(allErrs, field.Required(path.Child("retryMaxDelay"), "retryBaseDelay and retryMaxDelay are set together"))
+	} else if t.RetryBaseDelay != nil && t.RetryBaseDelay.Duration > t.RetryMaxDelay.Duration {
+		allErrs = append(allErrs, field.Invalid(path.Child("retryMaxDelay"), t.RetryMaxDelay.Duration.String(), "must not be shorter than retryBaseDelay"))
+	}
+	return allErrs
+}
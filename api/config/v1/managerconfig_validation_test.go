@@ -0,0 +1,356 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	configv1alpha1 "k8s.io/component-base/config/v1alpha1"
+)
+
+func TestManagerConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  ManagerConfig
+		wantErr bool
+	}{
+		{
+			name:   "empty config is valid",
+			config: ManagerConfig{},
+		},
+		{
+			name: "full config is valid",
+			config: ManagerConfig{
+				ProtectedLabels:         []string{"kubernetes.io/metadata.name"},
+				ProtectedLabelPrefixes:  []string{"kubernetes.io/"},
+				MaxConcurrentReconciles: 4,
+				WatchNamespaces:         []string{"team-a", "team-b"},
+				Enforcement:             EnforcementConfig{ConflictPolicy: ConflictPolicyOverwrite},
+			},
+		},
+		{
+			name:    "invalid protected label key",
+			config:  ManagerConfig{ProtectedLabels: []string{"not a key"}},
+			wantErr: true,
+		},
+		{
+			name:    "empty protected prefix",
+			config:  ManagerConfig{ProtectedLabelPrefixes: []string{""}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid approval required label",
+			config:  ManagerConfig{ApprovalRequiredLabels: []string{"not a key"}},
+			wantErr: true,
+		},
+		{
+			name:    "empty approval required prefix",
+			config:  ManagerConfig{ApprovalRequiredLabelPrefixes: []string{""}},
+			wantErr: true,
+		},
+		{
+			name:    "negative concurrency",
+			config:  ManagerConfig{MaxConcurrentReconciles: -1},
+			wantErr: true,
+		},
+		{
+			name: "zero sync period",
+			config: func() ManagerConfig {
+				c := ManagerConfig{}
+				c.SyncPeriod = &metav1.Duration{}
+				return c
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "namespace selector is valid",
+			config: ManagerConfig{NamespaceSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"tenant": "a"},
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "env", Operator: metav1.LabelSelectorOpIn, Values: []string{"prod"}},
+				},
+			}},
+		},
+		{
+			name: "invalid namespace selector",
+			config: ManagerConfig{NamespaceSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "env", Operator: metav1.LabelSelectorOpIn},
+				},
+			}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid namespace name",
+			config:  ManagerConfig{WatchNamespaces: []string{"Team_A"}},
+			wantErr: true,
+		},
+		{
+			name: "watch namespaces together with cache namespace",
+			config: func() ManagerConfig {
+				c := ManagerConfig{WatchNamespaces: []string{"team-a"}}
+				c.CacheNamespace = "team-b"
+				return c
+			}(),
+			wantErr: true,
+		},
+		{
+			name:    "unknown orphan label policy",
+			config:  ManagerConfig{OrphanLabels: OrphanLabelsConfig{Policy: "Delete"}},
+			wantErr: true,
+		},
+		{
+			name:    "negative sweep interval",
+			config:  ManagerConfig{OrphanLabels: OrphanLabelsConfig{SweepInterval: &metav1.Duration{Duration: -1}}},
+			wantErr: true,
+		},
+		{
+			name: "defaulted sharding is valid",
+			config: func() ManagerConfig {
+				c := ManagerConfig{Sharding: ShardingConfig{Enabled: true, LeaseNamespace: "system"}}
+				c.Default()
+				return c
+			}(),
+		},
+		{
+			name: "sharding together with leader election",
+			config: func() ManagerConfig {
+				leaderElect := true
+				c := ManagerConfig{Sharding: ShardingConfig{Enabled: true, LeaseNamespace: "system"}}
+				c.LeaderElection = &configv1alpha1.LeaderElectionConfiguration{LeaderElect: &leaderElect}
+				c.Default()
+				return c
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "sharding together with the hub mode",
+			config: func() ManagerConfig {
+				c := ManagerConfig{
+					Sharding: ShardingConfig{Enabled: true, LeaseNamespace: "system"},
+					Hub:      HubConfig{Enabled: true, ClusterSecretNamespace: "system"},
+				}
+				c.Default()
+				return c
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "sharding without lease namespace",
+			config: func() ManagerConfig {
+				c := ManagerConfig{Sharding: ShardingConfig{Enabled: true}}
+				c.Default()
+				return c
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "sharding renew interval longer than the lease",
+			config: func() ManagerConfig {
+				c := ManagerConfig{Sharding: ShardingConfig{
+					Enabled:        true,
+					LeaseNamespace: "system",
+					RenewInterval:  &metav1.Duration{Duration: time.Minute},
+				}}
+				c.Default()
+				return c
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "sharding settle period shorter than the renew interval",
+			config: func() ManagerConfig {
+				c := ManagerConfig{Sharding: ShardingConfig{
+					Enabled:        true,
+					LeaseNamespace: "system",
+					SettlePeriod:   &metav1.Duration{Duration: time.Second},
+				}}
+				c.Default()
+				return c
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "defaulted hub is valid",
+			config: func() ManagerConfig {
+				c := ManagerConfig{Hub: HubConfig{Enabled: true, ClusterSecretNamespace: "system"}}
+				c.Default()
+				return c
+			}(),
+		},
+		{
+			name: "hub without cluster secret namespace",
+			config: func() ManagerConfig {
+				c := ManagerConfig{Hub: HubConfig{Enabled: true}}
+				c.Default()
+				return c
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "hub with zero resync period",
+			config: func() ManagerConfig {
+				c := ManagerConfig{Hub: HubConfig{
+					Enabled:                true,
+					ClusterSecretNamespace: "system",
+					ResyncPeriod:           &metav1.Duration{},
+				}}
+				c.Default()
+				return c
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "hub with negative withdraw timeout",
+			config: func() ManagerConfig {
+				c := ManagerConfig{Hub: HubConfig{
+					Enabled:                true,
+					ClusterSecretNamespace: "system",
+					WithdrawTimeout:        &metav1.Duration{Duration: -time.Minute},
+				}}
+				c.Default()
+				return c
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "defaulted throttling is valid",
+			config: func() ManagerConfig {
+				c := ManagerConfig{Throttling: ThrottlingConfig{
+					WritesPerSecond:        20,
+					NamespaceWriteInterval: &metav1.Duration{Duration: 10 * time.Second},
+					RetryBaseDelay:         &metav1.Duration{Duration: time.Second},
+					RetryMaxDelay:          &metav1.Duration{Duration: time.Minute},
+					CircuitBreaker:         CircuitBreakerConfig{MaxFailures: 10},
+				}}
+				c.Default()
+				return c
+			}(),
+		},
+		{
+			name:    "negative writes per second",
+			config:  ManagerConfig{Throttling: ThrottlingConfig{WritesPerSecond: -1}},
+			wantErr: true,
+		},
+		{
+			name: "zero namespace write interval",
+			config: ManagerConfig{Throttling: ThrottlingConfig{
+				NamespaceWriteInterval: &metav1.Duration{},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "retry base delay without max delay",
+			config: ManagerConfig{Throttling: ThrottlingConfig{
+				RetryBaseDelay: &metav1.Duration{Duration: time.Second},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "retry max delay shorter than base delay",
+			config: ManagerConfig{Throttling: ThrottlingConfig{
+				RetryBaseDelay: &metav1.Duration{Duration: time.Minute},
+				RetryMaxDelay:  &metav1.Duration{Duration: time.Second},
+			}},
+			wantErr: true,
+		},
+		{
+			name:    "negative snapshot interval",
+			config:  ManagerConfig{Snapshots: SnapshotsConfig{Interval: &metav1.Duration{Duration: -time.Hour}}},
+			wantErr: true,
+		},
+		{
+			name:    "negative stuck threshold",
+			config:  ManagerConfig{Liveness: LivenessConfig{StuckThreshold: &metav1.Duration{Duration: -time.Minute}}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown conflict policy",
+			config:  ManagerConfig{Enforcement: EnforcementConfig{ConflictPolicy: "Merge"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestManagerConfigValidateErrorOrder(t *testing.T) {
+	config := ManagerConfig{Throttling: ThrottlingConfig{
+		WritesPerSecond:        -1,
+		WriteBurst:             -1,
+		NamespaceWriteBurst:    -1,
+		NamespaceWriteInterval: &metav1.Duration{},
+		RetryBaseDelay:         &metav1.Duration{},
+		RetryMaxDelay:          &metav1.Duration{},
+		CircuitBreaker:         CircuitBreakerConfig{MaxFailures: -1, FailureWindow: &metav1.Duration{}},
+	}}
+	want := config.Validate()
+	if want == nil {
+		t.Fatal("Validate() expected an error")
+	}
+	for i := 0; i < 20; i++ {
+		if err := config.Validate(); err.Error() != want.Error() {
+			t.Fatalf("Validate() error = %v, want the same error every time: %v", err, want)
+		}
+	}
+}
+
+func TestManagerConfigDefault(t *testing.T) {
+	config := ManagerConfig{}
+	config.Default()
+	if config.Enforcement.ConflictPolicy != ConflictPolicySkip {
+		t.Errorf("expected conflict policy %q, got %q", ConflictPolicySkip, config.Enforcement.ConflictPolicy)
+	}
+	if config.MaxConcurrentReconciles != 1 {
+		t.Errorf("expected 1 concurrent reconcile, got %d", config.MaxConcurrentReconciles)
+	}
+	if config.OrphanLabels.Policy != OrphanLabelPolicyReport {
+		t.Errorf("expected orphan label policy %q, got %q", OrphanLabelPolicyReport, config.OrphanLabels.Policy)
+	}
+	if config.Snapshots.Retain != 48 {
+		t.Errorf("expected 48 retained snapshots, got %d", config.Snapshots.Retain)
+	}
+	if config.Throttling.CircuitBreaker.FailureWindow != nil {
+		t.Errorf("expected no failure window without a circuit breaker, got %v", config.Throttling.CircuitBreaker.FailureWindow)
+	}
+
+	config = ManagerConfig{Throttling: ThrottlingConfig{
+		WritesPerSecond:        5,
+		NamespaceWriteInterval: &metav1.Duration{Duration: time.Second},
+		CircuitBreaker:         CircuitBreakerConfig{MaxFailures: 3},
+	}}
+	config.Default()
+	if config.Throttling.WriteBurst != 5 {
+		t.Errorf("expected write burst 5, got %d", config.Throttling.WriteBurst)
+	}
+	if config.Throttling.NamespaceWriteBurst != 1 {
+		t.Errorf("expected namespace write burst 1, got %d", config.Throttling.NamespaceWriteBurst)
+	}
+	if config.Throttling.CircuitBreaker.FailureWindow.Duration != time.Minute ||
+		config.Throttling.CircuitBreaker.OpenDuration.Duration != 5*time.Minute {
+		t.Errorf("expected 1m failure window and 5m open duration, got %v", config.Throttling.CircuitBreaker)
+	}
+}
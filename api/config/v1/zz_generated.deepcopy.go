@@ -0,0 +1,280 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by controller-gen. DO NOT EDIT.
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+)
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CircuitBreakerConfig) DeepCopyInto(out *CircuitBreakerConfig) {
+	*out = *in
+	if in.FailureWindow != nil {
+		in, out := &in.FailureWindow, &out.FailureWindow
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.OpenDuration != nil {
+		in, out := &in.OpenDuration, &out.OpenDuration
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CircuitBreakerConfig.
+func (in *CircuitBreakerConfig) DeepCopy() *CircuitBreakerConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(CircuitBreakerConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EnforcementConfig) DeepCopyInto(out *EnforcementConfig) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EnforcementConfig.
+func (in *EnforcementConfig) DeepCopy() *EnforcementConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(EnforcementConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HubConfig) DeepCopyInto(out *HubConfig) {
+	*out = *in
+	if in.ResyncPeriod != nil {
+		in, out := &in.ResyncPeriod, &out.ResyncPeriod
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.ClusterTimeout != nil {
+		in, out := &in.ClusterTimeout, &out.ClusterTimeout
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.WithdrawTimeout != nil {
+		in, out := &in.WithdrawTimeout, &out.WithdrawTimeout
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HubConfig.
+func (in *HubConfig) DeepCopy() *HubConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(HubConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LivenessConfig) DeepCopyInto(out *LivenessConfig) {
+	*out = *in
+	if in.StuckThreshold != nil {
+		in, out := &in.StuckThreshold, &out.StuckThreshold
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LivenessConfig.
+func (in *LivenessConfig) DeepCopy() *LivenessConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(LivenessConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ManagerConfig) DeepCopyInto(out *ManagerConfig) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ControllerManagerConfigurationSpec.DeepCopyInto(&out.ControllerManagerConfigurationSpec)
+	if in.ProtectedLabels != nil {
+		in, out := &in.ProtectedLabels, &out.ProtectedLabels
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ProtectedLabelPrefixes != nil {
+		in, out := &in.ProtectedLabelPrefixes, &out.ProtectedLabelPrefixes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ApprovalRequiredLabels != nil {
+		in, out := &in.ApprovalRequiredLabels, &out.ApprovalRequiredLabels
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ApprovalRequiredLabelPrefixes != nil {
+		in, out := &in.ApprovalRequiredLabelPrefixes, &out.ApprovalRequiredLabelPrefixes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.WatchNamespaces != nil {
+		in, out := &in.WatchNamespaces, &out.WatchNamespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.NamespaceSelector != nil {
+		in, out := &in.NamespaceSelector, &out.NamespaceSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	out.Enforcement = in.Enforcement
+	in.OrphanLabels.DeepCopyInto(&out.OrphanLabels)
+	in.Snapshots.DeepCopyInto(&out.Snapshots)
+	in.Sharding.DeepCopyInto(&out.Sharding)
+	in.Hub.DeepCopyInto(&out.Hub)
+	in.Throttling.DeepCopyInto(&out.Throttling)
+	in.Liveness.DeepCopyInto(&out.Liveness)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ManagerConfig.
+func (in *ManagerConfig) DeepCopy() *ManagerConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(ManagerConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ManagerConfig) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OrphanLabelsConfig) DeepCopyInto(out *OrphanLabelsConfig) {
+	*out = *in
+	if in.SweepInterval != nil {
+		in, out := &in.SweepInterval, &out.SweepInterval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OrphanLabelsConfig.
+func (in *OrphanLabelsConfig) DeepCopy() *OrphanLabelsConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(OrphanLabelsConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ShardingConfig) DeepCopyInto(out *ShardingConfig) {
+	*out = *in
+	if in.LeaseDuration != nil {
+		in, out := &in.LeaseDuration, &out.LeaseDuration
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.RenewInterval != nil {
+		in, out := &in.RenewInterval, &out.RenewInterval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.SettlePeriod != nil {
+		in, out := &in.SettlePeriod, &out.SettlePeriod
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ShardingConfig.
+func (in *ShardingConfig) DeepCopy() *ShardingConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(ShardingConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapshotsConfig) DeepCopyInto(out *SnapshotsConfig) {
+	*out = *in
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapshotsConfig.
+func (in *SnapshotsConfig) DeepCopy() *SnapshotsConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapshotsConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ThrottlingConfig) DeepCopyInto(out *ThrottlingConfig) {
+	*out = *in
+	if in.NamespaceWriteInterval != nil {
+		in, out := &in.NamespaceWriteInterval, &out.NamespaceWriteInterval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.RetryBaseDelay != nil {
+		in, out := &in.RetryBaseDelay, &out.RetryBaseDelay
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.RetryMaxDelay != nil {
+		in, out := &in.RetryMaxDelay, &out.RetryMaxDelay
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	in.CircuitBreaker.DeepCopyInto(&out.CircuitBreaker)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ThrottlingConfig.
+func (in *ThrottlingConfig) DeepCopy() *ThrottlingConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(ThrottlingConfig)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// FederatedNamespaceLabelSpec defines the NamespaceLabel a hub cluster propagates to its clusters
+type FederatedNamespaceLabelSpec struct {
+	// Template is the spec of the NamespaceLabel created in every cluster, with the
+	// name and namespace of the FederatedNamespaceLabel
+	Template NamespaceLabelSpec `json:"template,omitempty"`
+
+	// Clusters lists the names of the clusters to propagate to, every registered cluster when empty
+	// +optional
+	Clusters []string `json:"clusters,omitempty"`
+}
+
+// ClusterStatus is the state of the NamespaceLabel propagated to one cluster
+type ClusterStatus struct {
+	// Name of the cluster, the name of its kubeconfig Secret in the hub
+	Name string `json:"name"`
+
+	// Propagated is true when the NamespaceLabel of the cluster has the labels of the template
+	Propagated bool `json:"propagated"`
+
+	// Synced is the status of the Synced condition the cluster reported for the propagated spec,
+	// Unknown until the controller of the cluster reconciled it
+	// +optional
+	Synced metav1.ConditionStatus `json:"synced,omitempty"`
+
+	// SyncLabels and UnSyncLabels are copied from the status of the NamespaceLabel of the cluster
+	// +optional
+	SyncLabels map[string]string `json:"syncLabels,omitempty"`
+	// +optional
+	UnSyncLabels map[string]string `json:"unSyncLabels,omitempty"`
+
+	// Orphaned is true when the cluster is not selected or not registered anymore and the NamespaceLabel
+	// the hub wrote to it could not be deleted, it is left behind in the cluster
+	// +optional
+	Orphaned bool `json:"orphaned,omitempty"`
+
+	// Message explains why the cluster is not propagated
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// FederatedNamespaceLabelStatus aggregates the state of the NamespaceLabel in every cluster
+type FederatedNamespaceLabelStatus struct {
+	// ObservedGeneration is the generation of the spec the status was computed from
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Clusters holds one entry per cluster the NamespaceLabel is propagated to
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	Clusters []ClusterStatus `json:"clusters,omitempty"`
+
+	// Conditions summarize the clusters, Propagated and Synced are True when they are True in every cluster
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+const (
+	// ConditionTypePropagated is True when the NamespaceLabel of every selected cluster has the labels of the template
+	ConditionTypePropagated = "Propagated"
+
+	// ReasonPropagated means the template is written to every selected cluster
+	ReasonPropagated = "Propagated"
+	// ReasonPropagationFailed means some clusters could not be written, their status holds the error
+	ReasonPropagationFailed = "PropagationFailed"
+	// ReasonClustersNotSynced means some clusters did not sync all the labels of the template yet
+	ReasonClustersNotSynced = "ClustersNotSynced"
+
+	// ConditionTypeOrphaned is True when NamespaceLabels the hub wrote are left behind in clusters that
+	// are not selected or not registered anymore, the orphaned entries of the clusters status hold the error
+	ConditionTypeOrphaned = "Orphaned"
+
+	// ReasonClustersUnreachable means the NamespaceLabel of some clusters could not be deleted
+	ReasonClustersUnreachable = "ClustersUnreachable"
+	// ReasonNoOrphans means every NamespaceLabel of the clusters that are not selected anymore is deleted
+	ReasonNoOrphans = "NoOrphans"
+)
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Propagated",type=string,JSONPath=`.status.conditions[?(@.type=="Propagated")].status`
+//+kubebuilder:printcolumn:name="Synced",type=string,JSONPath=`.status.conditions[?(@.type=="Synced")].status`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// FederatedNamespaceLabel is the Schema for the federatednamespacelabels API. It lives in a hub
+// cluster, which keeps a NamespaceLabel with the same name and namespace in every selected cluster
+type FederatedNamespaceLabel struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   FederatedNamespaceLabelSpec   `json:"spec,omitempty"`
+	Status FederatedNamespaceLabelStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// FederatedNamespaceLabelList contains a list of FederatedNamespaceLabel
+type FederatedNamespaceLabelList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []FederatedNamespaceLabel `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&FederatedNamespaceLabel{}, &FederatedNamespaceLabelList{})
+}
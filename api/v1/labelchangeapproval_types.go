@@ -0,0 +1,69 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// LabelChangeApprovalSpec names the NamespaceLabel generation that is approved
+// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="spec is immutable, create another LabelChangeApproval"
+type LabelChangeApprovalSpec struct {
+	// NamespaceLabel is the name of the approved NamespaceLabel, in the namespace of the approval
+	// +kubebuilder:validation:MinLength=1
+	NamespaceLabel string `json:"namespaceLabel"`
+
+	// NamespaceLabelUID is the uid of the approved NamespaceLabel, shown in its metadata.uid. A NamespaceLabel
+	// deleted and created again with the same name starts over at generation 1 and needs another approval
+	NamespaceLabelUID types.UID `json:"namespaceLabelUID"`
+
+	// Generation is the approved generation of the NamespaceLabel, a later change to its
+	// spec needs another approval
+	// +kubebuilder:validation:Minimum=1
+	Generation int64 `json:"generation"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:printcolumn:name="NamespaceLabel",type=string,JSONPath=`.spec.namespaceLabel`
+//+kubebuilder:printcolumn:name="Generation",type=integer,JSONPath=`.spec.generation`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// LabelChangeApproval is the Schema for the labelchangeapprovals API. It lets a NamespaceLabel set,
+// change and remove from its spec the labels that require approval in the manager configuration.
+// Only the approvers should be allowed to create it, with the labelchangeapproval-editor-role.
+// Deleting the NamespaceLabel removes its labels without any approval, so the approvers should
+// also be the only ones allowed to delete the NamespaceLabels holding those labels
+type LabelChangeApproval struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec LabelChangeApprovalSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// LabelChangeApprovalList contains a list of LabelChangeApproval
+type LabelChangeApprovalList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []LabelChangeApproval `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&LabelChangeApproval{}, &LabelChangeApprovalList{})
+}
@@ -29,6 +29,62 @@ type NamespaceLabelSpec struct {
 	// Important: Run "make" to regenerate code after modifying this file
 
 	Labels map[string]string `json:"labels,omitempty"`
+
+	// Inheritable lists the keys of labels that are also synced to the descendant namespaces, the
+	// namespaces naming this one as parent in the namespacelabel.omer.io/parent annotation or in the
+	// hierarchy labels of HNC. The NamespaceLabels of a descendant win over the inherited labels
+	// +optional
+	// +listType=set
+	Inheritable []string `json:"inheritable,omitempty"`
+
+	// PropagateTo also stamps the synced labels onto the selected objects of the namespace,
+	// they are removed from the objects when the NamespaceLabel is deleted
+	// +optional
+	PropagateTo *PropagationSpec `json:"propagateTo,omitempty"`
+
+	// Suspend stops the writes of the NamespaceLabel: its labels are neither set, changed nor removed,
+	// also when it is deleted, until it is resumed. The labels that differ from the namespace are
+	// reported in unSyncLabels and in the Suspended condition. The namespacelabel.omer.io/paused=true
+	// annotation suspends all the NamespaceLabels of a namespace
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
+}
+
+// PropagationKind is a kind of object the labels can be propagated to
+// +kubebuilder:validation:Enum=Deployment;StatefulSet;Service;ServiceAccount;PodTemplate
+type PropagationKind string
+
+const (
+	PropagationKindDeployment     PropagationKind = "Deployment"
+	PropagationKindStatefulSet    PropagationKind = "StatefulSet"
+	PropagationKindService        PropagationKind = "Service"
+	PropagationKindServiceAccount PropagationKind = "ServiceAccount"
+	PropagationKindPodTemplate    PropagationKind = "PodTemplate"
+)
+
+// PropagationSpec selects the objects of the namespace the labels are propagated to. Only the
+// labels of the objects are changed, not the pod templates of Deployments and StatefulSets,
+// which would roll out their pods
+type PropagationSpec struct {
+	// Kinds of the objects to label
+	// +kubebuilder:validation:MinItems=1
+	// +listType=set
+	Kinds []PropagationKind `json:"kinds"`
+
+	// Selector restricts the objects to the ones matching it, all the objects of the kinds when empty
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+}
+
+// PropagationStatus counts the objects of one kind the labels are propagated to
+type PropagationStatus struct {
+	Kind PropagationKind `json:"kind"`
+
+	// Selected is the number of objects of the kind matching the selector
+	Selected int32 `json:"selected"`
+
+	// Labeled is the number of selected objects carrying all the synced labels
+	Labeled int32 `json:"labeled"`
 }
 
 // NamespaceLabelStatus defines the observed state of NamespaceLabel
@@ -37,10 +93,71 @@ type NamespaceLabelStatus struct {
 	// Important: Run "make" to regenerate code after modifying this file
 	SyncLabels   map[string]string `json:"syncLabels,omitempty"`
 	UnSyncLabels map[string]string `json:"unSyncLabels,omitempty"`
+
+	// PendingLabels are the labels that require approval and wait for a LabelChangeApproval of
+	// the current generation, with the value they will be synced with. The ones removed from the
+	// spec have the value they keep in the namespace until the removal is approved
+	// +optional
+	PendingLabels map[string]string `json:"pendingLabels,omitempty"`
+
+	// ObservedGeneration is the generation of the spec the status was computed from
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Propagation counts the objects labeled for every kind of propagateTo
+	// +listType=map
+	// +listMapKey=kind
+	// +optional
+	Propagation []PropagationStatus `json:"propagation,omitempty"`
+
+	// Conditions describe the latest sync of the NamespaceLabel to its namespace
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
 }
 
+const (
+	// ConditionTypeSynced is True when every label of the spec is written to the namespace
+	ConditionTypeSynced = "Synced"
+
+	// ReasonSynced means all the labels of the spec are synced
+	ReasonSynced = "Synced"
+	// ReasonLabelsNotSynced means some labels of the spec are listed in unSyncLabels
+	ReasonLabelsNotSynced = "LabelsNotSynced"
+	// ReasonApprovalPending means the labels of the spec that are not synced are all listed in pendingLabels
+	ReasonApprovalPending = "ApprovalPending"
+
+	// ConditionTypeThrottled is True while the writes to the namespace are delayed, the other
+	// conditions and the synced labels are the ones of the last sync done
+	ConditionTypeThrottled = "Throttled"
+
+	// ReasonRateLimited means the write is over the configured write rate
+	ReasonRateLimited = "RateLimited"
+	// ReasonCircuitOpen means the writes are paused after too many of them failed
+	ReasonCircuitOpen = "CircuitOpen"
+	// ReasonNotThrottled means the last write was not delayed
+	ReasonNotThrottled = "NotThrottled"
+
+	// ConditionTypeSuspended is True while the writes of the NamespaceLabel are suspended, its message
+	// lists the labels of the spec that differ from the namespace
+	ConditionTypeSuspended = "Suspended"
+
+	// ReasonSuspended means spec.suspend is set
+	ReasonSuspended = "Suspended"
+	// ReasonNamespacePaused means the namespace has the namespacelabel.omer.io/paused=true annotation
+	ReasonNamespacePaused = "NamespacePaused"
+	// ReasonManagerPaused means the controller runs with all the writes paused
+	ReasonManagerPaused = "ManagerPaused"
+	// ReasonNotSuspended means the writes are resumed
+	ReasonNotSuspended = "NotSuspended"
+)
+
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Synced",type=string,JSONPath=`.status.conditions[?(@.type=="Synced")].status`
+//+kubebuilder:printcolumn:name="Suspend",type=boolean,JSONPath=`.spec.suspend`,priority=1
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // NamespaceLabel is the Schema for the namespacelabels API
 type NamespaceLabel struct {
@@ -0,0 +1,68 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NamespaceLabelRuleSpec defines the labels a rule derives from the name and annotations of every namespace
+type NamespaceLabelRuleSpec struct {
+	// NamePattern is a regular expression the whole namespace name must match, e.g.
+	// (?P<team>[a-z]+)-(?P<env>[a-z]+)-(?P<app>.+). The rule skips the namespaces it does not match,
+	// without a pattern the rule applies to every namespace
+	// +optional
+	NamePattern string `json:"namePattern,omitempty"`
+
+	// Labels maps a label key to its value, $name and ${name} are replaced by the named capture
+	// groups of NamePattern. When empty every named capture group is a label of the same name
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// FromAnnotations maps an annotation of the namespace to the key of the label its value is
+	// synced to, the label is not set on the namespaces without the annotation
+	// +optional
+	FromAnnotations map[string]string `json:"fromAnnotations,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Pattern",type=string,JSONPath=`.spec.namePattern`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// NamespaceLabelRule is the Schema for the namespacelabelrules API. The labels it derives are synced
+// to the namespaces like the labels of a NamespaceLabel, the NamespaceLabels of a namespace and the
+// labels it inherits win over them
+type NamespaceLabelRule struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec NamespaceLabelRuleSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// NamespaceLabelRuleList contains a list of NamespaceLabelRule
+type NamespaceLabelRuleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NamespaceLabelRule `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NamespaceLabelRule{}, &NamespaceLabelRuleList{})
+}
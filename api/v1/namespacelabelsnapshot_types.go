@@ -0,0 +1,83 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NamespaceLabelSnapshotSpec is the label state of the namespace of the snapshot when it was taken
+// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="spec is immutable, snapshots are only taken by the controller"
+type NamespaceLabelSnapshotSpec struct {
+	// NamespaceLabels are the NamespaceLabels of the namespace and the labels of their spec,
+	// a rollback to the snapshot rewrites the NamespaceLabels with them
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	NamespaceLabels []NamespaceLabelState `json:"namespaceLabels,omitempty"`
+
+	// ManagedLabels are the labels the controller managed in the namespace, with their value and owner
+	// +listType=map
+	// +listMapKey=key
+	// +optional
+	ManagedLabels []ManagedLabel `json:"managedLabels,omitempty"`
+}
+
+// NamespaceLabelState is the spec labels of a NamespaceLabel in a snapshot
+type NamespaceLabelState struct {
+	Name string `json:"name"`
+
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// ManagedLabel is a label of the namespace written by the controller
+type ManagedLabel struct {
+	Key string `json:"key"`
+
+	Value string `json:"value"`
+
+	// Owner is the source of the label: the name of a NamespaceLabel of the namespace, namespace/name for
+	// an inherited label and rule:name for a label derived by a NamespaceLabelRule
+	Owner string `json:"owner"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// NamespaceLabelSnapshot is the Schema for the namespacelabelsnapshots API. The controller takes one
+// in every namespace when its label state changed since the last one, and keeps the most recent ones.
+// namespacelabel rollback rewrites the NamespaceLabels of the namespace to a snapshot
+type NamespaceLabelSnapshot struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec NamespaceLabelSnapshotSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// NamespaceLabelSnapshotList contains a list of NamespaceLabelSnapshot
+type NamespaceLabelSnapshotList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NamespaceLabelSnapshot `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NamespaceLabelSnapshot{}, &NamespaceLabelSnapshotList{})
+}
@@ -0,0 +1,250 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command namespacelabel bootstraps and checks NamespaceLabel manifests kept in git, and rolls the
+// NamespaceLabels of a namespace back to a NamespaceLabelSnapshot.
+//
+//	namespacelabel export [flags]     print the labels of the namespaces as NamespaceLabel manifests
+//	namespacelabel verify [flags]     compare a manifest directory to the namespaces and report the drift
+//	namespacelabel rollback [flags]   rewrite the NamespaceLabels of a namespace to one of its snapshots
+//
+// export and verify read the namespaces of the current kubeconfig context, or a saved
+// `kubectl get namespaces -o yaml` dump with --from-file.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
+	_ "k8s.io/client-go/plugin/pkg/client/auth"
+
+	omerv1 "omer.io/namespacelabel/api/v1"
+	"omer.io/namespacelabel/pkg/gitops"
+	"omer.io/namespacelabel/pkg/labelsync"
+)
+
+const usage = `Usage: namespacelabel <command> [flags]
+
+Commands:
+  export   print the labels of the namespaces as NamespaceLabel manifests
+  verify   compare a manifest directory to the namespaces, exits 1 when they drifted (alias: import)
+  rollback rewrite the NamespaceLabels of a namespace to one of its NamespaceLabelSnapshots
+
+Run namespacelabel <command> -h for the flags of a command.
+`
+
+// the exit code of verify when the manifests and the namespaces differ, errors exit with 2
+const exitDrift = 1
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+	case "export":
+		err = export(os.Args[2:], os.Stdout)
+	case "verify", "import":
+		err = verify(os.Args[2:], os.Stdout)
+	case "rollback":
+		err = rollback(os.Args[2:], os.Stdout)
+	case "-h", "--help", "help":
+		fmt.Fprint(os.Stdout, usage)
+		return
+	default:
+		err = fmt.Errorf("unknown command %q\n\n%s", os.Args[1], usage)
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if exitErr, isExit := err.(exitError); isExit {
+		os.Exit(int(exitErr))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(2)
+	}
+}
+
+// exitError ends the command with its code and no message
+type exitError int
+
+func (e exitError) Error() string {
+	return fmt.Sprintf("exit code %d", int(e))
+}
+
+// sourceFlags are the flags selecting where the namespaces are read from and which labels are protected
+type sourceFlags struct {
+	kubeconfig             string
+	fromFile               string
+	protectedLabels        string
+	protectedLabelPrefixes string
+}
+
+func (f *sourceFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&f.kubeconfig, "kubeconfig", "", "Path to the kubeconfig, the default loading rules apply when empty.")
+	flags.StringVar(&f.fromFile, "from-file", "",
+		"Read the namespaces from a file saved by kubectl get namespaces -o yaml instead of the cluster, - reads stdin.")
+	flags.StringVar(&f.protectedLabels, "protected-labels", "", "list of protected labels, never exported")
+	flags.StringVar(&f.protectedLabelPrefixes, "protected-label-prefixes", "kubernetes.io/,k8s.io/",
+		"list of protected label key prefixes, never exported")
+}
+
+func (f *sourceFlags) policy() labelsync.Policy {
+	return labelsync.Policy{
+		ProtectedLabels:        splitList(f.protectedLabels),
+		ProtectedLabelPrefixes: splitList(f.protectedLabelPrefixes),
+	}
+}
+
+// namespaces reads the namespaces from the dump file or from the cluster
+func (f *sourceFlags) namespaces(ctx context.Context) ([]corev1.Namespace, error) {
+	if f.fromFile == "-" {
+		return gitops.ReadNamespaces(os.Stdin)
+	}
+	if f.fromFile != "" {
+		file, err := os.Open(f.fromFile)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return gitops.ReadNamespaces(file)
+	}
+
+	c, err := newClient(f.kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	var namespaceList corev1.NamespaceList
+	if err := c.List(ctx, &namespaceList); err != nil {
+		return nil, err
+	}
+	return namespaceList.Items, nil
+}
+
+// newClient returns a client of the cluster of the kubeconfig, the default loading rules apply when it is empty
+func newClient(kubeconfig string) (client.Client, error) {
+	var config *rest.Config
+	var err error
+	if kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	} else {
+		config, err = ctrl.GetConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	if err := omerv1.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	return client.New(config, client.Options{Scheme: scheme})
+}
+
+func export(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	var source sourceFlags
+	source.register(flags)
+	name := flags.String("name", "namespace-labels", "The name of the NamespaceLabel exported for every namespace.")
+	outputDir := flags.String("output-dir", "", "Write one <namespace>.yaml file per namespace to the directory instead of stdout.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	namespaces, err := source.namespaces(context.Background())
+	if err != nil {
+		return err
+	}
+	namespaceLabels := gitops.Export(namespaces, source.policy(), *name)
+	if *outputDir != "" {
+		return gitops.WriteDir(*outputDir, namespaceLabels)
+	}
+	content, err := gitops.Marshal(namespaceLabels)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(content)
+	return err
+}
+
+func verify(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	var source sourceFlags
+	source.register(flags)
+	manifests := flags.String("manifests", "", "The directory of the NamespaceLabel manifests, read recursively.")
+	unmanaged := flags.Bool("unmanaged", false,
+		"Also report the labels of every namespace that no manifest declares.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *manifests == "" {
+		return fmt.Errorf("--manifests is required")
+	}
+
+	namespaceLabels, err := gitops.ReadNamespaceLabels(*manifests)
+	if err != nil {
+		return err
+	}
+	namespaces, err := source.namespaces(context.Background())
+	if err != nil {
+		return err
+	}
+	drifts := gitops.Verify(namespaceLabels, namespaces, source.policy(), *unmanaged)
+	if len(drifts) == 0 {
+		fmt.Fprintf(out, "%d NamespaceLabels match the namespaces\n", len(namespaceLabels))
+		return nil
+	}
+
+	writer := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "NAMESPACE\tLABEL\tREASON\tWANT\tGOT")
+	for _, drift := range drifts {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", drift.Namespace, drift.Key, drift.Reason, drift.Want, drift.Got)
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return exitError(exitDrift)
+}
+
+// splitList parses a comma separated flag value, an empty value gives an empty list
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	omerv1 "omer.io/namespacelabel/api/v1"
+	"omer.io/namespacelabel/pkg/snapshot"
+)
+
+func rollback(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("rollback", flag.ContinueOnError)
+	kubeconfig := flags.String("kubeconfig", "", "Path to the kubeconfig, the default loading rules apply when empty.")
+	namespace := flags.String("namespace", "", "The namespace to roll back.")
+	snapshotName := flags.String("snapshot", "", "The name of the NamespaceLabelSnapshot to roll back to.")
+	before := flags.Duration("before", 0, "Roll back to the labels the namespace had this long ago, e.g. 24h.")
+	at := flags.String("at", "", "Roll back to the labels the namespace had at this RFC3339 time.")
+	prune := flags.Bool("prune", false, "Also delete the NamespaceLabels created after the snapshot.")
+	dryRun := flags.Bool("dry-run", false, "Only print the changes.")
+	list := flags.Bool("list", false, "List the snapshots of the namespace instead of rolling back.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *namespace == "" {
+		return fmt.Errorf("--namespace is required")
+	}
+
+	ctx := context.Background()
+	c, err := newClient(*kubeconfig)
+	if err != nil {
+		return err
+	}
+	var snapshotList omerv1.NamespaceLabelSnapshotList
+	if err := c.List(ctx, &snapshotList, client.InNamespace(*namespace)); err != nil {
+		return err
+	}
+	snapshots := snapshotList.Items
+	snapshot.Sort(snapshots)
+	if *list {
+		writer := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "SNAPSHOT\tTAKEN\tNAMESPACELABELS\tMANAGED LABELS")
+		for _, namespaceSnapshot := range snapshots {
+			fmt.Fprintf(writer, "%s\t%s\t%d\t%d\n", namespaceSnapshot.Name, namespaceSnapshot.CreationTimestamp.UTC().Format(time.RFC3339),
+				len(namespaceSnapshot.Spec.NamespaceLabels), len(namespaceSnapshot.Spec.ManagedLabels))
+		}
+		return writer.Flush()
+	}
+
+	target, err := selectSnapshot(snapshots, *snapshotName, *before, *at)
+	if err != nil {
+		return err
+	}
+	var namespaceLabelList omerv1.NamespaceLabelList
+	if err := c.List(ctx, &namespaceLabelList, client.InNamespace(*namespace)); err != nil {
+		return err
+	}
+	changes := snapshot.Rollback(*target, namespaceLabelList.Items, *prune)
+	fmt.Fprintf(out, "rolling back namespace %s to %s, taken at %s\n", *namespace, target.Name,
+		target.CreationTimestamp.UTC().Format(time.RFC3339))
+	if len(changes) == 0 {
+		fmt.Fprintln(out, "the NamespaceLabels already match the snapshot")
+		return nil
+	}
+
+	writer := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "NAMESPACELABEL\tACTION\tFROM\tTO")
+	for _, change := range changes {
+		to := labels.Set(change.NamespaceLabel.Spec.Labels).String()
+		if change.Action == snapshot.ActionDelete {
+			to = ""
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", change.NamespaceLabel.Name, change.Action, labels.Set(change.Previous).String(), to)
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if *dryRun {
+		return nil
+	}
+
+	//an update is sent with the resource version that was read, a NamespaceLabel changed meanwhile fails
+	//the rollback, which is run again
+	for _, change := range changes {
+		namespaceLabel := change.NamespaceLabel
+		switch change.Action {
+		case snapshot.ActionUpdate:
+			err = c.Update(ctx, &namespaceLabel)
+		case snapshot.ActionCreate:
+			err = c.Create(ctx, &namespaceLabel)
+		case snapshot.ActionDelete:
+			err = client.IgnoreNotFound(c.Delete(ctx, &namespaceLabel))
+		}
+		if err != nil {
+			return fmt.Errorf("unable to %s NamespaceLabel %s: %w", change.Action, namespaceLabel.Name, err)
+		}
+	}
+	fmt.Fprintf(out, "%d NamespaceLabels rolled back\n", len(changes))
+	return nil
+}
+
+// selectSnapshot returns the snapshot named by the flags, snapshots are sorted
+func selectSnapshot(snapshots []omerv1.NamespaceLabelSnapshot, name string, before time.Duration, at string) (*omerv1.NamespaceLabelSnapshot, error) {
+	switch {
+	case name != "":
+		for i := range snapshots {
+			if snapshots[i].Name == name {
+				return &snapshots[i], nil
+			}
+		}
+		return nil, fmt.Errorf("snapshot %s not found", name)
+	case before > 0 || at != "":
+		atTime := time.Now().Add(-before)
+		if at != "" {
+			var err error
+			if atTime, err = time.Parse(time.RFC3339, at); err != nil {
+				return nil, fmt.Errorf("invalid --at: %w", err)
+			}
+		}
+		target := snapshot.At(snapshots, atTime)
+		if target == nil {
+			return nil, fmt.Errorf("no snapshot taken before %s", atTime.UTC().Format(time.RFC3339))
+		}
+		return target, nil
+	default:
+		return nil, fmt.Errorf("one of --snapshot, --before or --at is required")
+	}
+}
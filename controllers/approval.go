@@ -0,0 +1,61 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	omerv1 "omer.io/namespacelabel/api/v1"
+)
+
+//+kubebuilder:rbac:groups=omer.omer.io,resources=labelchangeapprovals,verbs=get;list;watch
+
+// approvals holds the nslabel generations approved by the LabelChangeApprovals of a namespace
+type approvals map[approvalKey]bool
+
+// an approval names the nslabel by name and uid, an nslabel created again with the same name starts over
+// at generation 1 and must not match the approvals of the deleted one
+type approvalKey struct {
+	name       string
+	uid        types.UID
+	generation int64
+}
+
+// the function return true if the current generation of the nslabel is approved
+func (a approvals) isApproved(namespaceLabel omerv1.NamespaceLabel) bool {
+	return a[approvalKey{name: namespaceLabel.Name, uid: namespaceLabel.UID, generation: namespaceLabel.Generation}]
+}
+
+// the function return the nslabel generations approved by the LabelChangeApprovals of the namespace
+func (r *NamespaceLabelReconciler) approvalsOf(ctx context.Context, namespace string) (approvals, error) {
+	var approvalList omerv1.LabelChangeApprovalList
+	if err := r.List(ctx, &approvalList, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	approved := make(approvals)
+	for _, approval := range approvalList.Items {
+		approved[approvalKey{
+			name:       approval.Spec.NamespaceLabel,
+			uid:        approval.Spec.NamespaceLabelUID,
+			generation: approval.Spec.Generation,
+		}] = true
+	}
+	return approved, nil
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	omerv1 "omer.io/namespacelabel/api/v1"
+	"omer.io/namespacelabel/pkg/labelsync"
+)
+
+//+kubebuilder:rbac:groups=omer.omer.io,resources=namespacelabelrules,verbs=get;list;watch
+
+// derived labels are owned by rule:<name> of their rule, the names of nslabels have no colon
+const derivedOwnerPrefix = "rule:"
+
+// the derived labels lose to the nslabels of the namespace and to the labels it inherits
+const derivedDepth = math.MaxInt32
+
+func derivedOwner(name string) string {
+	return derivedOwnerPrefix + name
+}
+
+func isDerivedOwner(owner string) bool {
+	return strings.HasPrefix(owner, derivedOwnerPrefix)
+}
+
+// the pattern has to match the whole namespace name
+func compileNamePattern(rule omerv1.NamespaceLabelRule) (*regexp.Regexp, error) {
+	if rule.Spec.NamePattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile("^(?:" + rule.Spec.NamePattern + ")$")
+}
+
+// the function return the labels the rule derives for the namespace, nil when the name does not match the pattern.
+// the labels with an invalid key or value are left out and returned in the error with the valid labels, an
+// invalid pattern derives no labels at all
+func deriveLabels(rule omerv1.NamespaceLabelRule, namespace *v1.Namespace) (map[string]string, error) {
+	pattern, err := compileNamePattern(rule)
+	if err != nil {
+		return nil, fmt.Errorf("invalid name pattern of rule %s: %w", rule.Name, err)
+	}
+
+	labels := make(map[string]string)
+	if pattern != nil {
+		match := pattern.FindStringSubmatchIndex(namespace.Name)
+		if match == nil {
+			return nil, nil
+		}
+		for key, template := range rule.Spec.Labels {
+			labels[key] = string(pattern.ExpandString(nil, template, namespace.Name, match))
+		}
+		if len(rule.Spec.Labels) == 0 {
+			//an optional group that did not take part in the match has no label
+			for i, group := range pattern.SubexpNames() {
+				if group != "" && match[2*i] >= 0 {
+					labels[group] = namespace.Name[match[2*i]:match[2*i+1]]
+				}
+			}
+		}
+	} else {
+		for key, value := range rule.Spec.Labels {
+			labels[key] = value
+		}
+	}
+	for annotation, key := range rule.Spec.FromAnnotations {
+		if value, isExist := namespace.Annotations[annotation]; isExist {
+			labels[key] = value
+		}
+	}
+
+	var errs []error
+	for key, value := range labels {
+		if messages := append(validation.IsQualifiedName(key), validation.IsValidLabelValue(value)...); len(messages) > 0 {
+			errs = append(errs, fmt.Errorf("rule %s derived an invalid label %s=%q: %v", rule.Name, key, value, messages))
+			delete(labels, key)
+		}
+	}
+	return labels, utilerrors.NewAggregate(errs)
+}
+
+// the function return the label keys the rule may derive, for the namespace updates that have to be reconciled
+func derivedLabelKeys(rule omerv1.NamespaceLabelRule) []string {
+	var keys []string
+	for key := range rule.Spec.Labels {
+		keys = append(keys, key)
+	}
+	if pattern, err := compileNamePattern(rule); err == nil && pattern != nil && len(rule.Spec.Labels) == 0 {
+		for _, group := range pattern.SubexpNames() {
+			if group != "" {
+				keys = append(keys, group)
+			}
+		}
+	}
+	for _, key := range rule.Spec.FromAnnotations {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// the function return the labels the rules derive for the namespace as sources of the namespace plan. a label
+// derived before whose rule is gone or does not derive it anymore is removed like the labels of a deleted nslabel
+func (r *NamespaceLabelReconciler) derivedSources(ctx context.Context, namespace *v1.Namespace, managedLabels map[string]string) ([]labelsync.Source, error) {
+	logger := ctrllog.FromContext(ctx)
+
+	var ruleList omerv1.NamespaceLabelRuleList
+	if err := r.List(ctx, &ruleList); err != nil {
+		return nil, err
+	}
+	var sources []labelsync.Source
+	isSource := make(map[string]bool)
+	for _, rule := range ruleList.Items {
+		labels, err := deriveLabels(rule, namespace)
+		if err != nil {
+			logger.Error(err, "unable to derive all the labels of the rule", "namespace", namespace.Name, "rule", rule.Name)
+		}
+		if len(labels) == 0 {
+			continue
+		}
+		name := derivedOwner(rule.Name)
+		sources = append(sources, labelsync.Source{
+			Name:              name,
+			Depth:             derivedDepth,
+			CreationTimestamp: rule.CreationTimestamp.Time,
+			Labels:            labels,
+		})
+		isSource[name] = true
+	}
+	for _, owner := range managedLabels {
+		if isDerivedOwner(owner) && !isSource[owner] {
+			sources = append(sources, labelsync.Source{Name: owner, Deleting: true})
+			isSource[owner] = true
+		}
+	}
+	return sources, nil
+}
+
+// a rule event reconciles every namespace, any of them may match it
+func (r *NamespaceLabelReconciler) namespacesOfNamespaceLabelRule(rule client.Object) []reconcile.Request {
+	var namespaceList v1.NamespaceList
+	if err := r.List(context.Background(), &namespaceList); err != nil {
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(namespaceList.Items))
+	for _, namespace := range namespaceList.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: namespace.Name}})
+	}
+	return requests
+}
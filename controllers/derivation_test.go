@@ -0,0 +1,114 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	omerv1 "omer.io/namespacelabel/api/v1"
+)
+
+var _ = Describe("Label derivation", func() {
+	const convention = `(?P<team>[a-z]+)-(?P<env>dev|prod)(-(?P<app>[a-z0-9-]+))?`
+
+	DescribeTable("Should derive the labels of a rule from the namespace",
+		func(spec omerv1.NamespaceLabelRuleSpec, name string, annotations map[string]string, labels map[string]string, isError bool) {
+			rule := omerv1.NamespaceLabelRule{ObjectMeta: metav1.ObjectMeta{Name: "rule"}, Spec: spec}
+			namespace := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations}}
+			derived, err := deriveLabels(rule, namespace)
+			if isError {
+				Expect(err).Should(HaveOccurred())
+			} else {
+				Expect(err).ShouldNot(HaveOccurred())
+			}
+			if len(labels) == 0 {
+				Expect(derived).Should(BeEmpty())
+			} else {
+				Expect(derived).Should(Equal(labels))
+			}
+		},
+		Entry("named capture groups are labels",
+			omerv1.NamespaceLabelRuleSpec{NamePattern: convention}, "payments-prod-api", nil,
+			map[string]string{"team": "payments", "env": "prod", "app": "api"}, false),
+		Entry("optional group that did not match",
+			omerv1.NamespaceLabelRuleSpec{NamePattern: convention}, "payments-dev", nil,
+			map[string]string{"team": "payments", "env": "dev"}, false),
+		Entry("pattern must match the whole name",
+			omerv1.NamespaceLabelRuleSpec{NamePattern: `(?P<team>[a-z]+)-prod`}, "payments-prod-api", nil,
+			nil, false),
+		Entry("label templates",
+			omerv1.NamespaceLabelRuleSpec{
+				NamePattern: convention,
+				Labels:      map[string]string{"omer.io/team": "${team}", "omer.io/tier": "${env}-tier", "fixed": "yes"},
+			}, "payments-prod", nil,
+			map[string]string{"omer.io/team": "payments", "omer.io/tier": "prod-tier", "fixed": "yes"}, false),
+		Entry("annotations without a pattern",
+			omerv1.NamespaceLabelRuleSpec{FromAnnotations: map[string]string{"omer.io/owner": "owner", "omer.io/missing": "missing"}},
+			"anything", map[string]string{"omer.io/owner": "alice"},
+			map[string]string{"owner": "alice"}, false),
+		Entry("invalid label value is left out",
+			omerv1.NamespaceLabelRuleSpec{FromAnnotations: map[string]string{"omer.io/owner": "owner", "omer.io/team": "team"}},
+			"anything", map[string]string{"omer.io/owner": "alice@omer.io", "omer.io/team": "payments"},
+			map[string]string{"team": "payments"}, true),
+		Entry("invalid pattern",
+			omerv1.NamespaceLabelRuleSpec{NamePattern: `(?P<team>[a-z]+`}, "payments", nil,
+			nil, true),
+	)
+
+	Context("When rules are listed for a namespace", func() {
+		It("Should build a source per matching rule and deleting sources for the rules that stopped deriving labels", func() {
+			scheme := runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).Should(Succeed())
+			Expect(omerv1.AddToScheme(scheme)).Should(Succeed())
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+				&omerv1.NamespaceLabelRule{
+					ObjectMeta: metav1.ObjectMeta{Name: "convention"},
+					Spec:       omerv1.NamespaceLabelRuleSpec{NamePattern: `(?P<team>[a-z]+)-(?P<env>[a-z]+)`},
+				},
+				&omerv1.NamespaceLabelRule{
+					ObjectMeta: metav1.ObjectMeta{Name: "other"},
+					Spec:       omerv1.NamespaceLabelRuleSpec{NamePattern: `other-.*`, Labels: map[string]string{"other": "yes"}},
+				},
+			).Build()
+			r := &NamespaceLabelReconciler{Client: c}
+
+			namespace := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "payments-prod"}}
+			sources, err := r.derivedSources(context.Background(), namespace,
+				map[string]string{"team": "rule:convention", "other": "rule:other", "old": "rule:gone", "local": "a"})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(sources).Should(HaveLen(3))
+			Expect(sources[0].Name).Should(Equal("rule:convention"))
+			Expect(sources[0].Depth).Should(Equal(derivedDepth))
+			Expect(sources[0].Labels).Should(Equal(map[string]string{"team": "payments", "env": "prod"}))
+
+			By("Deleting the labels of a rule that does not match anymore and of a deleted rule")
+			deleting := map[string]bool{}
+			for _, source := range sources[1:] {
+				deleting[source.Name] = source.Deleting
+			}
+			Expect(deleting).Should(Equal(map[string]bool{"rule:other": true, "rule:gone": true}))
+		})
+	})
+})
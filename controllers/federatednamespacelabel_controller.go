@@ -0,0 +1,400 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/clock"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	omerv1 "omer.io/namespacelabel/api/v1"
+	"omer.io/namespacelabel/pkg/hub"
+)
+
+const (
+	// the finalizer of the fednslabel, the nslabels of the clusters are deleted before it is released
+	federationFinalizer = "namespacelabel.omer.io/federation"
+	// the annotation marking the nslabels of a cluster written by the hub, its value is namespace/name of the fednslabel
+	federatedFromAnnotation = "namespacelabel.omer.io/federated-from"
+)
+
+// FederatedNamespaceLabelReconciler runs in the hub cluster. It keeps a NamespaceLabel with the spec
+// template of every FederatedNamespaceLabel in the selected clusters, and aggregates their status back.
+// The clusters are not watched, they are read again every ResyncPeriod.
+type FederatedNamespaceLabelReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Clusters *hub.Registry
+	// ResyncPeriod is the time between two reads of the clusters, 1m when zero
+	ResyncPeriod time.Duration
+	// WithdrawTimeout is how long a deleted fednslabel waits for the clusters its NamespaceLabel can not be
+	// deleted from, it is released afterwards and the NamespaceLabels are left behind. No wait when zero
+	WithdrawTimeout time.Duration
+	// Clock measures the wait of the deleted fednslabels, the real clock when nil
+	Clock clock.PassiveClock
+}
+
+//+kubebuilder:rbac:groups=omer.omer.io,resources=federatednamespacelabels,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=omer.omer.io,resources=federatednamespacelabels/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=omer.omer.io,resources=federatednamespacelabels/finalizers,verbs=update
+//+kubebuilder:rbac:groups=core,namespace=system,resources=secrets,verbs=get;list
+
+func (r *FederatedNamespaceLabelReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := ctrllog.FromContext(ctx)
+
+	var fedNamespaceLabel omerv1.FederatedNamespaceLabel
+	if err := r.Get(ctx, req.NamespacedName, &fedNamespaceLabel); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	clusters, err := r.Clusters.Clusters(ctx)
+	if err != nil {
+		logger.Error(err, "unable to list the registered clusters")
+		return ctrl.Result{}, err
+	}
+
+	//the nslabels of every cluster are deleted before the fednslabel is released. a cluster that can not be
+	//reached, or is not registered anymore, holds the fednslabel until the withdraw timeout at most
+	if !fedNamespaceLabel.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(&fedNamespaceLabel, federationFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		var orphans []omerv1.ClusterStatus
+		for _, cluster := range clusters {
+			if err := r.withdraw(ctx, cluster, fedNamespaceLabel); err != nil {
+				orphans = append(orphans, orphanedStatus(cluster.Name, "unable to delete the NamespaceLabel: "+err.Error()))
+			}
+		}
+		orphans = append(orphans, unregisteredClusters(fedNamespaceLabel.Status, clusters)...)
+		waited := r.clock().Since(fedNamespaceLabel.DeletionTimestamp.Time)
+		if len(orphans) > 0 && waited < r.WithdrawTimeout {
+			if err := r.updateFederatedStatus(ctx, fedNamespaceLabel, orphans); err != nil {
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{RequeueAfter: r.resyncPeriod()}, nil
+		}
+		if len(orphans) > 0 {
+			logger.Info("withdraw timeout passed, releasing the FederatedNamespaceLabel and leaving its NamespaceLabels behind",
+				"clusters", clusterNames(orphans))
+		}
+		return ctrl.Result{}, r.patchFederationFinalizer(ctx, &fedNamespaceLabel, false)
+	}
+	if err := r.patchFederationFinalizer(ctx, &fedNamespaceLabel, true); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	//propagate to the selected clusters in parallel, one slow cluster must not hold the others
+	selected := selectClusters(clusters, fedNamespaceLabel.Spec.Clusters)
+	statuses := make([]omerv1.ClusterStatus, len(selected))
+	var wg sync.WaitGroup
+	for i, cluster := range selected {
+		wg.Add(1)
+		go func(i int, cluster hub.Cluster) {
+			defer wg.Done()
+			statuses[i] = r.propagate(ctx, cluster, fedNamespaceLabel)
+		}(i, cluster)
+	}
+	wg.Wait()
+
+	//clusters that are not selected anymore keep their status entry until their nslabel is deleted
+	isSelected := make(map[string]bool, len(selected))
+	for _, cluster := range selected {
+		isSelected[cluster.Name] = true
+	}
+	for _, cluster := range clusters {
+		if isSelected[cluster.Name] || !hasClusterStatus(fedNamespaceLabel.Status, cluster.Name) {
+			continue
+		}
+		if err := r.withdraw(ctx, cluster, fedNamespaceLabel); err != nil {
+			statuses = append(statuses, orphanedStatus(cluster.Name, "unable to delete the NamespaceLabel: "+err.Error()))
+		}
+	}
+	//a cluster whose Secret was removed can not be reached anymore, its nslabel is reported as left behind
+	for _, status := range unregisteredClusters(fedNamespaceLabel.Status, clusters) {
+		if !isSelected[status.Name] {
+			statuses = append(statuses, status)
+		}
+	}
+	for _, status := range statuses {
+		if !status.Propagated {
+			logger.Info("NamespaceLabel not propagated", "cluster", status.Name, "message", status.Message)
+		}
+	}
+
+	if err := r.updateFederatedStatus(ctx, fedNamespaceLabel, statuses); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: r.resyncPeriod()}, nil
+}
+
+func (r *FederatedNamespaceLabelReconciler) resyncPeriod() time.Duration {
+	if r.ResyncPeriod <= 0 {
+		return time.Minute
+	}
+	return r.ResyncPeriod
+}
+
+// the function return the registered clusters the fednslabel propagates to, all of them when no names are listed.
+// a listed cluster that is not registered gets a cluster with an error, so it is reported in the status
+func selectClusters(clusters []hub.Cluster, names []string) []hub.Cluster {
+	if len(names) == 0 {
+		return clusters
+	}
+	registered := make(map[string]hub.Cluster, len(clusters))
+	for _, cluster := range clusters {
+		registered[cluster.Name] = cluster
+	}
+	selected := make([]hub.Cluster, 0, len(names))
+	for _, name := range names {
+		cluster, isFound := registered[name]
+		if !isFound {
+			cluster = hub.Cluster{Name: name, Err: fmt.Errorf("cluster is not registered in the hub")}
+		}
+		selected = append(selected, cluster)
+	}
+	return selected
+}
+
+// the function return an orphaned status for every cluster of the status that is not registered anymore
+func unregisteredClusters(status omerv1.FederatedNamespaceLabelStatus, clusters []hub.Cluster) []omerv1.ClusterStatus {
+	registered := make(map[string]bool, len(clusters))
+	for _, cluster := range clusters {
+		registered[cluster.Name] = true
+	}
+	var orphans []omerv1.ClusterStatus
+	for _, clusterStatus := range status.Clusters {
+		if !registered[clusterStatus.Name] {
+			orphans = append(orphans, orphanedStatus(clusterStatus.Name,
+				"the cluster is not registered in the hub anymore, its NamespaceLabel is left behind"))
+		}
+	}
+	return orphans
+}
+
+func orphanedStatus(name string, message string) omerv1.ClusterStatus {
+	return omerv1.ClusterStatus{Name: name, Orphaned: true, Message: message}
+}
+
+func clusterNames(statuses []omerv1.ClusterStatus) []string {
+	names := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		names = append(names, status.Name)
+	}
+	return names
+}
+
+func (r *FederatedNamespaceLabelReconciler) clock() clock.PassiveClock {
+	if r.Clock == nil {
+		return clock.RealClock{}
+	}
+	return r.Clock
+}
+
+func hasClusterStatus(status omerv1.FederatedNamespaceLabelStatus, name string) bool {
+	for _, clusterStatus := range status.Clusters {
+		if clusterStatus.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// the value of the federated-from annotation of the nslabels written for the fednslabel
+func federatedFrom(fedNamespaceLabel omerv1.FederatedNamespaceLabel) string {
+	return fedNamespaceLabel.Namespace + "/" + fedNamespaceLabel.Name
+}
+
+// the function write the template of the fednslabel to the nslabel of the cluster and return the cluster status.
+// a nslabel with the same name that was not written by the hub is left untouched
+func (r *FederatedNamespaceLabelReconciler) propagate(ctx context.Context, cluster hub.Cluster, fedNamespaceLabel omerv1.FederatedNamespaceLabel) omerv1.ClusterStatus {
+	status := omerv1.ClusterStatus{Name: cluster.Name}
+	if cluster.Err != nil {
+		status.Message = cluster.Err.Error()
+		return status
+	}
+
+	var namespaceLabel omerv1.NamespaceLabel
+	err := cluster.Client.Get(ctx, client.ObjectKeyFromObject(&fedNamespaceLabel), &namespaceLabel)
+	switch {
+	case apierrors.IsNotFound(err):
+		namespaceLabel = omerv1.NamespaceLabel{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        fedNamespaceLabel.Name,
+				Namespace:   fedNamespaceLabel.Namespace,
+				Annotations: map[string]string{federatedFromAnnotation: federatedFrom(fedNamespaceLabel)},
+			},
+			Spec: *fedNamespaceLabel.Spec.Template.DeepCopy(),
+		}
+		err = cluster.Client.Create(ctx, &namespaceLabel)
+	case err != nil:
+	case namespaceLabel.Annotations[federatedFromAnnotation] != federatedFrom(fedNamespaceLabel):
+		status.Message = "a NamespaceLabel with the same name exists and was not propagated by the hub"
+		return status
+	case !equality.Semantic.DeepEqual(namespaceLabel.Spec, fedNamespaceLabel.Spec.Template):
+		namespaceLabel.Spec = *fedNamespaceLabel.Spec.Template.DeepCopy()
+		err = cluster.Client.Update(ctx, &namespaceLabel)
+	}
+	if err != nil {
+		status.Message = err.Error()
+		return status
+	}
+
+	status.Propagated = true
+	status.Synced = metav1.ConditionUnknown
+	condition := meta.FindStatusCondition(namespaceLabel.Status.Conditions, omerv1.ConditionTypeSynced)
+	if condition != nil && condition.ObservedGeneration == namespaceLabel.Generation {
+		status.Synced = condition.Status
+		status.SyncLabels = namespaceLabel.Status.SyncLabels
+		status.UnSyncLabels = namespaceLabel.Status.UnSyncLabels
+	}
+	return status
+}
+
+// the function delete the nslabel the hub wrote to the cluster for the fednslabel, if there is one
+func (r *FederatedNamespaceLabelReconciler) withdraw(ctx context.Context, cluster hub.Cluster, fedNamespaceLabel omerv1.FederatedNamespaceLabel) error {
+	if cluster.Err != nil {
+		return cluster.Err
+	}
+	var namespaceLabel omerv1.NamespaceLabel
+	if err := cluster.Client.Get(ctx, client.ObjectKeyFromObject(&fedNamespaceLabel), &namespaceLabel); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if namespaceLabel.Annotations[federatedFromAnnotation] != federatedFrom(fedNamespaceLabel) {
+		return nil
+	}
+	return client.IgnoreNotFound(cluster.Client.Delete(ctx, &namespaceLabel, client.Preconditions{UID: &namespaceLabel.UID}))
+}
+
+// the function write the cluster statuses and their summary conditions, nothing is written when the status is unchanged
+func (r *FederatedNamespaceLabelReconciler) updateFederatedStatus(ctx context.Context, fedNamespaceLabel omerv1.FederatedNamespaceLabel, clusterStatuses []omerv1.ClusterStatus) error {
+	sort.Slice(clusterStatuses, func(i, j int) bool { return clusterStatuses[i].Name < clusterStatuses[j].Name })
+
+	status := *fedNamespaceLabel.Status.DeepCopy()
+	status.ObservedGeneration = fedNamespaceLabel.Generation
+	status.Clusters = nil
+	if len(clusterStatuses) > 0 {
+		status.Clusters = clusterStatuses
+	}
+
+	var notPropagated, notSynced, orphaned []string
+	for _, clusterStatus := range clusterStatuses {
+		//the orphaned clusters are not selected anymore, they only count for the Orphaned condition
+		if clusterStatus.Orphaned {
+			orphaned = append(orphaned, clusterStatus.Name)
+			continue
+		}
+		if !clusterStatus.Propagated {
+			notPropagated = append(notPropagated, clusterStatus.Name)
+		}
+		if clusterStatus.Synced != metav1.ConditionTrue {
+			notSynced = append(notSynced, clusterStatus.Name)
+		}
+	}
+	propagated := metav1.Condition{
+		Type:               omerv1.ConditionTypePropagated,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: fedNamespaceLabel.Generation,
+		Reason:             omerv1.ReasonPropagated,
+		Message:            "propagated to every selected cluster",
+	}
+	if len(notPropagated) > 0 {
+		propagated.Status = metav1.ConditionFalse
+		propagated.Reason = omerv1.ReasonPropagationFailed
+		propagated.Message = "not propagated to clusters " + strings.Join(notPropagated, ", ")
+	}
+	synced := metav1.Condition{
+		Type:               omerv1.ConditionTypeSynced,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: fedNamespaceLabel.Generation,
+		Reason:             omerv1.ReasonSynced,
+		Message:            "all the labels are synced in every cluster",
+	}
+	if len(notSynced) > 0 {
+		synced.Status = metav1.ConditionFalse
+		synced.Reason = omerv1.ReasonClustersNotSynced
+		synced.Message = "labels not synced in clusters " + strings.Join(notSynced, ", ")
+	}
+	meta.SetStatusCondition(&status.Conditions, propagated)
+	meta.SetStatusCondition(&status.Conditions, synced)
+	//the fednslabels that never left a NamespaceLabel behind do not carry the condition
+	if len(orphaned) > 0 || meta.FindStatusCondition(status.Conditions, omerv1.ConditionTypeOrphaned) != nil {
+		orphanedCondition := metav1.Condition{
+			Type:               omerv1.ConditionTypeOrphaned,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: fedNamespaceLabel.Generation,
+			Reason:             omerv1.ReasonNoOrphans,
+			Message:            "no NamespaceLabel is left behind",
+		}
+		if len(orphaned) > 0 {
+			orphanedCondition.Status = metav1.ConditionTrue
+			orphanedCondition.Reason = omerv1.ReasonClustersUnreachable
+			orphanedCondition.Message = "NamespaceLabels left behind in clusters " + strings.Join(orphaned, ", ")
+		}
+		meta.SetStatusCondition(&status.Conditions, orphanedCondition)
+	}
+
+	if equality.Semantic.DeepEqual(status, fedNamespaceLabel.Status) {
+		return nil
+	}
+	patch := client.MergeFromWithOptions(fedNamespaceLabel.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	fedNamespaceLabel.Status = status
+	if err := r.Status().Patch(ctx, &fedNamespaceLabel, patch); err != nil {
+		ctrllog.FromContext(ctx).Error(err, "unable to update status of federatedNamespaceLabel")
+		return client.IgnoreNotFound(err)
+	}
+	return nil
+}
+
+// the function add or remove the finalizer of the fednslabel, a conflict is retried by the next reconcile
+func (r *FederatedNamespaceLabelReconciler) patchFederationFinalizer(ctx context.Context, fedNamespaceLabel *omerv1.FederatedNamespaceLabel, add bool) error {
+	if controllerutil.ContainsFinalizer(fedNamespaceLabel, federationFinalizer) == add {
+		return nil
+	}
+	patch := client.MergeFromWithOptions(fedNamespaceLabel.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	if add {
+		controllerutil.AddFinalizer(fedNamespaceLabel, federationFinalizer)
+	} else {
+		controllerutil.RemoveFinalizer(fedNamespaceLabel, federationFinalizer)
+	}
+	return client.IgnoreNotFound(r.Patch(ctx, fedNamespaceLabel, patch))
+}
+
+// SetupWithManager sets up the controller with the Manager.
+// Like for the nslabels, only spec changes and the start of the deletion are reconciled
+func (r *FederatedNamespaceLabelReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&omerv1.FederatedNamespaceLabel{}, builder.WithPredicates(countingPredicate{
+			Predicate: namespaceLabelPredicate(),
+			kind:      "FederatedNamespaceLabel",
+		})).
+		Complete(r)
+}
@@ -0,0 +1,294 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	clocktesting "k8s.io/utils/clock/testing"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+
+	omerv1 "omer.io/namespacelabel/api/v1"
+	"omer.io/namespacelabel/pkg/hub"
+)
+
+// spokeCluster is an envtest API server standing in for a cluster registered in the hub,
+// it runs its own NamespaceLabel controller
+type spokeCluster struct {
+	testEnv *envtest.Environment
+	client  client.Client
+	cancel  context.CancelFunc
+}
+
+func startSpokeCluster() *spokeCluster {
+	spoke := &spokeCluster{testEnv: &envtest.Environment{
+		CRDDirectoryPaths:     []string{filepath.Join("..", "config", "crd", "bases")},
+		ErrorIfCRDPathMissing: true,
+	}}
+	spokeCfg, err := spoke.testEnv.Start()
+	Expect(err).NotTo(HaveOccurred())
+	spoke.client, err = client.New(spokeCfg, client.Options{Scheme: scheme.Scheme})
+	Expect(err).NotTo(HaveOccurred())
+
+	spokeManager, err := ctrl.NewManager(spokeCfg, ctrl.Options{Scheme: scheme.Scheme, MetricsBindAddress: "0"})
+	Expect(err).NotTo(HaveOccurred())
+	Expect((&NamespaceLabelReconciler{
+		Client: spokeManager.GetClient(),
+		Scheme: spokeManager.GetScheme(),
+	}).SetupWithManager(spokeManager)).Should(Succeed())
+
+	var spokeCtx context.Context
+	spokeCtx, spoke.cancel = context.WithCancel(context.Background())
+	go func() {
+		defer GinkgoRecover()
+		Expect(spokeManager.Start(spokeCtx)).Should(Succeed())
+	}()
+	return spoke
+}
+
+func (s *spokeCluster) stop() {
+	s.cancel()
+	Expect(s.testEnv.Stop()).Should(Succeed())
+}
+
+// spokeClientFactory hands out the clients of the spoke clusters by the name of their secret
+type spokeClientFactory map[string]*spokeCluster
+
+func (f spokeClientFactory) NewClient(cluster string, _ []byte) (client.Client, error) {
+	return f[cluster].client, nil
+}
+
+var _ = Describe("FederatedNamespaceLabel controller", Ordered, func() {
+
+	const name = "team"
+
+	var (
+		ctx            context.Context
+		namespace      string
+		spokes         spokeClientFactory
+		reconciler     *FederatedNamespaceLabelReconciler
+		fedNamespaceNN types.NamespacedName
+	)
+
+	BeforeAll(func() {
+		ctx = context.Background()
+		spokes = spokeClientFactory{"east": startSpokeCluster(), "west": startSpokeCluster()}
+
+		By("Registering the spoke clusters in the hub")
+		secretNamespace := createNamespace(ctx, nil)
+		for clusterName := range spokes {
+			Expect(k8sClient.Create(ctx, &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      clusterName,
+					Namespace: secretNamespace,
+					Labels:    map[string]string{hub.ClusterSecretLabel: "true"},
+				},
+				Data: map[string][]byte{hub.KubeconfigKey: []byte("unused by the test factory")},
+			})).Should(Succeed())
+		}
+		reconciler = &FederatedNamespaceLabelReconciler{
+			Client:   k8sClient,
+			Scheme:   scheme.Scheme,
+			Clusters: &hub.Registry{Reader: k8sClient, Namespace: secretNamespace, Factory: spokes},
+		}
+
+		By("Creating the namespace in the hub and in every spoke")
+		namespace = createNamespace(ctx, nil)
+		for _, spoke := range spokes {
+			Expect(spoke.client.Create(ctx, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}})).Should(Succeed())
+		}
+		fedNamespaceNN = types.NamespacedName{Namespace: namespace, Name: name}
+	})
+
+	AfterAll(func() {
+		for _, spoke := range spokes {
+			spoke.stop()
+		}
+	})
+
+	// reconcile until the status of the fednslabel has the condition for its current generation
+	expectFederated := func(conditionType string, status metav1.ConditionStatus) omerv1.FederatedNamespaceLabel {
+		var fedNamespaceLabel omerv1.FederatedNamespaceLabel
+		Eventually(func(g Gomega) {
+			_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: fedNamespaceNN})
+			g.Expect(err).ShouldNot(HaveOccurred())
+			g.Expect(k8sClient.Get(ctx, fedNamespaceNN, &fedNamespaceLabel)).Should(Succeed())
+			condition := meta.FindStatusCondition(fedNamespaceLabel.Status.Conditions, conditionType)
+			g.Expect(condition).ShouldNot(BeNil())
+			g.Expect(condition.ObservedGeneration).Should(Equal(fedNamespaceLabel.Generation))
+			g.Expect(condition.Status).Should(Equal(status))
+		}, timeout, interval).Should(Succeed())
+		return fedNamespaceLabel
+	}
+
+	spokeNamespaceLabels := func(clusterName string) map[string]string {
+		var namespaceObj v1.Namespace
+		Expect(spokes[clusterName].client.Get(ctx, types.NamespacedName{Name: namespace}, &namespaceObj)).Should(Succeed())
+		return namespaceObj.GetLabels()
+	}
+
+	It("Should propagate the template to every cluster and aggregate their status", func() {
+		Expect(k8sClient.Create(ctx, &omerv1.FederatedNamespaceLabel{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec: omerv1.FederatedNamespaceLabelSpec{
+				Template: omerv1.NamespaceLabelSpec{Labels: map[string]string{"team": "platform"}},
+			},
+		})).Should(Succeed())
+
+		fedNamespaceLabel := expectFederated(omerv1.ConditionTypeSynced, metav1.ConditionTrue)
+		Expect(fedNamespaceLabel.Finalizers).Should(ContainElement(federationFinalizer))
+		Expect(meta.IsStatusConditionTrue(fedNamespaceLabel.Status.Conditions, omerv1.ConditionTypePropagated)).Should(BeTrue())
+		Expect(fedNamespaceLabel.Status.Clusters).Should(HaveLen(2))
+		for _, clusterStatus := range fedNamespaceLabel.Status.Clusters {
+			Expect(clusterStatus.Propagated).Should(BeTrue())
+			Expect(clusterStatus.SyncLabels).Should(Equal(map[string]string{"team": "platform"}))
+			Expect(spokeNamespaceLabels(clusterStatus.Name)).Should(HaveKeyWithValue("team", "platform"))
+		}
+	})
+
+	It("Should withdraw the NamespaceLabel from the clusters that are not selected anymore", func() {
+		Eventually(func() error {
+			var fedNamespaceLabel omerv1.FederatedNamespaceLabel
+			if err := k8sClient.Get(ctx, fedNamespaceNN, &fedNamespaceLabel); err != nil {
+				return err
+			}
+			fedNamespaceLabel.Spec.Clusters = []string{"east"}
+			return k8sClient.Update(ctx, &fedNamespaceLabel)
+		}, timeout, interval).Should(Succeed())
+
+		fedNamespaceLabel := expectFederated(omerv1.ConditionTypeSynced, metav1.ConditionTrue)
+		Expect(fedNamespaceLabel.Status.Clusters).Should(HaveLen(1))
+		Expect(fedNamespaceLabel.Status.Clusters[0].Name).Should(Equal("east"))
+		Eventually(func() map[string]string {
+			return spokeNamespaceLabels("west")
+		}, timeout, interval).ShouldNot(HaveKey("team"))
+		Expect(spokeNamespaceLabels("east")).Should(HaveKeyWithValue("team", "platform"))
+	})
+
+	It("Should report a cluster whose NamespaceLabel was not written by the hub", func() {
+		Expect(spokes["west"].client.Create(ctx, &omerv1.NamespaceLabel{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec:       omerv1.NamespaceLabelSpec{Labels: map[string]string{"team": "local"}},
+		})).Should(Succeed())
+		Eventually(func() error {
+			var fedNamespaceLabel omerv1.FederatedNamespaceLabel
+			if err := k8sClient.Get(ctx, fedNamespaceNN, &fedNamespaceLabel); err != nil {
+				return err
+			}
+			fedNamespaceLabel.Spec.Clusters = nil
+			return k8sClient.Update(ctx, &fedNamespaceLabel)
+		}, timeout, interval).Should(Succeed())
+
+		fedNamespaceLabel := expectFederated(omerv1.ConditionTypePropagated, metav1.ConditionFalse)
+		condition := meta.FindStatusCondition(fedNamespaceLabel.Status.Conditions, omerv1.ConditionTypePropagated)
+		Expect(condition.Message).Should(ContainSubstring("west"))
+		Eventually(func() map[string]string {
+			return spokeNamespaceLabels("west")
+		}, timeout, interval).Should(HaveKeyWithValue("team", "local"))
+	})
+
+	It("Should delete the NamespaceLabels of the hub from every cluster before releasing the FederatedNamespaceLabel", func() {
+		Expect(k8sClient.Delete(ctx, &omerv1.FederatedNamespaceLabel{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		})).Should(Succeed())
+
+		Eventually(func() bool {
+			_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: fedNamespaceNN})
+			Expect(err).ShouldNot(HaveOccurred())
+			err = k8sClient.Get(ctx, fedNamespaceNN, &omerv1.FederatedNamespaceLabel{})
+			return apierrors.IsNotFound(err)
+		}, timeout, interval).Should(BeTrue())
+		err := spokes["east"].client.Get(ctx, fedNamespaceNN, &omerv1.NamespaceLabel{})
+		Expect(apierrors.IsNotFound(err)).Should(BeTrue())
+		Expect(spokes["west"].client.Get(ctx, fedNamespaceNN, &omerv1.NamespaceLabel{})).Should(Succeed())
+	})
+})
+
+var _ = Describe("Federated deletion with unreachable clusters", func() {
+
+	Context("When a cluster has a broken Secret and another one was removed", func() {
+		It("Should report the clusters as orphaned and hold the finalizer until the withdraw timeout", func() {
+			hubScheme := runtime.NewScheme()
+			Expect(scheme.AddToScheme(hubScheme)).Should(Succeed())
+			Expect(omerv1.AddToScheme(hubScheme)).Should(Succeed())
+			deletedAt := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+			fedNamespaceLabel := &omerv1.FederatedNamespaceLabel{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "team",
+					Namespace:         "ns",
+					DeletionTimestamp: &metav1.Time{Time: deletedAt},
+					Finalizers:        []string{federationFinalizer},
+				},
+				Status: omerv1.FederatedNamespaceLabelStatus{Clusters: []omerv1.ClusterStatus{
+					{Name: "broken", Propagated: true},
+					{Name: "removed", Propagated: true},
+				}},
+			}
+			c := fake.NewClientBuilder().WithScheme(hubScheme).WithObjects(
+				fedNamespaceLabel,
+				&v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "broken", Namespace: "system", Labels: map[string]string{hub.ClusterSecretLabel: "true"}}},
+			).Build()
+			clock := clocktesting.NewFakePassiveClock(deletedAt.Add(time.Minute))
+			r := &FederatedNamespaceLabelReconciler{
+				Client:          c,
+				Scheme:          hubScheme,
+				Clusters:        &hub.Registry{Reader: c, Namespace: "system"},
+				WithdrawTimeout: 10 * time.Minute,
+				Clock:           clock,
+			}
+			ctx := context.Background()
+			request := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(fedNamespaceLabel)}
+
+			result, err := r.Reconcile(ctx, request)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(result.RequeueAfter).ShouldNot(BeZero())
+			Expect(c.Get(ctx, request.NamespacedName, fedNamespaceLabel)).Should(Succeed())
+			Expect(fedNamespaceLabel.Finalizers).Should(ContainElement(federationFinalizer))
+			Expect(fedNamespaceLabel.Status.Clusters).Should(HaveLen(2))
+			for _, clusterStatus := range fedNamespaceLabel.Status.Clusters {
+				Expect(clusterStatus.Orphaned).Should(BeTrue(), "cluster %s is not reported as orphaned", clusterStatus.Name)
+				Expect(clusterStatus.Message).ShouldNot(BeEmpty())
+			}
+			Expect(meta.IsStatusConditionTrue(fedNamespaceLabel.Status.Conditions, omerv1.ConditionTypeOrphaned)).Should(BeTrue())
+
+			By("Reconciling after the withdraw timeout")
+			clock.SetTime(deletedAt.Add(11 * time.Minute))
+			_, err = r.Reconcile(ctx, request)
+			Expect(err).ShouldNot(HaveOccurred())
+			err = c.Get(ctx, request.NamespacedName, fedNamespaceLabel)
+			if err == nil {
+				Expect(fedNamespaceLabel.Finalizers).ShouldNot(ContainElement(federationFinalizer))
+			} else {
+				Expect(apierrors.IsNotFound(err)).Should(BeTrue())
+			}
+		})
+	})
+})
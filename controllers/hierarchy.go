@@ -0,0 +1,165 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	omerv1 "omer.io/namespacelabel/api/v1"
+	"omer.io/namespacelabel/pkg/labelsync"
+)
+
+// the namespace annotation naming the parent namespace, it wins over the hierarchy labels of HNC
+const parentAnnotation = "namespacelabel.omer.io/parent"
+
+// HNC labels every namespace with <ancestor>.tree.hnc.x-k8s.io/depth for itself and each of its
+// ancestors, the value is the distance to the ancestor and the parent is the ancestor at distance 1
+const hncDepthLabelSuffix = ".tree.hnc.x-k8s.io/depth"
+
+// the function return the parent namespace name, empty for a root namespace
+func parentOf(namespace *v1.Namespace) string {
+	if parent, isExist := namespace.Annotations[parentAnnotation]; isExist {
+		return parent
+	}
+	for key, value := range namespace.Labels {
+		if value == "1" && strings.HasSuffix(key, hncDepthLabelSuffix) {
+			return strings.TrimSuffix(key, hncDepthLabelSuffix)
+		}
+	}
+	return ""
+}
+
+// inherited labels are owned by namespace/name of their nslabel, local nslabels names have no slash
+func inheritedOwner(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+func isInheritedOwner(owner string) bool {
+	return strings.Contains(owner, "/")
+}
+
+// the function return the existing ancestors of the namespace, the parent first. a hierarchy that
+// loops back is logged and has no ancestors, so the namespaces of the cycle inherit nothing
+func (r *NamespaceLabelReconciler) ancestorsOf(ctx context.Context, namespace *v1.Namespace) ([]string, error) {
+	var ancestors []string
+	visited := map[string]bool{namespace.Name: true}
+	for parent := parentOf(namespace); parent != ""; {
+		if visited[parent] {
+			cycle := strings.Join(append(append([]string{namespace.Name}, ancestors...), parent), " -> ")
+			ctrllog.FromContext(ctx).Info("namespace hierarchy has a cycle, no labels are inherited", "namespace", namespace.Name, "cycle", cycle)
+			return nil, nil
+		}
+		visited[parent] = true
+		var parentNamespace v1.Namespace
+		if err := r.Get(ctx, types.NamespacedName{Name: parent}, &parentNamespace); err != nil {
+			if apierrors.IsNotFound(err) {
+				break
+			}
+			return nil, err
+		}
+		ancestors = append(ancestors, parent)
+		parent = parentOf(&parentNamespace)
+	}
+	return ancestors, nil
+}
+
+// the function return the inheritable labels of the nslabels of the ancestors as sources of the namespace
+// plan. the nearer ancestors win, and a label inherited before whose nslabel is gone, deleted or does not
+// reach the namespace anymore gets a deleting source, so it is removed like the labels of a deleted nslabel
+func (r *NamespaceLabelReconciler) inheritedSources(ctx context.Context, ancestors []string, managedLabels map[string]string) ([]labelsync.Source, error) {
+	var sources []labelsync.Source
+	isSource := make(map[string]bool)
+	for i, ancestor := range ancestors {
+		var namespaceLabelList omerv1.NamespaceLabelList
+		if err := r.List(ctx, &namespaceLabelList, client.InNamespace(ancestor)); err != nil {
+			return nil, err
+		}
+		approved, err := r.approvalsOf(ctx, ancestor)
+		if err != nil {
+			return nil, err
+		}
+		for _, namespaceLabel := range namespaceLabelList.Items {
+			if isNsLabelInDeletionState(namespaceLabel) {
+				continue
+			}
+			labels := make(map[string]string)
+			for _, key := range namespaceLabel.Spec.Inheritable {
+				if value, isExist := namespaceLabel.Spec.Labels[key]; isExist {
+					labels[key] = value
+				}
+			}
+			if len(labels) == 0 {
+				continue
+			}
+			name := inheritedOwner(ancestor, namespaceLabel.Name)
+			sources = append(sources, labelsync.Source{
+				Name:              name,
+				Depth:             i + 1,
+				CreationTimestamp: namespaceLabel.CreationTimestamp.Time,
+				Labels:            labels,
+				Approved:          approved.isApproved(namespaceLabel),
+				Suspended:         namespaceLabel.Spec.Suspend,
+			})
+			isSource[name] = true
+		}
+	}
+	for _, owner := range managedLabels {
+		if isInheritedOwner(owner) && !isSource[owner] {
+			sources = append(sources, labelsync.Source{Name: owner, Deleting: true})
+			isSource[owner] = true
+		}
+	}
+	return sources, nil
+}
+
+// the function return the requests of the descendant namespaces, their inherited labels change with the
+// nslabels of the namespace and with its parent. the hierarchy is read from the cache, a cycle ends the walk
+func (r *NamespaceLabelReconciler) descendantsOf(ctx context.Context, namespace string) []reconcile.Request {
+	var namespaceList v1.NamespaceList
+	if err := r.List(ctx, &namespaceList); err != nil {
+		return nil
+	}
+	children := make(map[string][]string)
+	for i := range namespaceList.Items {
+		if parent := parentOf(&namespaceList.Items[i]); parent != "" {
+			children[parent] = append(children[parent], namespaceList.Items[i].Name)
+		}
+	}
+
+	var requests []reconcile.Request
+	visited := map[string]bool{namespace: true}
+	queue := []string{namespace}
+	for len(queue) > 0 {
+		for _, child := range children[queue[0]] {
+			if !visited[child] {
+				visited[child] = true
+				queue = append(queue, child)
+				requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: child}})
+			}
+		}
+		queue = queue[1:]
+	}
+	return requests
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	omerv1 "omer.io/namespacelabel/api/v1"
+	"omer.io/namespacelabel/pkg/labelsync"
+)
+
+var _ = Describe("Namespace hierarchy", func() {
+
+	DescribeTable("Should find the parent of a namespace",
+		func(labels map[string]string, annotations map[string]string, parent string) {
+			namespace := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "child", Labels: labels, Annotations: annotations}}
+			Expect(parentOf(namespace)).Should(Equal(parent))
+		},
+		Entry("root namespace", nil, nil, ""),
+		Entry("parent annotation", nil, map[string]string{parentAnnotation: "team"}, "team"),
+		Entry("HNC hierarchy labels",
+			map[string]string{"child.tree.hnc.x-k8s.io/depth": "0", "team.tree.hnc.x-k8s.io/depth": "1", "org.tree.hnc.x-k8s.io/depth": "2"}, nil, "team"),
+		Entry("parent annotation wins over HNC",
+			map[string]string{"team.tree.hnc.x-k8s.io/depth": "1"}, map[string]string{parentAnnotation: "other"}, "other"),
+	)
+
+	Context("When namespaces have ancestors with NamespaceLabels", func() {
+		var (
+			ctx     context.Context
+			r       *NamespaceLabelReconciler
+			created metav1.Time
+		)
+		namespace := func(name, parent string) *v1.Namespace {
+			return &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: map[string]string{parentAnnotation: parent}}}
+		}
+
+		BeforeEach(func() {
+			ctx = context.Background()
+			scheme := runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).Should(Succeed())
+			Expect(omerv1.AddToScheme(scheme)).Should(Succeed())
+			created = metav1.NewTime(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+			namespaceLabel := func(namespace string, labels map[string]string, inheritable ...string) *omerv1.NamespaceLabel {
+				return &omerv1.NamespaceLabel{
+					ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: namespace, CreationTimestamp: created},
+					Spec:       omerv1.NamespaceLabelSpec{Labels: labels, Inheritable: inheritable},
+				}
+			}
+			r = &NamespaceLabelReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+				&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "org"}},
+				namespace("team", "org"),
+				namespace("child", "team"),
+				namespace("loop-a", "loop-b"),
+				namespace("loop-b", "loop-a"),
+				namespaceLabel("org", map[string]string{"org": "o", "cost": "1"}, "org", "cost"),
+				namespaceLabel("team", map[string]string{"cost": "2", "private": "p"}, "cost"),
+			).Build()}
+		})
+
+		It("Should list the ancestors parent first and stop at a cycle", func() {
+			Expect(r.ancestorsOf(ctx, namespace("child", "team"))).Should(Equal([]string{"team", "org"}))
+			Expect(r.ancestorsOf(ctx, namespace("loop-a", "loop-b"))).Should(BeEmpty())
+		})
+
+		It("Should build a source per ancestor NamespaceLabel and a deleting source for the ones gone", func() {
+			sources, err := r.inheritedSources(ctx, []string{"team", "org"}, map[string]string{"cost": "team/a", "old": "gone/a", "local": "a"})
+			Expect(err).ShouldNot(HaveOccurred())
+			//the creation timestamps went through the fake client, they are compared as instants
+			for i := range sources {
+				if !sources[i].Deleting {
+					Expect(sources[i].CreationTimestamp.Equal(created.Time)).Should(BeTrue())
+					sources[i].CreationTimestamp = time.Time{}
+				}
+			}
+			Expect(sources).Should(Equal([]labelsync.Source{
+				{Name: "team/a", Depth: 1, Labels: map[string]string{"cost": "2"}},
+				{Name: "org/a", Depth: 2, Labels: map[string]string{"org": "o", "cost": "1"}},
+				{Name: "gone/a", Deleting: true},
+			}))
+		})
+
+		It("Should list the descendants parent first and stop at a cycle", func() {
+			Expect(r.descendantsOf(ctx, "org")).Should(Equal([]reconcile.Request{
+				{NamespacedName: types.NamespacedName{Name: "team"}},
+				{NamespacedName: types.NamespacedName{Name: "child"}},
+			}))
+			Expect(r.descendantsOf(ctx, "loop-a")).Should(Equal([]reconcile.Request{
+				{NamespacedName: types.NamespacedName{Name: "loop-b"}},
+			}))
+		})
+	})
+})
@@ -0,0 +1,71 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// the namespace annotation that records which labels were written by the controller,
+// its value is a json object mapping every managed label key to the NamespaceLabel that owns it
+const managedLabelsAnnotation = "namespacelabel.omer.io/managed-labels"
+
+// the annotation of the objects the labels are propagated to, in the format of the managed labels annotation
+const propagatedLabelsAnnotation = "namespacelabel.omer.io/propagated-labels"
+
+// the function return the managed labels recorded on the namespace, an unreadable annotation is treated as empty
+func getManagedLabels(namespace *v1.Namespace) map[string]string {
+	return getLabelOwners(namespace, managedLabelsAnnotation)
+}
+
+// the function record the managed labels on the namespace, the annotation is removed when nothing is managed
+func setManagedLabels(namespace *v1.Namespace, managedLabels map[string]string) {
+	setLabelOwners(namespace, managedLabelsAnnotation, managedLabels)
+}
+
+// the function return the label owners recorded in the annotation of the object
+func getLabelOwners(object metav1.Object, annotation string) map[string]string {
+	owners := make(map[string]string)
+	value, isExist := object.GetAnnotations()[annotation]
+	if !isExist {
+		return owners
+	}
+	if err := json.Unmarshal([]byte(value), &owners); err != nil {
+		return make(map[string]string)
+	}
+	return owners
+}
+
+// the function record the label owners in the annotation of the object, the annotation is removed when empty
+func setLabelOwners(object metav1.Object, annotation string, owners map[string]string) {
+	annotations := object.GetAnnotations()
+	if len(owners) == 0 {
+		delete(annotations, annotation)
+		object.SetAnnotations(annotations)
+		return
+	}
+	// json.Marshal sorts the map keys so the annotation value is stable between reconciles
+	value, _ := json.Marshal(owners)
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[annotation] = string(value)
+	object.SetAnnotations(annotations)
+}
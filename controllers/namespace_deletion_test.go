@@ -0,0 +1,71 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	omerv1 "omer.io/namespacelabel/api/v1"
+)
+
+var _ = Describe("NamespaceLabel controller with a terminating namespace", func() {
+
+	const namespacelabelName = "a"
+
+	Context("When deleting a namespace that has NamespaceLabels", func() {
+		It("Should release the NamespaceLabels without updating the namespace", func() {
+			ctx := context.Background()
+			By("Creating a namespace with a NamespaceLabel")
+			namespace := createNamespace(ctx, nil)
+			createNamespaceLabel(ctx, namespace, namespacelabelName, map[string]string{"team": "a"})
+
+			var namespaceObj v1.Namespace
+			var nsLabel omerv1.NamespaceLabel
+			namespacedName := types.NamespacedName{Name: namespace}
+			nsLabelName := types.NamespacedName{Name: namespacelabelName, Namespace: namespace}
+			Eventually(func() map[string]string {
+				return getNamespaceLabels(ctx, namespace)
+			}, timeout, interval).Should(HaveKeyWithValue("team", "a"))
+			Expect(k8sClient.Get(ctx, nsLabelName, &nsLabel)).Should(Succeed())
+			Expect(controllerutil.ContainsFinalizer(&nsLabel, nsLabelFinalizer)).Should(BeTrue())
+
+			By("Deleting the namespace")
+			Expect(k8sClient.Get(ctx, namespacedName, &namespaceObj)).Should(Succeed())
+			Expect(k8sClient.Delete(ctx, &namespaceObj)).Should(Succeed())
+
+			// envtest runs no namespace controller, the NamespaceLabels are not deleted and only the
+			// reconciler can release them
+			By("Checking the finalizers of the NamespaceLabels are released")
+			Eventually(func(g Gomega) {
+				g.Expect(k8sClient.Get(ctx, nsLabelName, &nsLabel)).Should(Succeed())
+				g.Expect(controllerutil.ContainsFinalizer(&nsLabel, nsLabelFinalizer)).Should(BeFalse())
+			}, timeout, interval).Should(Succeed())
+			Expect(nsLabel.DeletionTimestamp.IsZero()).Should(BeTrue())
+
+			By("Checking the terminating namespace was left untouched")
+			Expect(k8sClient.Get(ctx, namespacedName, &namespaceObj)).Should(Succeed())
+			Expect(isNamespaceInDeletionState(namespaceObj)).Should(BeTrue())
+			Expect(namespaceObj.GetLabels()).Should(HaveKeyWithValue("team", "a"))
+		})
+	})
+})
@@ -18,45 +18,92 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
 
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/util/retry"
+	configv1 "omer.io/namespacelabel/api/config/v1"
 	omerv1 "omer.io/namespacelabel/api/v1"
+	"omer.io/namespacelabel/pkg/health"
+	"omer.io/namespacelabel/pkg/labelsync"
+	"omer.io/namespacelabel/pkg/podsecurity"
+	"omer.io/namespacelabel/pkg/sharding"
+	"omer.io/namespacelabel/pkg/throttle"
+	"omer.io/namespacelabel/pkg/tracing"
 
 	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
-	"github.com/go-logr/logr"
-
-	"golang.org/x/exp/slices"
+	"golang.org/x/exp/maps"
 )
 
-// NamespaceLabelReconciler reconciles a NamespaceLabel object
+// NamespaceLabelReconciler reconciles the NamespaceLabel objects of a namespace.
+// The reconcile unit is the Namespace: all the NamespaceLabels targeting it are merged
+// into one desired label set, written with a single patch and then reported back to
+// the status of every NamespaceLabel.
 type NamespaceLabelReconciler struct {
 	client.Client
-	Logger          logr.Logger
-	Scheme          *runtime.Scheme
-	ProtectedLabels []string
+	Scheme                 *runtime.Scheme
+	ProtectedLabels        []string
+	ProtectedLabelPrefixes []string
+	// ApprovalRequiredLabels and ApprovalRequiredLabelPrefixes are the label keys an nslabel only
+	// sets, changes or removes once a LabelChangeApproval approves its generation
+	ApprovalRequiredLabels        []string
+	ApprovalRequiredLabelPrefixes []string
+	// ConflictPolicy decides what to do with labels that already exist in the namespace, Skip when empty
+	ConflictPolicy configv1.ConflictPolicy
+	// MaxConcurrentReconciles is passed to the controller options, 1 when zero.
+	// Different namespaces are reconciled in parallel, the workqueue never hands
+	// the same namespace to two workers at once
+	MaxConcurrentReconciles int
+	// Shard restricts the reconciler to the namespaces owned by this replica when sharding is enabled
+	Shard *sharding.Coordinator
+	// PodSecurity checks a stricter pod security enforce level against the existing pods before
+	// it is synced, the level is synced without a check when nil
+	PodSecurity podsecurity.DryRunner
+	// Throttle limits the writes to the namespaces, a throttled namespace is reconciled again
+	// once the write is allowed. The writes are not limited when nil
+	Throttle *throttle.Throttle
+	// RetryBaseDelay and RetryMaxDelay bound the backoff of the failed reconciles of a namespace,
+	// the controller-runtime defaults are used when zero
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+	// Paused suspends all the NamespaceLabels, no namespace is written while their status still
+	// reports the labels that differ
+	Paused bool
+	// Progress follows the reconciles for the liveness check, nothing is recorded when nil
+	Progress *health.Progress
 }
 
 //+kubebuilder:rbac:groups=omer.omer.io,resources=namespacelabels,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=omer.omer.io,resources=namespacelabels/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=omer.omer.io,resources=namespacelabels/finalizers,verbs=update
+//+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch;update;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the NamespaceLabel object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
+// The request is the name of a Namespace, the labels of all its NamespaceLabel
+// objects are synced to it together.
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.13.0/pkg/reconcile
@@ -68,197 +115,505 @@ func isNsLabelInDeletionState(namespaceLabel omerv1.NamespaceLabel) bool {
 	return !(namespaceLabel.ObjectMeta.DeletionTimestamp.IsZero())
 }
 
+// a terminating namespace must not be updated, the namespace controller is deleting its content
+func isNamespaceInDeletionState(namespace v1.Namespace) bool {
+	return !(namespace.ObjectMeta.DeletionTimestamp.IsZero())
+}
+
 func isLabelKeyExistInLabels(labels map[string]string, key string) bool {
 	_, isExist := labels[key]
 	return isExist
 }
 
-// the function return all the keys that exist in map1 and not in map2
-func getDiffBetweenMaps(map1 map[string]string, map2 map[string]string) map[string]string {
-	diffMap := make(map[string]string)
-	for key, value := range map1 {
-		if !isLabelKeyExistInLabels(map2, key) {
-			diffMap[key] = value
-		}
+// the cluster wide rules every sync plan follows
+func (r *NamespaceLabelReconciler) syncPolicy() labelsync.Policy {
+	return labelsync.Policy{
+		ProtectedLabels:        r.ProtectedLabels,
+		ProtectedLabelPrefixes: r.ProtectedLabelPrefixes,
+		Overwrite:              r.ConflictPolicy == configv1.ConflictPolicyOverwrite,
+
+		ApprovalRequiredLabels:        r.ApprovalRequiredLabels,
+		ApprovalRequiredLabelPrefixes: r.ApprovalRequiredLabelPrefixes,
+		Validate:                      podsecurity.Validate,
 	}
+}
 
-	return diffMap
+// CheckPolicy is the readiness check of the protected labels, without them the controller would
+// overwrite the labels kubernetes and the other controllers set on the namespaces
+func (r *NamespaceLabelReconciler) CheckPolicy(_ *http.Request) error {
+	if len(r.ProtectedLabels) == 0 && len(r.ProtectedLabelPrefixes) == 0 {
+		return errors.New("no protected label or prefix is loaded")
+	}
+	return nil
 }
 
-func (r *NamespaceLabelReconciler) cleanupNamespaceLabel(ctx context.Context, namespaceLabel omerv1.NamespaceLabel, nsLabelFinalizer string) error {
-	//get the namespace for sync to nslabel
-	var namespace v1.Namespace
-	namespacedName := types.NamespacedName{Name: namespaceLabel.Namespace}
-	if err := r.Get(ctx, namespacedName, &namespace); err != nil {
-		r.Logger.Error(err, "unable to fetch namespace", namespaceLabel.Namespace)
-		return client.IgnoreNotFound(err)
+// the function convert the nslabels of the namespace to the sources of a sync plan
+func toSyncSources(namespaceLabels []omerv1.NamespaceLabel, approved approvals) []labelsync.Source {
+	sources := make([]labelsync.Source, 0, len(namespaceLabels))
+	for _, namespaceLabel := range namespaceLabels {
+		sources = append(sources, labelsync.Source{
+			Name:              namespaceLabel.Name,
+			CreationTimestamp: namespaceLabel.CreationTimestamp.Time,
+			Labels:            namespaceLabel.Spec.Labels,
+			Deleting:          isNsLabelInDeletionState(namespaceLabel),
+			Approved:          approved.isApproved(namespaceLabel),
+			Suspended:         namespaceLabel.Spec.Suspend,
+		})
 	}
+	return sources
+}
 
-	//delete all sync labels from ns
-	isChangeNeededInNamespace := false
-	for key, _ := range namespaceLabel.Status.SyncLabels {
-		if isLabelKeyExistInLabels(namespace.ObjectMeta.Labels, key) {
-			isChangeNeededInNamespace = true
-			delete(namespace.ObjectMeta.Labels, key)
+// the function return the labels managed in the namespace and the nslabel owning each of them.
+// the namespace annotation is the source of truth, the synced status of the nslabels is only used
+// for labels written before the annotation existed
+func getNamespaceManagedLabels(namespace v1.Namespace, namespaceLabels []omerv1.NamespaceLabel) map[string]string {
+	managedLabels := getManagedLabels(&namespace)
+	for _, namespaceLabel := range namespaceLabels {
+		for key := range namespaceLabel.Status.SyncLabels {
+			if !isLabelKeyExistInLabels(managedLabels, key) && isLabelKeyExistInLabels(namespace.ObjectMeta.Labels, key) {
+				managedLabels[key] = namespaceLabel.Name
+			}
 		}
 	}
+	return managedLabels
+}
 
-	//update the ns
-	if isChangeNeededInNamespace {
-		if err := r.Update(ctx, &namespace); err != nil {
-			r.Logger.Error(err, "unable to update namespace", namespace.Name)
-			return err
-		}
+// the function write the planned labels to the namespace with a single patch
+func (r *NamespaceLabelReconciler) syncNamespaceToNamespaceLabel(ctx context.Context, namespace v1.Namespace, managedLabels map[string]string, plan labelsync.Plan) error {
+	logger := ctrllog.FromContext(ctx)
+
+	if isNamespaceSynced(namespace, managedLabels, plan) {
+		return nil
 	}
-	//remove the finalizer
-	controllerutil.RemoveFinalizer(&namespaceLabel, nsLabelFinalizer)
-	if err := r.Update(ctx, &namespaceLabel); err != nil {
+
+	patch := client.MergeFromWithOptions(namespace.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	namespace.SetLabels(plan.Labels(namespace.ObjectMeta.Labels))
+	setManagedLabels(&namespace, plan.Managed)
+	err := r.Patch(ctx, &namespace, patch)
+	if r.Throttle != nil {
+		r.Throttle.Record(err)
+	}
+	if err != nil {
+		logger.Error(err, "unable to update namespace labels", "namespace", namespace.Name)
 		return err
 	}
 
 	return nil
 }
 
-// the main function for handling the sync between the cr and the namespace
-func (r *NamespaceLabelReconciler) handleSyncNamespaceLabel(ctx context.Context, namespaceLabel omerv1.NamespaceLabel) error {
-	//get the namespace for sync to nslabel
-	var namespace v1.Namespace
-	if err := r.Get(ctx, types.NamespacedName{Name: namespaceLabel.Namespace}, &namespace); err != nil {
-		r.Logger.Error(err, "unable to fetch namespace", namespace.ObjectMeta.Name)
-		return client.IgnoreNotFound(err)
-	}
+// the namespace already has the planned labels and managed labels annotation, nothing is written
+func isNamespaceSynced(namespace v1.Namespace, managedLabels map[string]string, plan labelsync.Plan) bool {
+	return plan.IsNoop(managedLabels) && maps.Equal(managedLabels, getManagedLabels(&namespace))
+}
 
-	postSyncLabels, postUnSyncLabels := r.sortSyncLabels(namespaceLabel, namespace)
-	fmt.Println(postSyncLabels, postUnSyncLabels)
-	if err := r.syncNamespaceToNamespaceLabel(ctx, namespaceLabel, namespace, postSyncLabels); err != nil {
-		r.Logger.Error(err, "unable to update namespacelabel in order to the namespacelabel", namespaceLabel.ObjectMeta.Name)
-		return err
-	}
+// the function write the sync result of the nslabel to its status with a merge patch, nothing is
+// written when the status is unchanged. on a conflict the nslabel is read again, as long as its spec
+// is still the generation the result was computed for
+func (r *NamespaceLabelReconciler) handleSyncNamespaceLabel(ctx context.Context, namespaceLabel omerv1.NamespaceLabel, result labelsync.Result,
+	propagation []omerv1.PropagationStatus, suspendReason string) error {
+	logger := ctrllog.FromContext(ctx)
+	ctx, span := tracing.Start(ctx, "handleSyncNamespaceLabel", tracing.NamespaceKey.String(namespaceLabel.Namespace),
+		tracing.NamespaceLabelKey.String(namespaceLabel.Name), tracing.GenerationKey.Int64(namespaceLabel.Generation))
+
+	generation := namespaceLabel.Generation
+	isFirstAttempt := true
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if !isFirstAttempt {
+			if err := r.Get(ctx, client.ObjectKeyFromObject(&namespaceLabel), &namespaceLabel); err != nil {
+				return err
+			}
+		}
+		isFirstAttempt = false
+		//the spec changed since the sync, the next reconcile reports it
+		if namespaceLabel.Generation != generation {
+			return nil
+		}
 
-	namespaceLabel.Status.SyncLabels = postSyncLabels
-	namespaceLabel.Status.UnSyncLabels = postUnSyncLabels
-	if err := r.Status().Update(ctx, &namespaceLabel); err != nil {
-		r.Logger.Error(err, "unable to update status of namespaceLabel", namespaceLabel.ObjectMeta.Name)
-		return err
+		status := syncStatus(namespaceLabel, result, propagation, suspendReason)
+		if equality.Semantic.DeepEqual(status, namespaceLabel.Status) {
+			return nil
+		}
+		patch := client.MergeFromWithOptions(namespaceLabel.DeepCopy(), client.MergeFromWithOptimisticLock{})
+		namespaceLabel.Status = status
+		return r.Status().Patch(ctx, &namespaceLabel, patch)
+	})
+	tracing.End(span, err)
+	if err != nil {
+		logger.Error(err, "unable to update status of namespaceLabel", "namespaceLabel", namespaceLabel.Name)
+		return client.IgnoreNotFound(err)
 	}
 
 	return nil
 }
 
-func (r *NamespaceLabelReconciler) sortSyncLabels(namespaceLabel omerv1.NamespaceLabel, namespace v1.Namespace) (syncLabels map[string]string, unSyncLabels map[string]string) {
-	syncLabels = make(map[string]string)
-	unSyncLabels = make(map[string]string)
+// the function return the status of the nslabel after the sync, empty label maps are left nil like they are read back
+func syncStatus(namespaceLabel omerv1.NamespaceLabel, result labelsync.Result, propagation []omerv1.PropagationStatus,
+	suspendReason string) omerv1.NamespaceLabelStatus {
+	status := *namespaceLabel.Status.DeepCopy()
+	status.SyncLabels = nil
+	if len(result.Synced) > 0 {
+		status.SyncLabels = maps.Clone(result.Synced)
+	}
+	status.UnSyncLabels = nil
+	status.PendingLabels = nil
+	for key, skip := range result.Skipped {
+		if skip.Reason == labelsync.ReasonPendingApproval {
+			if status.PendingLabels == nil {
+				status.PendingLabels = make(map[string]string)
+			}
+			status.PendingLabels[key] = skip.Value
+			continue
+		}
+		if status.UnSyncLabels == nil {
+			status.UnSyncLabels = make(map[string]string)
+		}
+		status.UnSyncLabels[key] = skip.Value
+	}
+	status.Propagation = nil
+	if len(propagation) > 0 {
+		status.Propagation = propagation
+	}
+	status.ObservedGeneration = namespaceLabel.Generation
+	meta.SetStatusCondition(&status.Conditions, syncedCondition(namespaceLabel, result))
+	//the nslabels that were never suspended or throttled do not carry the conditions
+	if suspendReason != "" || meta.FindStatusCondition(status.Conditions, omerv1.ConditionTypeSuspended) != nil {
+		meta.SetStatusCondition(&status.Conditions, suspendedCondition(namespaceLabel, suspendReason, result))
+	}
+	if meta.FindStatusCondition(status.Conditions, omerv1.ConditionTypeThrottled) != nil {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               omerv1.ConditionTypeThrottled,
+			Status:             metav1.ConditionFalse,
+			Reason:             omerv1.ReasonNotThrottled,
+			Message:            "the namespace write was not delayed",
+			ObservedGeneration: namespaceLabel.Generation,
+		})
+	}
+	return status
+}
 
-	//stage 2:running on all the labels in the spec of the nslabel object
-	//stage 2.1: the label is in the namespace and not in the sync - result: update the Unsync
-	//stage 2.2: the label is in the namespace and in the sync - result: update the sync
-	//stage 2.3: the label is in the not in the namespace and also not in the sync - result: update the sync
+func syncedCondition(namespaceLabel omerv1.NamespaceLabel, result labelsync.Result) metav1.Condition {
+	if len(result.Skipped) == 0 {
+		return metav1.Condition{
+			Type:               omerv1.ConditionTypeSynced,
+			Status:             metav1.ConditionTrue,
+			Reason:             omerv1.ReasonSynced,
+			Message:            "all labels are synced to the namespace",
+			ObservedGeneration: namespaceLabel.Generation,
+		}
+	}
 
-	for key, value := range namespaceLabel.Spec.Labels {
-		if slices.Contains(r.ProtectedLabels, key) {
-			unSyncLabels[key] = value
-		} else {
-			if isLabelKeyExistInLabels(namespace.ObjectMeta.Labels, key) {
-				if isLabelKeyExistInLabels(namespaceLabel.Status.SyncLabels, key) {
-					syncLabels[key] = value
-				} else {
-					unSyncLabels[key] = value
-				}
-			} else {
-				syncLabels[key] = value
-			}
+	keys := maps.Keys(result.Skipped)
+	sort.Strings(keys)
+	messages := make([]string, 0, len(keys))
+	reason := omerv1.ReasonApprovalPending
+	for _, key := range keys {
+		messages = append(messages, fmt.Sprintf("label %s %s", key, skipMessage(result.Skipped[key])))
+		if result.Skipped[key].Reason != labelsync.ReasonPendingApproval {
+			reason = omerv1.ReasonLabelsNotSynced
 		}
+	}
+	return metav1.Condition{
+		Type:               omerv1.ConditionTypeSynced,
+		Status:             metav1.ConditionFalse,
+		Reason:             reason,
+		Message:            strings.Join(messages, "; "),
+		ObservedGeneration: namespaceLabel.Generation,
+	}
+}
 
+func skipMessage(skip labelsync.Skip) string {
+	switch skip.Reason {
+	case labelsync.ReasonProtected:
+		return "is protected"
+	case labelsync.ReasonClaimedByOther:
+		return "is claimed by NamespaceLabel " + skip.Owner
+	case labelsync.ReasonExistsInNamespace:
+		return "already exists in the namespace"
+	case labelsync.ReasonPendingApproval:
+		if skip.Removal {
+			return "is removed from the spec and waits for a LabelChangeApproval of this generation"
+		}
+		return "waits for a LabelChangeApproval of this generation"
+	case labelsync.ReasonInvalid:
+		return "is invalid: " + skip.Message
+	case reasonPodSecurityViolations:
+		return "is held, existing pods violate it: " + skip.Message
+	case labelsync.ReasonSuspended:
+		return "is suspended"
+	default:
+		return string(skip.Reason)
 	}
-	return syncLabels, unSyncLabels
 }
 
-func (r *NamespaceLabelReconciler) syncNamespaceToNamespaceLabel(ctx context.Context, namespaceLabel omerv1.NamespaceLabel, namespace v1.Namespace, postSyncLabels map[string]string) error {
-	newNamespaceLabels := make(map[string]string)
-	deletedLabels := getDiffBetweenMaps(namespaceLabel.Status.SyncLabels, postSyncLabels)
+// the function remove the finalizer of a deleted nslabel, after its labels were removed from the namespace
+func (r *NamespaceLabelReconciler) cleanupNamespaceLabel(ctx context.Context, namespaceLabel omerv1.NamespaceLabel, nsLabelFinalizer string) error {
+	ctx, span := tracing.Start(ctx, "cleanupNamespaceLabel", tracing.NamespaceKey.String(namespaceLabel.Namespace),
+		tracing.NamespaceLabelKey.String(namespaceLabel.Name))
+	err := client.IgnoreNotFound(r.patchFinalizer(ctx, &namespaceLabel, nsLabelFinalizer, false))
+	tracing.End(span, err)
+	return err
+}
 
-	for key, value := range namespace.ObjectMeta.Labels {
-		if isLabelKeyExistInLabels(deletedLabels, key) {
-			continue
+// the function add or remove the finalizer of the nslabel with a merge patch. the patch replaces the whole
+// finalizers list, so it is sent with the resource version and retried on a conflict with a fresh read
+func (r *NamespaceLabelReconciler) patchFinalizer(ctx context.Context, namespaceLabel *omerv1.NamespaceLabel, finalizer string, add bool) error {
+	isFirstAttempt := true
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if !isFirstAttempt {
+			if err := r.Get(ctx, client.ObjectKeyFromObject(namespaceLabel), namespaceLabel); err != nil {
+				return err
+			}
+		}
+		isFirstAttempt = false
+		//no finalizer can be added to a deleted nslabel
+		if controllerutil.ContainsFinalizer(namespaceLabel, finalizer) == add || (add && isNsLabelInDeletionState(*namespaceLabel)) {
+			return nil
+		}
+
+		patch := client.MergeFromWithOptions(namespaceLabel.DeepCopy(), client.MergeFromWithOptimisticLock{})
+		if add {
+			controllerutil.AddFinalizer(namespaceLabel, finalizer)
 		} else {
-			newNamespaceLabels[key] = value
+			controllerutil.RemoveFinalizer(namespaceLabel, finalizer)
 		}
-	}
+		return r.Patch(ctx, namespaceLabel, patch)
+	})
+}
+
+// every nslabel or propagated object event is mapped to its namespace, which is the reconcile unit
+func (r *NamespaceLabelReconciler) namespaceOfObject(object client.Object) []reconcile.Request {
+	return []reconcile.Request{{
+		NamespacedName: types.NamespacedName{Name: object.GetNamespace()},
+	}}
+}
+
+// the nslabel events also reach the descendants of the namespace, which may inherit its labels
+func (r *NamespaceLabelReconciler) namespaceAndDescendantsOfNamespaceLabel(namespaceLabel client.Object) []reconcile.Request {
+	return append(r.namespaceOfObject(namespaceLabel), r.descendantsOf(context.Background(), namespaceLabel.GetNamespace())...)
+}
 
-	for key, value := range postSyncLabels {
-		newNamespaceLabels[key] = value
+// a namespace moved in the hierarchy changes the labels its descendants inherit
+func (r *NamespaceLabelReconciler) descendantsOfNamespace(namespace client.Object) []reconcile.Request {
+	return r.descendantsOf(context.Background(), namespace.GetName())
+}
+
+func (r *NamespaceLabelReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
+	//the spans of the api calls and of the nslabels of the namespace are children of the reconcile span
+	ctx, span := tracing.Start(ctx, "Reconcile", tracing.NamespaceKey.String(req.Name))
+	defer func() { tracing.End(span, err) }()
+	if r.Progress != nil {
+		defer r.Progress.Start()()
 	}
 
-	namespace.SetLabels(newNamespaceLabels)
-	if err := r.Update(ctx, &namespace); err != nil {
-		r.Logger.Error(err, "unable to update namespace labels", namespace.Name)
-		return err
+	// the logger comes from the context, reconciles may run in parallel
+	logger := ctrllog.FromContext(ctx)
+
+	//another replica owns the namespace, the shard coordinator enqueues it again if this replica gains it
+	if r.Shard != nil && !r.Shard.Owns(req.Name) {
+		return ctrl.Result{}, nil
 	}
 
-	return nil
-}
+	//get the namespace
+	var namespace v1.Namespace
+	if err := r.Get(ctx, req.NamespacedName, &namespace); err != nil {
+		//the nslabels of a deleted namespace are deleted with it
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
 
-func (r *NamespaceLabelReconciler) listAllNamespaceLabel(namespace client.Object) []reconcile.Request {
-	namespaceLabelList := &omerv1.NamespaceLabelList{}
-	listOps := &client.ListOptions{
-		Namespace: namespace.GetNamespace(),
+	//get all the nslabels of the namespace
+	var namespaceLabelList omerv1.NamespaceLabelList
+	if err := r.List(ctx, &namespaceLabelList, client.InNamespace(namespace.Name)); err != nil {
+		logger.Error(err, "unable to list ns-labels", "namespace", namespace.Name)
+		return ctrl.Result{}, err
 	}
-	err := r.List(context.TODO(), namespaceLabelList, listOps)
-	if err != nil {
-		return []reconcile.Request{}
+
+	//the namespace is terminating and will take its labels with it, updating it would fail
+	//and keeping the finalizers would block the namespace deletion
+	if isNamespaceInDeletionState(namespace) {
+		logger.Info("namespace in deletion state, releasing its ns-labels", "namespace", namespace.Name)
+		var errs []error
+		for _, namespaceLabel := range namespaceLabelList.Items {
+			if err := r.cleanupNamespaceLabel(ctx, namespaceLabel, nsLabelFinalizer); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return ctrl.Result{}, utilerrors.NewAggregate(errs)
 	}
 
-	requests := make([]reconcile.Request, len(namespaceLabelList.Items))
-	for i, item := range namespaceLabelList.Items {
-		requests[i] = reconcile.Request{
-			NamespacedName: types.NamespacedName{
-				Name:      item.GetName(),
-				Namespace: item.GetNamespace(),
-			},
+	//first - add the finalizer to the live nslabels, so their labels are removed when they are deleted
+	var liveNamespaceLabels, deletedNamespaceLabels []omerv1.NamespaceLabel
+	for _, namespaceLabel := range namespaceLabelList.Items {
+		if isNsLabelInDeletionState(namespaceLabel) {
+			deletedNamespaceLabels = append(deletedNamespaceLabels, namespaceLabel)
+			continue
 		}
+		if err := r.patchFinalizer(ctx, &namespaceLabel, nsLabelFinalizer, true); err != nil {
+			return ctrl.Result{}, err
+		}
+		liveNamespaceLabels = append(liveNamespaceLabels, namespaceLabel)
+	}
+
+	//the labels requiring approval are only changed by the approved generations
+	approved, err := r.approvalsOf(ctx, namespace.Name)
+	if err != nil {
+		logger.Error(err, "unable to list the label change approvals", "namespace", namespace.Name)
+		return ctrl.Result{}, err
 	}
-	return requests
-}
 
-func (r *NamespaceLabelReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	r.Logger = ctrllog.FromContext(ctx)
+	//the inheritable labels of the ancestors are merged too, a cycle in the hierarchy stops the inheritance
+	managedLabels := getNamespaceManagedLabels(namespace, namespaceLabelList.Items)
+	ancestors, err := r.ancestorsOf(ctx, &namespace)
+	if err != nil {
+		logger.Error(err, "unable to read the ancestors", "namespace", namespace.Name)
+		return ctrl.Result{}, err
+	}
+	inheritedSources, err := r.inheritedSources(ctx, ancestors, managedLabels)
+	if err != nil {
+		logger.Error(err, "unable to list the ns-labels of the ancestors", "namespace", namespace.Name)
+		return ctrl.Result{}, err
+	}
 
-	// TODO(user): your logic here
+	//the labels derived from the name and annotations by the rules lose to the nslabels and the inherited labels
+	derivedSources, err := r.derivedSources(ctx, &namespace, managedLabels)
+	if err != nil {
+		logger.Error(err, "unable to list the ns-label rules", "namespace", namespace.Name)
+		return ctrl.Result{}, err
+	}
 
-	//get the nslabel
-	var namespaceLabel omerv1.NamespaceLabel
-	if err := r.Get(ctx, req.NamespacedName, &namespaceLabel); err != nil {
-		r.Logger.Error(err, "unable to fetch ns-label", "namespace", namespaceLabel.ObjectMeta.Name)
-		return ctrl.Result{}, client.IgnoreNotFound(err)
+	//merge the nslabels and sync the namespace with a single write
+	sources := append(append(toSyncSources(namespaceLabelList.Items, approved), inheritedSources...), derivedSources...)
+	//a paused namespace keeps all its labels, the plan only reports what differs
+	isPaused := r.Paused || isNamespacePaused(&namespace)
+	if isPaused {
+		for i := range sources {
+			sources[i].Suspended = true
+		}
+	}
+	plan := labelsync.NewPlan(sources, namespace.ObjectMeta.Labels, managedLabels, r.syncPolicy())
+	if err := r.checkPodSecurity(ctx, &namespace, managedLabels, plan); err != nil {
+		logger.Error(err, "unable to check the pod security level", "namespace", namespace.Name)
+		return ctrl.Result{}, err
 	}
 
-	//check if  nslabel in deletion state
-	if isNsLabelInDeletionState(namespaceLabel) {
-		r.Logger.Info("NamespaceLabel in deletion state ")
-		//sent to clean all the labels from the namespace and then delete the nslabel finalizer
-		r.cleanupNamespaceLabel(ctx, namespaceLabel, nsLabelFinalizer)
-	} else {
-		//first - add finalizer
-		if !controllerutil.ContainsFinalizer(&namespaceLabel, nsLabelFinalizer) {
-			controllerutil.AddFinalizer(&namespaceLabel, nsLabelFinalizer)
-			if err := r.Update(ctx, &namespaceLabel); err != nil {
+	//a write over the limits is not done, nothing else is changed until it is: the propagation
+	//and the finalizers of the deleted nslabels follow the namespace labels
+	if r.Throttle != nil && !isNamespaceSynced(namespace, managedLabels, plan) {
+		if delay, reason := r.Throttle.Allow(namespace.Name); delay > 0 {
+			logger.Info("namespace write throttled", "namespace", namespace.Name, "reason", reason, "retryAfter", delay)
+			throttledWrites.WithLabelValues(string(reason)).Inc()
+			if err := r.handleThrottledNamespaceLabels(ctx, liveNamespaceLabels, reason); err != nil {
 				return ctrl.Result{}, err
 			}
+			return ctrl.Result{RequeueAfter: delay}, nil
 		}
-		//todo - sync nslabel to namespace object
-		r.handleSyncNamespaceLabel(ctx, namespaceLabel)
+	}
+	if err := r.syncNamespaceToNamespaceLabel(ctx, namespace, managedLabels, plan); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	//stamp the synced labels onto the objects selected by the nslabels, the objects that failed are
+	//retried by the next reconcile and meanwhile the other objects and the status are synced
+	var errs []error
+	propagation, propagateErr := r.propagateToObjects(ctx, namespace.Name, namespaceLabelList.Items, plan, isPaused)
+	if propagateErr != nil {
+		logger.Error(propagateErr, "unable to propagate labels", "namespace", namespace.Name)
+		errs = append(errs, propagateErr)
 	}
 
-	return ctrl.Result{}, nil
+	//fan the result back to every nslabel
+	for _, namespaceLabel := range liveNamespaceLabels {
+		if err := r.handleSyncNamespaceLabel(ctx, namespaceLabel, plan.Results[namespaceLabel.Name], propagation[namespaceLabel.Name],
+			r.suspendReason(&namespace, namespaceLabel)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, namespaceLabel := range deletedNamespaceLabels {
+		//the finalizer is kept until the labels are removed from the objects too, a suspended nslabel
+		//keeps its labels until it is resumed
+		if propagateErr != nil || r.suspendReason(&namespace, namespaceLabel) != "" {
+			continue
+		}
+		logger.Info("NamespaceLabel in deletion state", "namespaceLabel", namespaceLabel.Name)
+		if err := r.cleanupNamespaceLabel(ctx, namespaceLabel, nsLabelFinalizer); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return ctrl.Result{}, utilerrors.NewAggregate(errs)
 }
 
 // SetupWithManager sets up the controller with the Manager.
+// The predicates drop the events the reconcile has nothing to do for, like the status
+// updates it writes itself, the namespacelabel_watch_events_total metric counts them.
 func (r *NamespaceLabelReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&omerv1.NamespaceLabel{}).
+	options := controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}
+	if r.RetryBaseDelay > 0 && r.RetryMaxDelay > 0 {
+		options.RateLimiter = retryRateLimiter(r.RetryBaseDelay, r.RetryMaxDelay)
+	}
+	for _, index := range predicateIndexes {
+		if err := mgr.GetFieldIndexer().IndexField(context.Background(), index.object, index.field, index.extract); err != nil {
+			return err
+		}
+	}
+	controllerBuilder := ctrl.NewControllerManagedBy(mgr).
+		Named("namespacelabel").
+		For(&v1.Namespace{}, builder.WithPredicates(countingPredicate{
+			Predicate: namespacePredicate{Reader: mgr.GetClient()},
+			kind:      "Namespace",
+		})).
+		WithOptions(options).
 		Watches(
-			&source.Kind{Type: &v1.Namespace{}},
-			handler.EnqueueRequestsFromMapFunc(r.listAllNamespaceLabel),
-		).
-		Complete(r)
+			&source.Kind{Type: &omerv1.NamespaceLabel{}},
+			handler.EnqueueRequestsFromMapFunc(r.namespaceAndDescendantsOfNamespaceLabel),
+			builder.WithPredicates(countingPredicate{
+				Predicate: namespaceLabelPredicate(),
+				kind:      "NamespaceLabel",
+			}),
+		)
+	controllerBuilder = controllerBuilder.Watches(
+		&source.Kind{Type: &v1.Namespace{}},
+		handler.EnqueueRequestsFromMapFunc(r.descendantsOfNamespace),
+		builder.WithPredicates(countingPredicate{
+			Predicate: parentChangedPredicate(),
+			kind:      "NamespaceParent",
+		}),
+	)
+	//an approval changes the labels of its namespace and the ones its descendants inherit
+	controllerBuilder = controllerBuilder.Watches(
+		&source.Kind{Type: &omerv1.LabelChangeApproval{}},
+		handler.EnqueueRequestsFromMapFunc(r.namespaceAndDescendantsOfNamespaceLabel),
+		builder.WithPredicates(countingPredicate{
+			Predicate: predicate.GenerationChangedPredicate{},
+			kind:      "LabelChangeApproval",
+		}),
+	)
+	controllerBuilder = controllerBuilder.Watches(
+		&source.Kind{Type: &omerv1.NamespaceLabelRule{}},
+		handler.EnqueueRequestsFromMapFunc(r.namespacesOfNamespaceLabelRule),
+		builder.WithPredicates(countingPredicate{
+			Predicate: predicate.GenerationChangedPredicate{},
+			kind:      "NamespaceLabelRule",
+		}),
+	)
+	//the objects the labels are propagated to are watched as metadata, a new or relabeled object
+	//reconciles its namespace
+	kinds := maps.Keys(propagationKinds)
+	sort.Slice(kinds, func(i, j int) bool { return kinds[i] < kinds[j] })
+	for _, kind := range kinds {
+		object := &metav1.PartialObjectMetadata{}
+		object.SetGroupVersionKind(propagationKinds[kind])
+		controllerBuilder = controllerBuilder.Watches(
+			&source.Kind{Type: object},
+			handler.EnqueueRequestsFromMapFunc(r.namespaceOfObject),
+			builder.OnlyMetadata,
+			builder.WithPredicates(countingPredicate{
+				Predicate: propagatedObjectPredicate{Reader: mgr.GetClient()},
+				kind:      string(kind),
+			}),
+		)
+	}
+	if r.Shard != nil {
+		controllerBuilder = controllerBuilder.Watches(&source.Channel{Source: r.Shard.Events()}, &handler.EnqueueRequestForObject{})
+	}
+	return controllerBuilder.Complete(r)
 }
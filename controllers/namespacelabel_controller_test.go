@@ -2,198 +2,689 @@ package controllers
 
 import (
 	"context"
-	"fmt"
-	"reflect"
-	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/pointer"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	omerv1 "omer.io/namespacelabel/api/v1"
+	"omer.io/namespacelabel/pkg/podsecurity"
 )
 
+// the label prefix the reconciler of the suite is configured to protect
+const protectedLabelPrefix = "protected.omer.io/"
+
+// the label prefix the reconciler of the suite only syncs for approved NamespaceLabels
+const approvalRequiredLabelPrefix = "approval.omer.io/"
+
+const (
+	timeout  = "25s"
+	interval = "250ms"
+)
+
+// createNamespace creates a namespace with a generated name, so every spec works in its own namespace
+func createNamespace(ctx context.Context, labels map[string]string) string {
+	namespaceObj := v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "nslabel-",
+			Labels:       labels,
+		},
+	}
+	Expect(k8sClient.Create(ctx, &namespaceObj)).Should(Succeed())
+	return namespaceObj.Name
+}
+
+func createNamespaceLabel(ctx context.Context, namespace, name string, labels map[string]string) {
+	nsLabel := omerv1.NamespaceLabel{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: omerv1.NamespaceLabelSpec{
+			Labels: labels,
+		},
+	}
+	Expect(k8sClient.Create(ctx, &nsLabel)).Should(Succeed())
+}
+
+// updateNamespaceLabel changes the spec labels of a NamespaceLabel, retrying when the status update of the controller wins the race
+func updateNamespaceLabel(ctx context.Context, namespace, name string, mutate func(labels map[string]string)) {
+	Eventually(func() error {
+		var nsLabel omerv1.NamespaceLabel
+		if err := k8sClient.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, &nsLabel); err != nil {
+			return err
+		}
+		mutate(nsLabel.Spec.Labels)
+		return k8sClient.Update(ctx, &nsLabel)
+	}, timeout, interval).Should(Succeed())
+}
+
+// expectReconciled waits until the controller reported the Synced condition for the current generation of the NamespaceLabel
+func expectReconciled(ctx context.Context, namespace, name string, status metav1.ConditionStatus) omerv1.NamespaceLabel {
+	var nsLabel omerv1.NamespaceLabel
+	Eventually(func(g Gomega) {
+		g.Expect(k8sClient.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, &nsLabel)).Should(Succeed())
+		g.Expect(nsLabel.Status.ObservedGeneration).Should(Equal(nsLabel.Generation))
+		condition := meta.FindStatusCondition(nsLabel.Status.Conditions, omerv1.ConditionTypeSynced)
+		g.Expect(condition).ShouldNot(BeNil())
+		g.Expect(condition.ObservedGeneration).Should(Equal(nsLabel.Generation))
+		g.Expect(condition.Status).Should(Equal(status))
+	}, timeout, interval).Should(Succeed())
+	return nsLabel
+}
+
+func getNamespaceLabels(ctx context.Context, namespace string) map[string]string {
+	var namespaceObj v1.Namespace
+	Expect(k8sClient.Get(ctx, types.NamespacedName{Name: namespace}, &namespaceObj)).Should(Succeed())
+	return namespaceObj.GetLabels()
+}
+
 var _ = Describe("NamespaceLabel controller", func() {
 
-	const (
-		timeout             = "25s"
-		interval            = "5s"
-		namespacelabelName1 = "a"
-		namespacelabelName2 = "b"
-		namespacelabelName3 = "c"
-		namespace           = "default"
+	var (
+		ctx       context.Context
+		namespace string
 	)
 
-	Context("When setting up the test environment", func() {
-		It("Should create NamespaceLabel custom resources", func() {
-			By("Creating a first NamespaceLabel custom resource")
-			ctx := context.Background()
-			nsLabel1 := omerv1.NamespaceLabel{
-				ObjectMeta: metav1.ObjectMeta{
-					Name:      namespacelabelName1,
-					Namespace: namespace,
-				},
+	BeforeEach(func() {
+		ctx = context.Background()
+		namespace = createNamespace(ctx, map[string]string{"unmanaged": "unmanaged"})
+	})
+
+	Context("When creating two NamespaceLabels", func() {
+		It("Should sync the labels of both to the namespace", func() {
+			createNamespaceLabel(ctx, namespace, "a", map[string]string{"a": "a", "b": "b"})
+			createNamespaceLabel(ctx, namespace, "b", map[string]string{"c": "c", "d": "d"})
+
+			nsLabel := expectReconciled(ctx, namespace, "a", metav1.ConditionTrue)
+			Expect(nsLabel.Status.SyncLabels).Should(Equal(map[string]string{"a": "a", "b": "b"}))
+			Expect(nsLabel.Finalizers).Should(ContainElement(nsLabelFinalizer))
+			nsLabel = expectReconciled(ctx, namespace, "b", metav1.ConditionTrue)
+			Expect(nsLabel.Status.SyncLabels).Should(Equal(map[string]string{"c": "c", "d": "d"}))
+
+			labels := getNamespaceLabels(ctx, namespace)
+			Expect(labels).Should(HaveKeyWithValue("a", "a"))
+			Expect(labels).Should(HaveKeyWithValue("b", "b"))
+			Expect(labels).Should(HaveKeyWithValue("c", "c"))
+			Expect(labels).Should(HaveKeyWithValue("d", "d"))
+			Expect(labels).Should(HaveKeyWithValue("unmanaged", "unmanaged"))
+		})
+	})
+
+	Context("When changing a synced label on the namespace", func() {
+		It("Should restore the value of the NamespaceLabel", func() {
+			createNamespaceLabel(ctx, namespace, "a", map[string]string{"a": "a"})
+			expectReconciled(ctx, namespace, "a", metav1.ConditionTrue)
+
+			By("Changing the label on the namespace")
+			Eventually(func() error {
+				var namespaceObj v1.Namespace
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: namespace}, &namespaceObj); err != nil {
+					return err
+				}
+				namespaceObj.Labels["a"] = "hara"
+				return k8sClient.Update(ctx, &namespaceObj)
+			}, timeout, interval).Should(Succeed())
+
+			Eventually(func() map[string]string {
+				return getNamespaceLabels(ctx, namespace)
+			}, timeout, interval).Should(HaveKeyWithValue("a", "a"))
+		})
+	})
+
+	Context("When changing the labels of a NamespaceLabel", func() {
+		It("Should add the new labels and remove the deleted ones from the namespace", func() {
+			createNamespaceLabel(ctx, namespace, "a", map[string]string{"a": "a", "b": "b"})
+			expectReconciled(ctx, namespace, "a", metav1.ConditionTrue)
+
+			updateNamespaceLabel(ctx, namespace, "a", func(labels map[string]string) {
+				delete(labels, "a")
+				labels["m"] = "m"
+			})
+
+			nsLabel := expectReconciled(ctx, namespace, "a", metav1.ConditionTrue)
+			Expect(nsLabel.Status.SyncLabels).Should(Equal(map[string]string{"b": "b", "m": "m"}))
+			labels := getNamespaceLabels(ctx, namespace)
+			Expect(labels).ShouldNot(HaveKey("a"))
+			Expect(labels).Should(HaveKeyWithValue("b", "b"))
+			Expect(labels).Should(HaveKeyWithValue("m", "m"))
+		})
+	})
+
+	Context("When a NamespaceLabel has a label that already exists in the namespace", func() {
+		It("Should skip the label and report it as not synced", func() {
+			createNamespaceLabel(ctx, namespace, "a", map[string]string{"unmanaged": "haragadol", "a": "a"})
+
+			nsLabel := expectReconciled(ctx, namespace, "a", metav1.ConditionFalse)
+			Expect(nsLabel.Status.SyncLabels).Should(Equal(map[string]string{"a": "a"}))
+			Expect(nsLabel.Status.UnSyncLabels).Should(Equal(map[string]string{"unmanaged": "haragadol"}))
+			condition := meta.FindStatusCondition(nsLabel.Status.Conditions, omerv1.ConditionTypeSynced)
+			Expect(condition.Reason).Should(Equal(omerv1.ReasonLabelsNotSynced))
+			Expect(condition.Message).Should(ContainSubstring("label unmanaged already exists in the namespace"))
+			Expect(getNamespaceLabels(ctx, namespace)).Should(HaveKeyWithValue("unmanaged", "unmanaged"))
+		})
+	})
+
+	Context("When a NamespaceLabel has a protected label", func() {
+		It("Should never write the label to the namespace", func() {
+			protectedLabel := protectedLabelPrefix + "team"
+			createNamespaceLabel(ctx, namespace, "a", map[string]string{protectedLabel: "a", "a": "a"})
+
+			nsLabel := expectReconciled(ctx, namespace, "a", metav1.ConditionFalse)
+			Expect(nsLabel.Status.UnSyncLabels).Should(Equal(map[string]string{protectedLabel: "a"}))
+			condition := meta.FindStatusCondition(nsLabel.Status.Conditions, omerv1.ConditionTypeSynced)
+			Expect(condition.Message).Should(ContainSubstring("label " + protectedLabel + " is protected"))
+			labels := getNamespaceLabels(ctx, namespace)
+			Expect(labels).ShouldNot(HaveKey(protectedLabel))
+			Expect(labels).Should(HaveKeyWithValue("a", "a"))
+		})
+	})
+
+	Context("When two NamespaceLabels claim the same label", func() {
+		It("Should sync the label of the oldest NamespaceLabel and report the conflict on the other", func() {
+			// both may get the same creation second, the name breaks the tie the same way
+			createNamespaceLabel(ctx, namespace, "a", map[string]string{"team": "a"})
+			expectReconciled(ctx, namespace, "a", metav1.ConditionTrue)
+			createNamespaceLabel(ctx, namespace, "b", map[string]string{"team": "b", "b": "b"})
+
+			nsLabel := expectReconciled(ctx, namespace, "b", metav1.ConditionFalse)
+			Expect(nsLabel.Status.SyncLabels).Should(Equal(map[string]string{"b": "b"}))
+			Expect(nsLabel.Status.UnSyncLabels).Should(Equal(map[string]string{"team": "b"}))
+			condition := meta.FindStatusCondition(nsLabel.Status.Conditions, omerv1.ConditionTypeSynced)
+			Expect(condition.Message).Should(ContainSubstring("label team is claimed by NamespaceLabel a"))
+			expectReconciled(ctx, namespace, "a", metav1.ConditionTrue)
+			Expect(getNamespaceLabels(ctx, namespace)).Should(HaveKeyWithValue("team", "a"))
+
+			By("Deleting the NamespaceLabel that owns the label")
+			Expect(k8sClient.Delete(ctx, &omerv1.NamespaceLabel{
+				ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: namespace},
+			})).Should(Succeed())
+
+			nsLabel = expectReconciled(ctx, namespace, "b", metav1.ConditionTrue)
+			Expect(nsLabel.Status.SyncLabels).Should(Equal(map[string]string{"team": "b", "b": "b"}))
+			Expect(getNamespaceLabels(ctx, namespace)).Should(HaveKeyWithValue("team", "b"))
+		})
+	})
+
+	Context("When a NamespaceLabel propagates its labels to objects", func() {
+		It("Should label the selected objects, count them and remove the labels on deletion", func() {
+			for _, name := range []string{"web", "db"} {
+				Expect(k8sClient.Create(ctx, &v1.ServiceAccount{
+					ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: map[string]string{"app": name}},
+				})).Should(Succeed())
+			}
+			Expect(k8sClient.Create(ctx, &omerv1.NamespaceLabel{
+				ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: namespace},
 				Spec: omerv1.NamespaceLabelSpec{
-					Labels: map[string]string{
-						"a": "a",
-						"b": "b",
+					Labels: map[string]string{"team": "a"},
+					PropagateTo: &omerv1.PropagationSpec{
+						Kinds:    []omerv1.PropagationKind{omerv1.PropagationKindServiceAccount},
+						Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
 					},
 				},
+			})).Should(Succeed())
+
+			getServiceAccountLabels := func(name string) map[string]string {
+				var serviceAccount v1.ServiceAccount
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, &serviceAccount)).Should(Succeed())
+				return serviceAccount.GetLabels()
 			}
-			Expect(k8sClient.Create(ctx, &nsLabel1)).Should(Succeed())
+			Eventually(func(g Gomega) {
+				nsLabel := expectReconciled(ctx, namespace, "a", metav1.ConditionTrue)
+				g.Expect(nsLabel.Status.Propagation).Should(Equal([]omerv1.PropagationStatus{
+					{Kind: omerv1.PropagationKindServiceAccount, Selected: 1, Labeled: 1},
+				}))
+			}, timeout, interval).Should(Succeed())
+			Expect(getServiceAccountLabels("web")).Should(HaveKeyWithValue("team", "a"))
+			Expect(getServiceAccountLabels("db")).ShouldNot(HaveKey("team"))
+
+			By("Creating a new selected object")
+			Expect(k8sClient.Create(ctx, &v1.ServiceAccount{
+				ObjectMeta: metav1.ObjectMeta{Name: "web-2", Namespace: namespace, Labels: map[string]string{"app": "web"}},
+			})).Should(Succeed())
+			Eventually(func() map[string]string {
+				return getServiceAccountLabels("web-2")
+			}, timeout, interval).Should(HaveKeyWithValue("team", "a"))
+
+			By("Deleting the NamespaceLabel")
+			Expect(k8sClient.Delete(ctx, &omerv1.NamespaceLabel{
+				ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: namespace},
+			})).Should(Succeed())
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, types.NamespacedName{Name: "a", Namespace: namespace}, &omerv1.NamespaceLabel{})
+				return apierrors.IsNotFound(err)
+			}, timeout, interval).Should(BeTrue())
+			Expect(getServiceAccountLabels("web")).ShouldNot(HaveKey("team"))
+			Expect(getServiceAccountLabels("web-2")).ShouldNot(HaveKey("team"))
+			Expect(getServiceAccountLabels("web")).Should(HaveKeyWithValue("app", "web"))
+		})
 
-			By("Creating another NamespaceLabel custom resource")
-			nsLabel2 := omerv1.NamespaceLabel{
+		It("Should remove the labels of an owner deleted without its finalizer", func() {
+			//the owner was force deleted, only the object still records it
+			Expect(k8sClient.Create(ctx, &v1.ServiceAccount{
 				ObjectMeta: metav1.ObjectMeta{
-					Name:      namespacelabelName2,
-					Namespace: namespace,
+					Name:        "web",
+					Namespace:   namespace,
+					Labels:      map[string]string{"app": "web", "team": "gone"},
+					Annotations: map[string]string{propagatedLabelsAnnotation: `{"team":"gone"}`},
 				},
+			})).Should(Succeed())
+			createNamespaceLabel(ctx, namespace, "a", map[string]string{"env": "a"})
+			expectReconciled(ctx, namespace, "a", metav1.ConditionTrue)
+			Eventually(func(g Gomega) {
+				var serviceAccount v1.ServiceAccount
+				g.Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "web", Namespace: namespace}, &serviceAccount)).Should(Succeed())
+				g.Expect(serviceAccount.Labels).Should(Equal(map[string]string{"app": "web"}))
+				g.Expect(serviceAccount.Annotations).ShouldNot(HaveKey(propagatedLabelsAnnotation))
+			}, timeout, interval).Should(Succeed())
+		})
+	})
+
+	Context("When a parent namespace has inheritable labels", func() {
+		It("Should sync them to the child namespace unless the child overrides them", func() {
+			Expect(k8sClient.Create(ctx, &omerv1.NamespaceLabel{
+				ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: namespace},
 				Spec: omerv1.NamespaceLabelSpec{
-					Labels: map[string]string{
-						"c": "c",
-						"d": "d",
-					},
+					Labels:      map[string]string{"cost-center": "a", "owner": "a", "private": "a"},
+					Inheritable: []string{"cost-center", "owner"},
+				},
+			})).Should(Succeed())
+			expectReconciled(ctx, namespace, "a", metav1.ConditionTrue)
+
+			childObj := v1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: "nslabel-",
+					Annotations:  map[string]string{parentAnnotation: namespace},
 				},
 			}
-			Expect(k8sClient.Create(ctx, &nsLabel2)).Should(Succeed())
+			Expect(k8sClient.Create(ctx, &childObj)).Should(Succeed())
+			child := childObj.Name
+			createNamespaceLabel(ctx, child, "b", map[string]string{"owner": "b"})
+			expectReconciled(ctx, child, "b", metav1.ConditionTrue)
+			Eventually(func() map[string]string {
+				return getNamespaceLabels(ctx, child)
+			}, timeout, interval).Should(HaveKeyWithValue("cost-center", "a"))
+			labels := getNamespaceLabels(ctx, child)
+			Expect(labels).Should(HaveKeyWithValue("owner", "b"))
+			Expect(labels).ShouldNot(HaveKey("private"))
+
+			By("Deleting the NamespaceLabel of the parent")
+			Expect(k8sClient.Delete(ctx, &omerv1.NamespaceLabel{
+				ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: namespace},
+			})).Should(Succeed())
+			Eventually(func() map[string]string {
+				return getNamespaceLabels(ctx, child)
+			}, timeout, interval).ShouldNot(HaveKey("cost-center"))
+			Expect(getNamespaceLabels(ctx, child)).Should(HaveKeyWithValue("owner", "b"))
 		})
-	})
 
-	Context("When creating two namespacelabel custom resources", func() {
-		It("Should sync the labels in the nslabels cr with the namespace's labels", func() {
-			time.Sleep(time.Second * 2)
-			By("Check if the labels in the nslabels is sync with the namespace")
-			var namespaceObj v1.Namespace
-			namespacedName := types.NamespacedName{Name: namespace}
-			Eventually(func() bool {
-				if err := k8sClient.Get(ctx, namespacedName, &namespaceObj); err != nil {
-					return false
+		It("Should sync the labels of the new parent when the parent annotation changes", func() {
+			Expect(k8sClient.Create(ctx, &omerv1.NamespaceLabel{
+				ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: namespace},
+				Spec: omerv1.NamespaceLabelSpec{
+					Labels:      map[string]string{"cost-center": "a"},
+					Inheritable: []string{"cost-center"},
+				},
+			})).Should(Succeed())
+			expectReconciled(ctx, namespace, "a", metav1.ConditionTrue)
+			otherParent := createNamespace(ctx, nil)
+
+			childObj := v1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: "nslabel-",
+					Annotations:  map[string]string{parentAnnotation: namespace},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &childObj)).Should(Succeed())
+			child := childObj.Name
+			createNamespaceLabel(ctx, child, "b", map[string]string{"owner": "b"})
+			Eventually(func() map[string]string {
+				return getNamespaceLabels(ctx, child)
+			}, timeout, interval).Should(HaveKeyWithValue("cost-center", "a"))
+
+			By("Moving the child under a parent without inheritable labels")
+			Eventually(func() error {
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: child}, &childObj); err != nil {
+					return err
 				}
-				return reflect.DeepEqual(map[string]string{
-					"a":                           "a",
-					"b":                           "b",
-					"c":                           "c",
-					"d":                           "d",
-					"kubernetes.io/metadata.name": "default"}, namespaceObj.GetLabels())
-			}, timeout, interval).Should(BeTrue())
+				childObj.Annotations[parentAnnotation] = otherParent
+				return k8sClient.Update(ctx, &childObj)
+			}, timeout, interval).Should(Succeed())
+			Eventually(func() map[string]string {
+				return getNamespaceLabels(ctx, child)
+			}, timeout, interval).ShouldNot(HaveKey("cost-center"))
+			Expect(getNamespaceLabels(ctx, child)).Should(HaveKeyWithValue("owner", "b"))
 		})
 	})
 
-	Context("When change one of the namespace labels that exist in the namespacelabel spec", func() {
-		It("Should resync, and update the label back to the label in the nslabel cr", func() {
-			time.Sleep(time.Second * 2)
-			By("change the value of one of the labels in the namespace")
-			var namespaceObj v1.Namespace
-			namespacedName := types.NamespacedName{Name: namespace}
-			k8sClient.Get(ctx, namespacedName, &namespaceObj)
-			namespaceObj.ObjectMeta.Labels["a"] = "hara"
-			k8sClient.Update(ctx, &namespaceObj)
-			Eventually(func() bool {
-				k8sClient.Get(ctx, namespacedName, &namespaceObj)
-				return reflect.DeepEqual(map[string]string{
-					"a":                           "a",
-					"b":                           "b",
-					"c":                           "c",
-					"d":                           "d",
-					"kubernetes.io/metadata.name": "default"}, namespaceObj.GetLabels())
-			}, timeout, interval).Should(BeTrue())
+	Context("When a NamespaceLabelRule matches the namespace name", func() {
+		It("Should sync the derived labels unless a NamespaceLabel sets them and remove them with the rule", func() {
+			//the generated suffix keeps the namespace apart from the other runs, the pattern matches it too
+			namespaceObj := &v1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{GenerateName: "payments-prod-api-", Annotations: map[string]string{"omer.io/owner": "alice"}},
+			}
+			Expect(k8sClient.Create(ctx, namespaceObj)).Should(Succeed())
+			derived := namespaceObj.Name
+			//the rule is cluster scoped, it gets a generated name too and is deleted even when the spec fails
+			rule := &omerv1.NamespaceLabelRule{
+				ObjectMeta: metav1.ObjectMeta{GenerateName: "naming-convention-"},
+				Spec: omerv1.NamespaceLabelRuleSpec{
+					NamePattern:     `(?P<team>[a-z]+)-(?P<env>dev|prod)-(?P<app>[a-z]+)-[a-z0-9]+`,
+					FromAnnotations: map[string]string{"omer.io/owner": "owner"},
+				},
+			}
+			Expect(k8sClient.Create(ctx, rule)).Should(Succeed())
+			DeferCleanup(func(ctx context.Context) {
+				Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, rule))).Should(Succeed())
+			})
+			createNamespaceLabel(ctx, derived, "a", map[string]string{"env": "staging"})
+			expectReconciled(ctx, derived, "a", metav1.ConditionTrue)
+			Eventually(func() map[string]string {
+				return getNamespaceLabels(ctx, derived)
+			}, timeout, interval).Should(And(
+				HaveKeyWithValue("team", "payments"),
+				HaveKeyWithValue("app", "api"),
+				HaveKeyWithValue("owner", "alice"),
+				HaveKeyWithValue("env", "staging"),
+			))
+
+			By("Deleting the NamespaceLabelRule")
+			Expect(k8sClient.Delete(ctx, rule)).Should(Succeed())
+			Eventually(func() map[string]string {
+				return getNamespaceLabels(ctx, derived)
+			}, timeout, interval).ShouldNot(Or(HaveKey("team"), HaveKey("app"), HaveKey("owner")))
+			Expect(getNamespaceLabels(ctx, derived)).Should(HaveKeyWithValue("env", "staging"))
 		})
 	})
 
-	Context("When delete one of the labels of nslabel object", func() {
-		It("Should resync, and delete the label from the cr and the namespace", func() {
-			time.Sleep(time.Second * 2)
-			By("delete the value from one of the labels in the nslabel cr")
-			var nslabel1 omerv1.NamespaceLabel
-			namespacedName := types.NamespacedName{Name: namespacelabelName1, Namespace: namespace}
-			k8sClient.Get(ctx, namespacedName, &nslabel1)
-			delete(nslabel1.Spec.Labels, "a")
-			k8sClient.Update(ctx, &nslabel1)
-			Eventually(func() bool {
-				var namespaceObj v1.Namespace
-				namespacedName = types.NamespacedName{Name: namespace}
-				k8sClient.Get(ctx, namespacedName, &namespaceObj)
-				namespacedName := types.NamespacedName{Name: namespacelabelName1, Namespace: namespace}
-				k8sClient.Get(ctx, namespacedName, &nslabel1)
-				fmt.Println(nslabel1.Status.SyncLabels)
-				return (reflect.DeepEqual(map[string]string{
-					"b":                           "b",
-					"c":                           "c",
-					"d":                           "d",
-					"kubernetes.io/metadata.name": "default"}, namespaceObj.GetLabels())) &&
-					(reflect.DeepEqual(map[string]string{
-						"b": "b"}, nslabel1.Status.SyncLabels))
-			}, timeout, interval).Should(BeTrue())
+	Context("When a NamespaceLabel sets a label that requires approval", func() {
+		It("Should keep the label pending until its generation is approved", func() {
+			createNamespaceLabel(ctx, namespace, "a", map[string]string{approvalRequiredLabelPrefix + "enforce": "restricted", "team": "a"})
+			nsLabel := expectReconciled(ctx, namespace, "a", metav1.ConditionFalse)
+			Expect(nsLabel.Status.PendingLabels).Should(Equal(map[string]string{approvalRequiredLabelPrefix + "enforce": "restricted"}))
+			Expect(meta.FindStatusCondition(nsLabel.Status.Conditions, omerv1.ConditionTypeSynced).Reason).Should(Equal(omerv1.ReasonApprovalPending))
+			Expect(getNamespaceLabels(ctx, namespace)).ShouldNot(HaveKey(approvalRequiredLabelPrefix + "enforce"))
+			Expect(getNamespaceLabels(ctx, namespace)).Should(HaveKeyWithValue("team", "a"))
+
+			By("Approving the generation")
+			Expect(k8sClient.Create(ctx, &omerv1.LabelChangeApproval{
+				ObjectMeta: metav1.ObjectMeta{Name: "a-1", Namespace: namespace},
+				Spec:       omerv1.LabelChangeApprovalSpec{NamespaceLabel: "a", NamespaceLabelUID: nsLabel.UID, Generation: nsLabel.Generation},
+			})).Should(Succeed())
+			nsLabel = expectReconciled(ctx, namespace, "a", metav1.ConditionTrue)
+			Expect(nsLabel.Status.PendingLabels).Should(BeEmpty())
+			Expect(getNamespaceLabels(ctx, namespace)).Should(HaveKeyWithValue(approvalRequiredLabelPrefix+"enforce", "restricted"))
+
+			By("Changing the label in a new generation")
+			updateNamespaceLabel(ctx, namespace, "a", func(labels map[string]string) {
+				labels[approvalRequiredLabelPrefix+"enforce"] = "baseline"
+			})
+			nsLabel = expectReconciled(ctx, namespace, "a", metav1.ConditionFalse)
+			Expect(nsLabel.Status.PendingLabels).Should(Equal(map[string]string{approvalRequiredLabelPrefix + "enforce": "baseline"}))
+			Expect(getNamespaceLabels(ctx, namespace)).Should(HaveKeyWithValue(approvalRequiredLabelPrefix+"enforce", "restricted"))
+
+			By("Removing the label in a new generation")
+			updateNamespaceLabel(ctx, namespace, "a", func(labels map[string]string) {
+				delete(labels, approvalRequiredLabelPrefix+"enforce")
+			})
+			nsLabel = expectReconciled(ctx, namespace, "a", metav1.ConditionFalse)
+			Expect(nsLabel.Status.PendingLabels).Should(Equal(map[string]string{approvalRequiredLabelPrefix + "enforce": "restricted"}))
+			Expect(getNamespaceLabels(ctx, namespace)).Should(HaveKeyWithValue(approvalRequiredLabelPrefix+"enforce", "restricted"))
+
+			By("Approving the removal")
+			Expect(k8sClient.Create(ctx, &omerv1.LabelChangeApproval{
+				ObjectMeta: metav1.ObjectMeta{Name: "a-removal", Namespace: namespace},
+				Spec:       omerv1.LabelChangeApprovalSpec{NamespaceLabel: "a", NamespaceLabelUID: nsLabel.UID, Generation: nsLabel.Generation},
+			})).Should(Succeed())
+			expectReconciled(ctx, namespace, "a", metav1.ConditionTrue)
+			Expect(getNamespaceLabels(ctx, namespace)).ShouldNot(HaveKey(approvalRequiredLabelPrefix + "enforce"))
+		})
+
+		It("Should propagate the approved label to the selected objects", func() {
+			Expect(k8sClient.Create(ctx, &v1.ServiceAccount{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: namespace},
+			})).Should(Succeed())
+			Expect(k8sClient.Create(ctx, &omerv1.NamespaceLabel{
+				ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: namespace},
+				Spec: omerv1.NamespaceLabelSpec{
+					Labels:      map[string]string{approvalRequiredLabelPrefix + "enforce": "restricted"},
+					PropagateTo: &omerv1.PropagationSpec{Kinds: []omerv1.PropagationKind{omerv1.PropagationKindServiceAccount}},
+				},
+			})).Should(Succeed())
+			nsLabel := expectReconciled(ctx, namespace, "a", metav1.ConditionFalse)
+
+			By("Approving the generation")
+			Expect(k8sClient.Create(ctx, &omerv1.LabelChangeApproval{
+				ObjectMeta: metav1.ObjectMeta{Name: "a-1", Namespace: namespace},
+				Spec:       omerv1.LabelChangeApprovalSpec{NamespaceLabel: "a", NamespaceLabelUID: nsLabel.UID, Generation: nsLabel.Generation},
+			})).Should(Succeed())
+			Eventually(func(g Gomega) {
+				nsLabel := expectReconciled(ctx, namespace, "a", metav1.ConditionTrue)
+				g.Expect(nsLabel.Status.Propagation).Should(Equal([]omerv1.PropagationStatus{
+					{Kind: omerv1.PropagationKindServiceAccount, Selected: 1, Labeled: 1},
+				}))
+			}, timeout, interval).Should(Succeed())
+			var serviceAccount v1.ServiceAccount
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "web", Namespace: namespace}, &serviceAccount)).Should(Succeed())
+			Expect(serviceAccount.Labels).Should(HaveKeyWithValue(approvalRequiredLabelPrefix+"enforce", "restricted"))
 		})
 	})
 
-	Context("When add label that already exists in the namespace", func() {
-		It("Should resync, and delete the label from the cr", func() {
-			time.Sleep(time.Second * 2)
-			By("add label to the nslabel cr that already exist in the ns")
-			var nslabel1 omerv1.NamespaceLabel
-			namespacedName := types.NamespacedName{Name: namespacelabelName1, Namespace: namespace}
-			k8sClient.Get(ctx, namespacedName, &nslabel1)
-			nslabel1.Spec.Labels["kubernetes.io/metadata.name"] = "haragadol"
-			k8sClient.Update(ctx, &nslabel1)
-			Eventually(func() bool {
-				k8sClient.Get(ctx, namespacedName, &nslabel1)
-				return (reflect.DeepEqual(map[string]string{
-					"b":                           "b",
-					"kubernetes.io/metadata.name": "haragadol",
-				}, nslabel1.Spec.Labels)) &&
-					(reflect.DeepEqual(map[string]string{
-						"kubernetes.io/metadata.name": "haragadol",
-					}, nslabel1.Status.UnSyncLabels))
-			}, timeout, interval).Should(BeTrue())
+	Context("When a NamespaceLabel sets the pod security labels", func() {
+		It("Should reject invalid levels and hold a level the existing pods violate", func() {
+			Expect(k8sClient.Create(ctx, &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "privileged", Namespace: namespace},
+				Spec: v1.PodSpec{Containers: []v1.Container{{
+					Name:            "app",
+					Image:           "busybox",
+					SecurityContext: &v1.SecurityContext{Privileged: pointer.Bool(true)},
+				}}},
+			})).Should(Succeed())
+			createNamespaceLabel(ctx, namespace, "a", map[string]string{
+				podsecurity.LabelPrefix + "warn":  "strict",
+				podsecurity.EnforceLabel:          podsecurity.LevelRestricted,
+				podsecurity.LabelPrefix + "audit": podsecurity.LevelRestricted,
+			})
+			nsLabel := expectReconciled(ctx, namespace, "a", metav1.ConditionFalse)
+			Expect(nsLabel.Status.UnSyncLabels).Should(Equal(map[string]string{
+				podsecurity.LabelPrefix + "warn": "strict",
+				podsecurity.EnforceLabel:         podsecurity.LevelRestricted,
+			}))
+			Expect(meta.FindStatusCondition(nsLabel.Status.Conditions, omerv1.ConditionTypeSynced).Message).Should(ContainSubstring("existing pods violate it"))
+			labels := getNamespaceLabels(ctx, namespace)
+			Expect(labels).ShouldNot(HaveKey(podsecurity.EnforceLabel))
+			Expect(labels).Should(HaveKeyWithValue(podsecurity.LabelPrefix+"audit", podsecurity.LevelRestricted))
+
+			By("Enforcing a level the pods do not violate")
+			updateNamespaceLabel(ctx, namespace, "a", func(labels map[string]string) {
+				delete(labels, podsecurity.LabelPrefix+"warn")
+				labels[podsecurity.EnforceLabel] = podsecurity.LevelPrivileged
+			})
+			expectReconciled(ctx, namespace, "a", metav1.ConditionTrue)
+			Expect(getNamespaceLabels(ctx, namespace)).Should(HaveKeyWithValue(podsecurity.EnforceLabel, podsecurity.LevelPrivileged))
+
+			By("Raising the enforced level above what the pods allow")
+			updateNamespaceLabel(ctx, namespace, "a", func(labels map[string]string) {
+				labels[podsecurity.EnforceLabel] = podsecurity.LevelRestricted
+			})
+			nsLabel = expectReconciled(ctx, namespace, "a", metav1.ConditionFalse)
+			Expect(nsLabel.Status.UnSyncLabels).Should(Equal(map[string]string{podsecurity.EnforceLabel: podsecurity.LevelRestricted}))
+			Expect(getNamespaceLabels(ctx, namespace)).Should(HaveKeyWithValue(podsecurity.EnforceLabel, podsecurity.LevelPrivileged))
 		})
 	})
 
-	Context("When add new label to the nslabelobject", func() {
-		It("Should resync, and add the label to the ns", func() {
-			time.Sleep(time.Second * 2)
-			By("add new label to the nslabel cr")
-			var nslabel1 omerv1.NamespaceLabel
-			namespacedName := types.NamespacedName{Name: namespacelabelName1, Namespace: namespace}
-			k8sClient.Get(ctx, namespacedName, &nslabel1)
-			nslabel1.Spec.Labels["m"] = "m"
-			k8sClient.Update(ctx, &nslabel1)
-			Eventually(func() bool {
+	Context("When a NamespaceLabel or its namespace is suspended", func() {
+		It("Should report the drift without writing the namespace until it is resumed", func() {
+			createNamespaceLabel(ctx, namespace, "a", map[string]string{"team": "a"})
+			expectReconciled(ctx, namespace, "a", metav1.ConditionTrue)
+
+			By("Suspending the NamespaceLabel and changing its labels")
+			Eventually(func() error {
+				var nsLabel omerv1.NamespaceLabel
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: "a", Namespace: namespace}, &nsLabel); err != nil {
+					return err
+				}
+				nsLabel.Spec.Suspend = true
+				nsLabel.Spec.Labels = map[string]string{"team": "b"}
+				return k8sClient.Update(ctx, &nsLabel)
+			}, timeout, interval).Should(Succeed())
+			nsLabel := expectReconciled(ctx, namespace, "a", metav1.ConditionFalse)
+			condition := meta.FindStatusCondition(nsLabel.Status.Conditions, omerv1.ConditionTypeSuspended)
+			Expect(condition.Status).Should(Equal(metav1.ConditionTrue))
+			Expect(condition.Reason).Should(Equal(omerv1.ReasonSuspended))
+			Expect(condition.Message).Should(ContainSubstring("team"))
+			Expect(getNamespaceLabels(ctx, namespace)).Should(HaveKeyWithValue("team", "a"))
+
+			By("Pausing the namespace and resuming the NamespaceLabel")
+			Eventually(func() error {
 				var namespaceObj v1.Namespace
-				namespacedName = types.NamespacedName{Name: namespace}
-				k8sClient.Get(ctx, namespacedName, &namespaceObj)
-
-				return reflect.DeepEqual(map[string]string{
-					"b":                           "b",
-					"c":                           "c",
-					"d":                           "d",
-					"kubernetes.io/metadata.name": "default",
-					"m":                           "m",
-				}, namespaceObj.ObjectMeta.Labels)
-			}, timeout, interval).Should(BeTrue())
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: namespace}, &namespaceObj); err != nil {
+					return err
+				}
+				namespaceObj.Annotations[pausedAnnotation] = "true"
+				return k8sClient.Update(ctx, &namespaceObj)
+			}, timeout, interval).Should(Succeed())
+			Eventually(func() error {
+				var nsLabel omerv1.NamespaceLabel
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: "a", Namespace: namespace}, &nsLabel); err != nil {
+					return err
+				}
+				nsLabel.Spec.Suspend = false
+				return k8sClient.Update(ctx, &nsLabel)
+			}, timeout, interval).Should(Succeed())
+			nsLabel = expectReconciled(ctx, namespace, "a", metav1.ConditionFalse)
+			Expect(meta.FindStatusCondition(nsLabel.Status.Conditions, omerv1.ConditionTypeSuspended).Reason).Should(Equal(omerv1.ReasonNamespacePaused))
+			Expect(getNamespaceLabels(ctx, namespace)).Should(HaveKeyWithValue("team", "a"))
+
+			By("Resuming the namespace")
+			Eventually(func() error {
+				var namespaceObj v1.Namespace
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: namespace}, &namespaceObj); err != nil {
+					return err
+				}
+				delete(namespaceObj.Annotations, pausedAnnotation)
+				return k8sClient.Update(ctx, &namespaceObj)
+			}, timeout, interval).Should(Succeed())
+			Eventually(func() map[string]string {
+				return getNamespaceLabels(ctx, namespace)
+			}, timeout, interval).Should(HaveKeyWithValue("team", "b"))
+			nsLabel = expectReconciled(ctx, namespace, "a", metav1.ConditionTrue)
+			Expect(meta.FindStatusCondition(nsLabel.Status.Conditions, omerv1.ConditionTypeSuspended).Status).Should(Equal(metav1.ConditionFalse))
 		})
 	})
-	Context("When delete nslabel object", func() {
-		It("Should resync, and remove the sync labels from the ns", func() {
-			time.Sleep(time.Second * 2)
-			By("delete nslabel cr")
-			var nslabel1 omerv1.NamespaceLabel
-			namespacedName := types.NamespacedName{Name: namespacelabelName1, Namespace: namespace}
-			k8sClient.Get(ctx, namespacedName, &nslabel1)
-			k8sClient.Delete(ctx, &nslabel1)
+
+	Context("When deleting a NamespaceLabel", func() {
+		It("Should remove only its labels from the namespace and release it", func() {
+			createNamespaceLabel(ctx, namespace, "a", map[string]string{"a": "a", "unmanaged": "a"})
+			createNamespaceLabel(ctx, namespace, "b", map[string]string{"b": "b"})
+			expectReconciled(ctx, namespace, "a", metav1.ConditionFalse)
+			expectReconciled(ctx, namespace, "b", metav1.ConditionTrue)
+
+			Expect(k8sClient.Delete(ctx, &omerv1.NamespaceLabel{
+				ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: namespace},
+			})).Should(Succeed())
+
 			Eventually(func() bool {
-				var namespaceObj v1.Namespace
-				namespacedName = types.NamespacedName{Name: namespace}
-				k8sClient.Get(ctx, namespacedName, &namespaceObj)
-
-				return reflect.DeepEqual(map[string]string{
-					"c":                           "c",
-					"d":                           "d",
-					"kubernetes.io/metadata.name": "default",
-				}, namespaceObj.ObjectMeta.Labels)
+				var nsLabel omerv1.NamespaceLabel
+				err := k8sClient.Get(ctx, types.NamespacedName{Name: "a", Namespace: namespace}, &nsLabel)
+				return apierrors.IsNotFound(err)
 			}, timeout, interval).Should(BeTrue())
+			labels := getNamespaceLabels(ctx, namespace)
+			Expect(labels).ShouldNot(HaveKey("a"))
+			Expect(labels).Should(HaveKeyWithValue("b", "b"))
+			Expect(labels).Should(HaveKeyWithValue("unmanaged", "unmanaged"))
+		})
+	})
+})
+
+// patchCounter counts the patches of namespaces sent through the client
+type patchCounter struct {
+	client.Client
+	namespacePatches int
+}
+
+func (c *patchCounter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if _, isNamespace := obj.(*v1.Namespace); isNamespace {
+		c.namespacePatches++
+	}
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+var _ = Describe("Namespace reconcile", func() {
+
+	Context("When a namespace has several NamespaceLabels", func() {
+		It("Should merge the labels of every NamespaceLabel in one patch and not write a synced namespace", func() {
+			scheme := runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).Should(Succeed())
+			Expect(omerv1.AddToScheme(scheme)).Should(Succeed())
+			deletedAt := metav1.Now()
+			c := &patchCounter{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+				&v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+					Name:        "ns",
+					Labels:      map[string]string{"old": "c"},
+					Annotations: map[string]string{managedLabelsAnnotation: `{"old":"c"}`},
+				}},
+				&omerv1.NamespaceLabel{
+					ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "ns"},
+					Spec:       omerv1.NamespaceLabelSpec{Labels: map[string]string{"team": "a"}},
+				},
+				&omerv1.NamespaceLabel{
+					ObjectMeta: metav1.ObjectMeta{Name: "b", Namespace: "ns"},
+					Spec:       omerv1.NamespaceLabelSpec{Labels: map[string]string{"env": "b"}},
+				},
+				&omerv1.NamespaceLabel{
+					ObjectMeta: metav1.ObjectMeta{Name: "c", Namespace: "ns", DeletionTimestamp: &deletedAt, Finalizers: []string{nsLabelFinalizer}},
+					Spec:       omerv1.NamespaceLabelSpec{Labels: map[string]string{"old": "c"}},
+				},
+			).Build()}
+			r := &NamespaceLabelReconciler{Client: c, Scheme: scheme}
+			ctx := context.Background()
+			request := ctrl.Request{NamespacedName: types.NamespacedName{Name: "ns"}}
+			_, err := r.Reconcile(ctx, request)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(c.namespacePatches).Should(Equal(1))
+			var namespace v1.Namespace
+			Expect(c.Get(ctx, types.NamespacedName{Name: "ns"}, &namespace)).Should(Succeed())
+			Expect(namespace.Labels).Should(Equal(map[string]string{"team": "a", "env": "b"}))
+
+			By("Reconciling the synced namespace again")
+			c.namespacePatches = 0
+			_, err = r.Reconcile(ctx, request)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(c.namespacePatches).Should(BeZero())
+		})
+	})
+
+	Context("When a NamespaceLabel event is mapped", func() {
+		It("Should request its namespace and the descendants of the namespace", func() {
+			scheme := runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).Should(Succeed())
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+				&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "parent"}},
+				&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "child", Annotations: map[string]string{parentAnnotation: "parent"}}},
+				&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other"}},
+			).Build()
+			r := &NamespaceLabelReconciler{Client: c, Scheme: scheme}
+
+			namespaceLabel := &omerv1.NamespaceLabel{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "parent"}}
+			Expect(r.namespaceOfObject(namespaceLabel)).Should(Equal([]reconcile.Request{
+				{NamespacedName: types.NamespacedName{Name: "parent"}},
+			}))
+			Expect(r.namespaceAndDescendantsOfNamespaceLabel(namespaceLabel)).Should(Equal([]reconcile.Request{
+				{NamespacedName: types.NamespacedName{Name: "parent"}},
+				{NamespacedName: types.NamespacedName{Name: "child"}},
+			}))
 		})
 	})
 })
@@ -0,0 +1,240 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/exp/slices"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	configv1 "omer.io/namespacelabel/api/config/v1"
+	omerv1 "omer.io/namespacelabel/api/v1"
+	"omer.io/namespacelabel/pkg/sharding"
+)
+
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+
+// OrphanLabelSweeper periodically scans all namespaces for labels recorded as managed
+// by a NamespaceLabel that no longer exists or no longer claims them. This happens when
+// a NamespaceLabel is force deleted (the finalizer removed by hand) while the controller is down.
+type OrphanLabelSweeper struct {
+	client.Client
+	Recorder record.EventRecorder
+	// Interval is the time between two sweeps
+	Interval time.Duration
+	// Policy decides if orphaned labels are removed from the namespace or only reported
+	Policy configv1.OrphanLabelPolicy
+	// Shard restricts the sweeps to the namespaces owned by this replica when sharding is enabled
+	Shard *sharding.Coordinator
+	// Paused only reports the orphaned labels whatever the Policy, like for the paused namespaces
+	Paused bool
+	// Reader reads the owners of the orphaned labels again, bypassing the cache, right before they
+	// are removed. The Client is used when nil
+	Reader client.Reader
+}
+
+// NeedLeaderElection makes the sweeper run only on the leader, like the controller itself.
+// Sharding turns leader election off, the manager then starts the sweeper on every replica
+// and the Shard keeps each one to the namespaces it owns
+func (s *OrphanLabelSweeper) NeedLeaderElection() bool {
+	return true
+}
+
+// Start runs a sweep every Interval until the context is cancelled
+func (s *OrphanLabelSweeper) Start(ctx context.Context) error {
+	logger := ctrllog.FromContext(ctx).WithName("orphan-sweeper")
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := s.Sweep(ctx); err != nil {
+			logger.Error(err, "orphaned labels sweep failed")
+		}
+	}, s.Interval)
+	return nil
+}
+
+// Sweep runs a single scan over all namespaces
+func (s *OrphanLabelSweeper) Sweep(ctx context.Context) error {
+	logger := ctrllog.FromContext(ctx).WithName("orphan-sweeper")
+
+	//the namespaces are listed first: a label synced after this list is not in it yet, while listing the
+	//nslabels first would find the label of an nslabel created in between without its owner
+	var namespaceList v1.NamespaceList
+	if err := s.List(ctx, &namespaceList); err != nil {
+		return err
+	}
+	var namespaceLabelList omerv1.NamespaceLabelList
+	if err := s.List(ctx, &namespaceLabelList); err != nil {
+		return err
+	}
+	claimedLabels := make(map[string]map[string]bool)
+	inheritedLabels := make(map[string]map[string]bool)
+	suspendedOwners := make(map[string]bool)
+	for _, namespaceLabel := range namespaceLabelList.Items {
+		//a suspended nslabel keeps the labels it managed until it is resumed
+		if namespaceLabel.Spec.Suspend {
+			suspendedOwners[inheritedOwner(namespaceLabel.Namespace, namespaceLabel.Name)] = true
+		}
+		claimedLabels[inheritedOwner(namespaceLabel.Namespace, namespaceLabel.Name)] = claimedKeys(namespaceLabel, false)
+		inheritedLabels[inheritedOwner(namespaceLabel.Namespace, namespaceLabel.Name)] = claimedKeys(namespaceLabel, true)
+	}
+
+	for i := range namespaceList.Items {
+		namespace := &namespaceList.Items[i]
+		if s.Shard != nil && !s.Shard.Owns(namespace.Name) {
+			continue
+		}
+		managedLabels := getManagedLabels(namespace)
+		orphanedLabels := make(map[string]string)
+		for key, owner := range managedLabels {
+			//the reconcile removes the labels of a deleted rule, it finds the rules gone when it starts
+			if isDerivedOwner(owner) {
+				continue
+			}
+			//inherited labels are owned by an nslabel of an ancestor, its owner is already namespace/name
+			claimedBy, claimed := inheritedOwner(namespace.Name, owner), claimedLabels
+			if isInheritedOwner(owner) {
+				claimedBy, claimed = owner, inheritedLabels
+			}
+			if !claimed[claimedBy][key] && !suspendedOwners[claimedBy] {
+				orphanedLabels[key] = owner
+			}
+		}
+		if len(orphanedLabels) == 0 {
+			continue
+		}
+		if err := s.handleOrphanedLabels(ctx, logger, namespace, orphanedLabels); err != nil {
+			logger.Error(err, "unable to handle orphaned labels", "namespace", namespace.Name)
+		}
+	}
+	return nil
+}
+
+func (s *OrphanLabelSweeper) handleOrphanedLabels(ctx context.Context, logger logr.Logger, namespace *v1.Namespace,
+	orphanedLabels map[string]string) error {
+	keys := make([]string, 0, len(orphanedLabels))
+	for key := range orphanedLabels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	logger.Info("found orphaned labels", "namespace", namespace.Name, "labels", keys, "policy", s.Policy)
+
+	if s.Policy != configv1.OrphanLabelPolicyRemove || s.Paused || isNamespacePaused(namespace) {
+		s.event(namespace, v1.EventTypeWarning, "OrphanedLabels",
+			"labels managed by a deleted NamespaceLabel: "+strings.Join(keys, ","))
+		return nil
+	}
+
+	//the patch replaces the whole managed labels annotation, it is sent with the resource version so it
+	//never overwrites the one a reconcile just wrote, and retried on a conflict with a fresh read
+	var removedKeys []string
+	isFirstAttempt := true
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if !isFirstAttempt {
+			if err := s.reader().Get(ctx, client.ObjectKeyFromObject(namespace), namespace); err != nil {
+				return err
+			}
+		}
+		isFirstAttempt = false
+
+		removedKeys = nil
+		managedLabels := getManagedLabels(namespace)
+		patch := client.MergeFromWithOptions(namespace.DeepCopy(), client.MergeFromWithOptimisticLock{})
+		for _, key := range keys {
+			if managedLabels[key] != orphanedLabels[key] {
+				continue
+			}
+			//the owner is read again, it may have claimed the label since the sweep listed the nslabels
+			isClaimed, err := s.isClaimed(ctx, namespace.Name, orphanedLabels[key], key)
+			if err != nil {
+				return err
+			}
+			if isClaimed {
+				continue
+			}
+			delete(namespace.ObjectMeta.Labels, key)
+			delete(managedLabels, key)
+			removedKeys = append(removedKeys, key)
+		}
+		if len(removedKeys) == 0 {
+			return nil
+		}
+		setManagedLabels(namespace, managedLabels)
+		return s.Patch(ctx, namespace, patch)
+	})
+	if err != nil || len(removedKeys) == 0 {
+		return client.IgnoreNotFound(err)
+	}
+	s.event(namespace, v1.EventTypeNormal, "OrphanedLabelsRemoved",
+		"removed labels managed by a deleted NamespaceLabel: "+strings.Join(removedKeys, ","))
+	return nil
+}
+
+// the function read the owner of a managed label, bypassing the cache, and tell if it still claims the label
+func (s *OrphanLabelSweeper) isClaimed(ctx context.Context, namespace string, owner string, key string) (bool, error) {
+	name := owner
+	if isInheritedOwner(owner) {
+		namespace, name, _ = strings.Cut(owner, "/")
+	}
+	var namespaceLabel omerv1.NamespaceLabel
+	if err := s.reader().Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &namespaceLabel); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	return namespaceLabel.Spec.Suspend || claimedKeys(namespaceLabel, isInheritedOwner(owner))[key], nil
+}
+
+func (s *OrphanLabelSweeper) reader() client.Reader {
+	if s.Reader == nil {
+		return s.Client
+	}
+	return s.Reader
+}
+
+// a label is claimed when its owner still has it in the spec or in the synced status,
+// the descendant namespaces only inherit the keys that are still inheritable
+func claimedKeys(namespaceLabel omerv1.NamespaceLabel, isInherited bool) map[string]bool {
+	claimed := make(map[string]bool)
+	for key := range namespaceLabel.Spec.Labels {
+		claimed[key] = true
+	}
+	for key := range namespaceLabel.Status.SyncLabels {
+		claimed[key] = true
+	}
+	if isInherited {
+		for key := range claimed {
+			if !slices.Contains(namespaceLabel.Spec.Inheritable, key) {
+				delete(claimed, key)
+			}
+		}
+	}
+	return claimed
+}
+
+func (s *OrphanLabelSweeper) event(namespace *v1.Namespace, eventType string, reason string, message string) {
+	if s.Recorder != nil {
+		s.Recorder.Event(namespace, eventType, reason, message)
+	}
+}
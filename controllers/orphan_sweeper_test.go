@@ -0,0 +1,126 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	configv1 "omer.io/namespacelabel/api/config/v1"
+	omerv1 "omer.io/namespacelabel/api/v1"
+)
+
+var _ = Describe("Orphaned labels sweeper", func() {
+
+	Context("When a namespace has a managed label whose NamespaceLabel is gone", func() {
+		It("Should report and then remove only the orphaned label", func() {
+			ctx := context.Background()
+			By("Creating a namespace with an orphaned managed label")
+			namespaceObj := v1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: "nslabel-",
+					Labels: map[string]string{
+						"orphan": "orphan",
+						"kept":   "kept",
+					},
+					Annotations: map[string]string{
+						managedLabelsAnnotation: `{"orphan":"force-deleted"}`,
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &namespaceObj)).Should(Succeed())
+			namespace := namespaceObj.Name
+
+			By("Sweeping with the Report policy")
+			sweeper := &OrphanLabelSweeper{Client: k8sClient, Policy: configv1.OrphanLabelPolicyReport}
+			Expect(sweeper.Sweep(ctx)).Should(Succeed())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: namespace}, &namespaceObj)).Should(Succeed())
+			Expect(namespaceObj.GetLabels()).Should(HaveKeyWithValue("orphan", "orphan"))
+
+			By("Sweeping with the Remove policy")
+			sweeper.Policy = configv1.OrphanLabelPolicyRemove
+			Expect(sweeper.Sweep(ctx)).Should(Succeed())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: namespace}, &namespaceObj)).Should(Succeed())
+			Expect(namespaceObj.GetLabels()).ShouldNot(HaveKey("orphan"))
+			Expect(namespaceObj.GetLabels()).Should(HaveKeyWithValue("kept", "kept"))
+			Expect(namespaceObj.GetAnnotations()).ShouldNot(HaveKey(managedLabelsAnnotation))
+		})
+
+		It("Should read the owners again before removing their labels", func() {
+			ctx := context.Background()
+			scheme := runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).Should(Succeed())
+			Expect(omerv1.AddToScheme(scheme)).Should(Succeed())
+			namespace := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name:        "ns",
+				Labels:      map[string]string{"team": "a", "orphan": "b"},
+				Annotations: map[string]string{managedLabelsAnnotation: `{"orphan":"gone","team":"a"}`},
+			}}
+			By("Listing no NamespaceLabel from the cache while the live read finds the one that claimed the label in between")
+			cached := fake.NewClientBuilder().WithScheme(scheme).WithObjects(namespace).Build()
+			live := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&omerv1.NamespaceLabel{
+				ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "ns"},
+				Spec:       omerv1.NamespaceLabelSpec{Labels: map[string]string{"team": "a"}},
+			}).Build()
+
+			sweeper := &OrphanLabelSweeper{Client: cached, Reader: live, Policy: configv1.OrphanLabelPolicyRemove}
+			Expect(sweeper.Sweep(ctx)).Should(Succeed())
+			Expect(cached.Get(ctx, client.ObjectKeyFromObject(namespace), namespace)).Should(Succeed())
+			Expect(namespace.GetLabels()).ShouldNot(HaveKey("orphan"))
+			Expect(namespace.GetLabels()).Should(HaveKeyWithValue("team", "a"))
+			Expect(getManagedLabels(namespace)).Should(Equal(map[string]string{"team": "a"}))
+		})
+	})
+
+	Context("When a namespace inherited a label that is no longer inheritable", func() {
+		It("Should remove the inherited label the ancestor still has", func() {
+			ctx := context.Background()
+			scheme := runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).Should(Succeed())
+			Expect(omerv1.AddToScheme(scheme)).Should(Succeed())
+			child := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name:        "child",
+				Labels:      map[string]string{"team": "a", "tier": "b"},
+				Annotations: map[string]string{managedLabelsAnnotation: `{"team":"parent/a","tier":"parent/a"}`},
+			}}
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(child, &omerv1.NamespaceLabel{
+				ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "parent"},
+				Spec: omerv1.NamespaceLabelSpec{
+					Labels:      map[string]string{"team": "a", "tier": "b"},
+					Inheritable: []string{"team"},
+				},
+				Status: omerv1.NamespaceLabelStatus{SyncLabels: map[string]string{"team": "a", "tier": "b"}},
+			}).Build()
+
+			sweeper := &OrphanLabelSweeper{Client: c, Policy: configv1.OrphanLabelPolicyRemove}
+			Expect(sweeper.Sweep(ctx)).Should(Succeed())
+			Expect(c.Get(ctx, client.ObjectKeyFromObject(child), child)).Should(Succeed())
+			Expect(child.GetLabels()).Should(HaveKeyWithValue("team", "a"))
+			Expect(child.GetLabels()).ShouldNot(HaveKey("tier"))
+			Expect(getManagedLabels(child)).Should(Equal(map[string]string{"team": "parent/a"}))
+		})
+	})
+})
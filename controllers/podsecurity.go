@@ -0,0 +1,58 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"omer.io/namespacelabel/pkg/labelsync"
+	"omer.io/namespacelabel/pkg/podsecurity"
+)
+
+// reasonPodSecurityViolations means the enforce level is held, existing pods of the namespace violate it
+const reasonPodSecurityViolations labelsync.Reason = "PodSecurityViolations"
+
+// the function hold the enforce labels of the plan when they make the pod security of the namespace
+// stricter and the dry-run of the update warns about existing pods violating the new level
+func (r *NamespaceLabelReconciler) checkPodSecurity(ctx context.Context, namespace *v1.Namespace, managedLabels map[string]string, plan labelsync.Plan) error {
+	if r.PodSecurity == nil {
+		return nil
+	}
+	labels := plan.Labels(namespace.Labels)
+	if !podsecurity.IsStricter(namespace.Labels, labels) {
+		return nil
+	}
+
+	warnings, err := r.PodSecurity.DryRun(ctx, namespace, labels)
+	if err != nil {
+		return err
+	}
+	if len(warnings) == 0 {
+		return nil
+	}
+	ctrllog.FromContext(ctx).Info("stricter pod security level is held, existing pods violate it",
+		"namespace", namespace.Name, "level", labels[podsecurity.EnforceLabel], "warnings", warnings)
+	message := strings.Join(warnings, "; ")
+	for _, key := range []string{podsecurity.EnforceLabel, podsecurity.EnforceVersionLabel} {
+		plan.Hold(key, reasonPodSecurityViolations, message, namespace.Labels, managedLabels)
+	}
+	return nil
+}
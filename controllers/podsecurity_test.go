@@ -0,0 +1,74 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"omer.io/namespacelabel/pkg/labelsync"
+	"omer.io/namespacelabel/pkg/podsecurity"
+)
+
+// dryRunFunc is a DryRunner returning the warnings of the function
+type dryRunFunc func(labels map[string]string) []string
+
+func (f dryRunFunc) DryRun(_ context.Context, _ *v1.Namespace, labels map[string]string) ([]string, error) {
+	return f(labels), nil
+}
+
+var _ = Describe("Pod security check", func() {
+	//the pods of the namespace only violate the restricted level
+	violations := dryRunFunc(func(labels map[string]string) []string {
+		if labels[podsecurity.EnforceLabel] == podsecurity.LevelRestricted {
+			return []string{"web: privileged"}
+		}
+		return nil
+	})
+
+	DescribeTable("Should hold a stricter enforced level the existing pods violate",
+		func(current map[string]string, desired map[string]string, level string, isHeld bool) {
+			r := &NamespaceLabelReconciler{PodSecurity: violations}
+			namespace := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns", Labels: current}}
+			previous := make(map[string]string)
+			for key := range current {
+				previous[key] = "a"
+			}
+			plan := labelsync.NewPlan([]labelsync.Source{{Name: "a", Labels: desired}}, current, previous, r.syncPolicy())
+			Expect(r.checkPodSecurity(context.Background(), namespace, previous, plan)).Should(Succeed())
+			skip, isFound := plan.Results["a"].Skipped[podsecurity.EnforceLabel]
+			Expect(isFound).Should(Equal(isHeld))
+			if isHeld {
+				Expect(skip.Reason).Should(Equal(reasonPodSecurityViolations))
+			}
+			Expect(plan.Labels(current)[podsecurity.EnforceLabel]).Should(Equal(level))
+		},
+		Entry("stricter level without violations",
+			nil, map[string]string{podsecurity.EnforceLabel: podsecurity.LevelBaseline},
+			podsecurity.LevelBaseline, false),
+		Entry("stricter level with violations",
+			map[string]string{podsecurity.EnforceLabel: podsecurity.LevelBaseline}, map[string]string{podsecurity.EnforceLabel: podsecurity.LevelRestricted},
+			podsecurity.LevelBaseline, true),
+		Entry("looser level is not checked",
+			map[string]string{podsecurity.EnforceLabel: podsecurity.LevelRestricted}, map[string]string{podsecurity.EnforceLabel: podsecurity.LevelBaseline},
+			podsecurity.LevelBaseline, false),
+	)
+})
@@ -0,0 +1,285 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	omerv1 "omer.io/namespacelabel/api/v1"
+)
+
+// the watch events of the controller, by watched kind and by whether the predicates filtered them
+var watchEvents = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "namespacelabel_watch_events_total",
+		Help: "Number of watch events seen by the namespacelabel controller, by kind and result (filtered or processed)",
+	},
+	[]string{"kind", "result"},
+)
+
+// the namespace writes delayed by the write limits or the circuit breaker, by reason
+var throttledWrites = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "namespacelabel_throttled_writes_total",
+		Help: "Number of namespace writes delayed by the namespacelabel controller, by reason (RateLimited or CircuitOpen)",
+	},
+	[]string{"reason"},
+)
+
+func init() {
+	metrics.Registry.MustRegister(watchEvents, throttledWrites)
+}
+
+// countingPredicate counts the events its predicate lets through and filters out
+type countingPredicate struct {
+	predicate.Predicate
+	kind string
+}
+
+func (p countingPredicate) count(processed bool) bool {
+	result := "filtered"
+	if processed {
+		result = "processed"
+	}
+	watchEvents.WithLabelValues(p.kind, result).Inc()
+	return processed
+}
+
+func (p countingPredicate) Create(e event.CreateEvent) bool {
+	return p.count(p.Predicate.Create(e))
+}
+
+func (p countingPredicate) Delete(e event.DeleteEvent) bool {
+	return p.count(p.Predicate.Delete(e))
+}
+
+func (p countingPredicate) Update(e event.UpdateEvent) bool {
+	return p.count(p.Predicate.Update(e))
+}
+
+func (p countingPredicate) Generic(e event.GenericEvent) bool {
+	return p.count(p.Predicate.Generic(e))
+}
+
+// the nslabel spec changes bump the generation, the status and finalizer writes of the reconciler do not.
+// the deletion is matched on its own, the labels of a deleted nslabel have to be removed
+func namespaceLabelPredicate() predicate.Predicate {
+	return predicate.Or(
+		predicate.GenerationChangedPredicate{},
+		predicate.Funcs{
+			UpdateFunc: func(e event.UpdateEvent) bool {
+				return e.ObjectOld.GetDeletionTimestamp().IsZero() && !e.ObjectNew.GetDeletionTimestamp().IsZero()
+			},
+		},
+	)
+}
+
+// the cache indexes the namespace predicate looks the changed label and annotation keys up in
+const (
+	// namespaceLabelKeyIndex indexes the nslabels of every namespace by the label keys of their spec
+	namespaceLabelKeyIndex = "spec.labels.keys"
+	// ruleAnnotationIndex indexes the rules by the annotations they derive labels from
+	ruleAnnotationIndex = "spec.fromAnnotations.keys"
+	// ruleLabelKeyIndex indexes the rules by the label keys they derive
+	ruleLabelKeyIndex = "spec.derivedLabelKeys"
+)
+
+// predicateIndexes are registered in the cache by SetupWithManager, the namespace predicate
+// finds the nslabels and the rules of a changed key without listing them all
+var predicateIndexes = []struct {
+	object  client.Object
+	field   string
+	extract client.IndexerFunc
+}{
+	{
+		object: &omerv1.NamespaceLabel{},
+		field:  namespaceLabelKeyIndex,
+		extract: func(object client.Object) []string {
+			return maps.Keys(object.(*omerv1.NamespaceLabel).Spec.Labels)
+		},
+	},
+	{
+		object: &omerv1.NamespaceLabelRule{},
+		field:  ruleAnnotationIndex,
+		extract: func(object client.Object) []string {
+			return maps.Keys(object.(*omerv1.NamespaceLabelRule).Spec.FromAnnotations)
+		},
+	},
+	{
+		object: &omerv1.NamespaceLabelRule{},
+		field:  ruleLabelKeyIndex,
+		extract: func(object client.Object) []string {
+			return derivedLabelKeys(*object.(*omerv1.NamespaceLabelRule))
+		},
+	},
+}
+
+// namespacePredicate lets through the namespace updates the reconciler has to act on: a change to a label
+// that is managed, wanted by one of the nslabels of the namespace, inheritable from an nslabel of another
+// namespace or derived by a rule, a change to the managed labels annotation, to the paused annotation or to
+// an annotation a rule derives labels from, or the start of the namespace deletion.
+// the nslabels and rules of a key are found in the cache indexes of predicateIndexes. the inheritable labels
+// are matched in every namespace, not only in the ancestors, walking the hierarchy is left to the reconciler.
+// the patches of the reconciler change the managed labels, so they are followed by one more reconcile that
+// finds nothing to do
+type namespacePredicate struct {
+	predicate.Funcs
+	client.Reader
+}
+
+func (p namespacePredicate) Delete(e event.DeleteEvent) bool {
+	//the nslabels of a deleted namespace are deleted with it
+	return false
+}
+
+func (p namespacePredicate) Update(e event.UpdateEvent) bool {
+	oldNamespace, isOldNamespace := e.ObjectOld.(*v1.Namespace)
+	newNamespace, isNewNamespace := e.ObjectNew.(*v1.Namespace)
+	if !isOldNamespace || !isNewNamespace {
+		return true
+	}
+	if oldNamespace.DeletionTimestamp.IsZero() && !newNamespace.DeletionTimestamp.IsZero() {
+		return true
+	}
+	if oldNamespace.Annotations[managedLabelsAnnotation] != newNamespace.Annotations[managedLabelsAnnotation] {
+		return true
+	}
+	if parentOf(oldNamespace) != parentOf(newNamespace) {
+		return true
+	}
+	if isNamespacePaused(oldNamespace) != isNamespacePaused(newNamespace) {
+		return true
+	}
+
+	//the rules derive labels from the name, which never changes, and from the annotations
+	for _, annotation := range changedKeys(oldNamespace.Annotations, newNamespace.Annotations) {
+		if p.isIndexed(&omerv1.NamespaceLabelRuleList{}, ruleAnnotationIndex, annotation) {
+			return true
+		}
+	}
+
+	managedLabels := getManagedLabels(newNamespace)
+	for _, key := range changedKeys(oldNamespace.Labels, newNamespace.Labels) {
+		if isLabelKeyExistInLabels(managedLabels, key) {
+			return true
+		}
+		if p.isIndexed(&omerv1.NamespaceLabelRuleList{}, ruleLabelKeyIndex, key) {
+			return true
+		}
+		//a label an nslabel skipped because it existed may have been removed, in the namespace or in a descendant
+		var namespaceLabelList omerv1.NamespaceLabelList
+		if err := p.List(context.Background(), &namespaceLabelList, client.MatchingFields{namespaceLabelKeyIndex: key}); err != nil {
+			return true
+		}
+		for _, namespaceLabel := range namespaceLabelList.Items {
+			if namespaceLabel.Namespace == newNamespace.Name || slices.Contains(namespaceLabel.Spec.Inheritable, key) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// the function return true if an object of the list kind is indexed under the value, or the lookup failed
+func (p namespacePredicate) isIndexed(list client.ObjectList, field, value string) bool {
+	if err := p.List(context.Background(), list, client.MatchingFields{field: value}); err != nil {
+		return true
+	}
+	return meta.LenList(list) > 0
+}
+
+// the function return the keys added, removed or changed between the two label or annotation sets
+func changedKeys(oldValues, newValues map[string]string) []string {
+	var keys []string
+	for key, value := range newValues {
+		if oldValue, isExist := oldValues[key]; !isExist || oldValue != value {
+			keys = append(keys, key)
+		}
+	}
+	for key := range oldValues {
+		if !isLabelKeyExistInLabels(newValues, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// parentChangedPredicate lets through the namespaces that moved in the hierarchy or were deleted,
+// their descendants inherit other labels. a new namespace has no descendants yet
+func parentChangedPredicate() predicate.Predicate {
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return false
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldNamespace, isOldNamespace := e.ObjectOld.(*v1.Namespace)
+			newNamespace, isNewNamespace := e.ObjectNew.(*v1.Namespace)
+			return !isOldNamespace || !isNewNamespace || parentOf(oldNamespace) != parentOf(newNamespace)
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return false
+		},
+	}
+}
+
+// propagatedObjectPredicate lets through the events of the objects the labels may be propagated to:
+// a new object, or a change to its labels or to its propagated labels annotation. the events of the
+// namespaces where no nslabel propagates labels are filtered out
+type propagatedObjectPredicate struct {
+	predicate.Funcs
+	client.Reader
+}
+
+func (p propagatedObjectPredicate) Create(e event.CreateEvent) bool {
+	return p.isPropagatedNamespace(e.Object.GetNamespace())
+}
+
+func (p propagatedObjectPredicate) Delete(e event.DeleteEvent) bool {
+	//the labels are deleted with the object
+	return false
+}
+
+func (p propagatedObjectPredicate) Update(e event.UpdateEvent) bool {
+	if len(changedKeys(e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels())) == 0 &&
+		e.ObjectOld.GetAnnotations()[propagatedLabelsAnnotation] == e.ObjectNew.GetAnnotations()[propagatedLabelsAnnotation] {
+		return false
+	}
+	return p.isPropagatedNamespace(e.ObjectNew.GetNamespace())
+}
+
+func (p propagatedObjectPredicate) Generic(e event.GenericEvent) bool {
+	return false
+}
+
+// the function return true if an nslabel of the namespace propagates labels, or did at its last sync
+func (p propagatedObjectPredicate) isPropagatedNamespace(namespace string) bool {
+	var namespaceLabelList omerv1.NamespaceLabelList
+	if err := p.List(context.Background(), &namespaceLabelList, client.InNamespace(namespace)); err != nil {
+		return true
+	}
+	return len(propagatedKinds(namespaceLabelList.Items)) > 0
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	omerv1 "omer.io/namespacelabel/api/v1"
+)
+
+var _ = Describe("Watch predicates", func() {
+
+	Context("When a namespace is updated", func() {
+		var p namespacePredicate
+
+		BeforeEach(func() {
+			scheme := runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).Should(Succeed())
+			Expect(omerv1.AddToScheme(scheme)).Should(Succeed())
+			builder := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&omerv1.NamespaceLabel{
+				ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "ns"},
+				Spec:       omerv1.NamespaceLabelSpec{Labels: map[string]string{"team": "a", "skipped": "a"}},
+			}, &omerv1.NamespaceLabel{
+				ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "parent"},
+				Spec: omerv1.NamespaceLabelSpec{
+					Labels:      map[string]string{"inherited": "a", "local": "a"},
+					Inheritable: []string{"inherited"},
+				},
+			}, &omerv1.NamespaceLabelRule{
+				ObjectMeta: metav1.ObjectMeta{Name: "owner"},
+				Spec:       omerv1.NamespaceLabelRuleSpec{FromAnnotations: map[string]string{"omer.io/owner": "owner"}},
+			})
+			for _, index := range predicateIndexes {
+				builder = builder.WithIndex(index.object, index.field, index.extract)
+			}
+			p = namespacePredicate{Reader: builder.Build()}
+		})
+
+		namespace := func(labels map[string]string, annotations map[string]string) *v1.Namespace {
+			return &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns", Labels: labels, Annotations: annotations}}
+		}
+		managed := map[string]string{managedLabelsAnnotation: `{"team":"a"}`}
+		now := metav1.Now()
+		terminating := namespace(map[string]string{"team": "a"}, managed)
+		terminating.DeletionTimestamp = &now
+
+		DescribeTable("Should reconcile only the changes a NamespaceLabel or a rule depends on",
+			func(old *v1.Namespace, new *v1.Namespace, isFiltered bool) {
+				Expect(p.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: new})).Should(Equal(!isFiltered))
+			},
+			Entry("unrelated label is added",
+				namespace(map[string]string{"team": "a"}, managed),
+				namespace(map[string]string{"team": "a", "other": "x"}, managed), true),
+			Entry("unrelated annotation is added",
+				namespace(map[string]string{"team": "a"}, managed),
+				namespace(map[string]string{"team": "a"}, map[string]string{managedLabelsAnnotation: `{"team":"a"}`, "other": "x"}), true),
+			Entry("managed label is changed",
+				namespace(map[string]string{"team": "a"}, managed),
+				namespace(map[string]string{"team": "b"}, managed), false),
+			Entry("managed label is removed",
+				namespace(map[string]string{"team": "a"}, managed),
+				namespace(nil, managed), false),
+			Entry("label wanted by a NamespaceLabel is removed",
+				namespace(map[string]string{"team": "a", "skipped": "user"}, managed),
+				namespace(map[string]string{"team": "a"}, managed), false),
+			Entry("managed labels annotation is changed",
+				namespace(map[string]string{"team": "a"}, managed),
+				namespace(map[string]string{"team": "a"}, nil), false),
+			Entry("namespace starts terminating",
+				namespace(map[string]string{"team": "a"}, managed),
+				terminating, false),
+			Entry("parent annotation is changed",
+				namespace(map[string]string{"team": "a"}, managed),
+				namespace(map[string]string{"team": "a"}, map[string]string{managedLabelsAnnotation: `{"team":"a"}`, parentAnnotation: "root"}), false),
+			Entry("parent is changed in the HNC hierarchy labels",
+				namespace(map[string]string{"team": "a", "root.tree.hnc.x-k8s.io/depth": "1"}, managed),
+				namespace(map[string]string{"team": "a", "other.tree.hnc.x-k8s.io/depth": "1"}, managed), false),
+			Entry("annotation of a rule is changed",
+				namespace(map[string]string{"team": "a"}, managed),
+				namespace(map[string]string{"team": "a"}, map[string]string{managedLabelsAnnotation: `{"team":"a"}`, "omer.io/owner": "b"}), false),
+			Entry("unrelated annotation is changed",
+				namespace(map[string]string{"team": "a"}, map[string]string{managedLabelsAnnotation: `{"team":"a"}`, "other": "a"}),
+				namespace(map[string]string{"team": "a"}, map[string]string{managedLabelsAnnotation: `{"team":"a"}`, "other": "b"}), true),
+			Entry("namespace is paused",
+				namespace(map[string]string{"team": "a"}, managed),
+				namespace(map[string]string{"team": "a"}, map[string]string{managedLabelsAnnotation: `{"team":"a"}`, pausedAnnotation: "true"}), false),
+			Entry("label inheritable from a NamespaceLabel of another namespace is removed",
+				namespace(map[string]string{"team": "a", "inherited": "user"}, managed),
+				namespace(map[string]string{"team": "a"}, managed), false),
+			Entry("label a NamespaceLabel of another namespace does not pass down is removed",
+				namespace(map[string]string{"team": "a", "local": "user"}, managed),
+				namespace(map[string]string{"team": "a"}, managed), true),
+			Entry("label derived by a rule is removed",
+				namespace(map[string]string{"team": "a", "owner": "b"}, managed),
+				namespace(map[string]string{"team": "a"}, managed), false),
+		)
+	})
+
+	Context("When a NamespaceLabel is updated", func() {
+		namespaceLabel := func(generation int64, isDeleting bool) *omerv1.NamespaceLabel {
+			namespaceLabel := &omerv1.NamespaceLabel{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "ns", Generation: generation}}
+			if isDeleting {
+				now := metav1.Now()
+				namespaceLabel.DeletionTimestamp = &now
+			}
+			return namespaceLabel
+		}
+
+		DescribeTable("Should reconcile only the spec changes and the deletion",
+			func(old *omerv1.NamespaceLabel, new *omerv1.NamespaceLabel, isFiltered bool) {
+				Expect(namespaceLabelPredicate().Update(event.UpdateEvent{ObjectOld: old, ObjectNew: new})).Should(Equal(!isFiltered))
+			},
+			Entry("status or finalizer update", namespaceLabel(1, false), namespaceLabel(1, false), true),
+			Entry("spec update", namespaceLabel(1, false), namespaceLabel(2, false), false),
+			Entry("deletion", namespaceLabel(1, false), namespaceLabel(1, true), false),
+			Entry("update while deleting", namespaceLabel(1, true), namespaceLabel(1, true), true),
+		)
+	})
+
+	Context("When a predicate filters an event", func() {
+		It("Should count the filtered event", func() {
+			p := countingPredicate{Predicate: namespacePredicate{}, kind: "test"}
+			filtered := testutil.ToFloat64(watchEvents.WithLabelValues("test", "filtered"))
+			p.Delete(event.DeleteEvent{Object: &v1.Namespace{}})
+			Expect(testutil.ToFloat64(watchEvents.WithLabelValues("test", "filtered"))).Should(Equal(filtered + 1))
+		})
+	})
+})
@@ -0,0 +1,201 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+
+	omerv1 "omer.io/namespacelabel/api/v1"
+	"omer.io/namespacelabel/pkg/labelsync"
+)
+
+//+kubebuilder:rbac:groups=apps,resources=deployments;statefulsets,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups=core,resources=services;serviceaccounts;podtemplates,verbs=get;list;watch;patch
+
+// the kinds the labels can be propagated to, the objects are read and patched as metadata only
+var propagationKinds = map[omerv1.PropagationKind]schema.GroupVersionKind{
+	omerv1.PropagationKindDeployment:     appsv1.SchemeGroupVersion.WithKind("Deployment"),
+	omerv1.PropagationKindStatefulSet:    appsv1.SchemeGroupVersion.WithKind("StatefulSet"),
+	omerv1.PropagationKindService:        v1.SchemeGroupVersion.WithKind("Service"),
+	omerv1.PropagationKindServiceAccount: v1.SchemeGroupVersion.WithKind("ServiceAccount"),
+	omerv1.PropagationKindPodTemplate:    v1.SchemeGroupVersion.WithKind("PodTemplate"),
+}
+
+// the function return the kinds the nslabels propagate to, or did at their last sync. the kinds that
+// were removed from a spec are listed too, so their objects get the labels removed
+func propagatedKinds(namespaceLabels []omerv1.NamespaceLabel) []omerv1.PropagationKind {
+	kinds := make(map[omerv1.PropagationKind]bool)
+	for _, namespaceLabel := range namespaceLabels {
+		if namespaceLabel.Spec.PropagateTo != nil {
+			for _, kind := range namespaceLabel.Spec.PropagateTo.Kinds {
+				kinds[kind] = true
+			}
+		}
+		for _, propagation := range namespaceLabel.Status.Propagation {
+			kinds[propagation.Kind] = true
+		}
+	}
+	sorted := maps.Keys(kinds)
+	slices.Sort(sorted)
+	return sorted
+}
+
+// the function return true if the nslabel propagates its labels to the object of the kind
+func isPropagatedTo(namespaceLabel omerv1.NamespaceLabel, kind omerv1.PropagationKind, object metav1.Object) bool {
+	propagateTo := namespaceLabel.Spec.PropagateTo
+	if propagateTo == nil || !slices.Contains(propagateTo.Kinds, kind) {
+		return false
+	}
+	if propagateTo.Selector == nil {
+		return true
+	}
+	selector, err := metav1.LabelSelectorAsSelector(propagateTo.Selector)
+	if err != nil {
+		return false
+	}
+	return selector.Matches(labels.Set(object.GetLabels()))
+}
+
+// the function stamp the synced labels of the nslabels onto the objects they select, and return the
+// propagation status of every nslabel. every object is synced like the namespace: the nslabels are the
+// sources of a plan, the labels an nslabel does not propagate to the object anymore are removed from it.
+// the objects that failed are reported in the error, the others are synced anyway. the labels of a
+// suspended nslabel, or of all of them when the namespace is paused, are left as they are on the objects
+func (r *NamespaceLabelReconciler) propagateToObjects(ctx context.Context, namespace string, namespaceLabels []omerv1.NamespaceLabel,
+	plan labelsync.Plan, isPaused bool) (map[string][]omerv1.PropagationStatus, error) {
+	propagation := make(map[string][]omerv1.PropagationStatus)
+	//the sources get the labels the namespace plan synced, which are already approved
+	policy := r.syncPolicy()
+	policy.ApprovalRequiredLabels = nil
+	policy.ApprovalRequiredLabelPrefixes = nil
+	//every kind is visited, the nslabel that propagated to it may be gone along with its status
+	kinds := maps.Keys(propagationKinds)
+	slices.Sort(kinds)
+	var errs []error
+	for _, kind := range kinds {
+		gvk := propagationKinds[kind]
+		var objectList metav1.PartialObjectMetadataList
+		objectList.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		if err := r.List(ctx, &objectList, client.InNamespace(namespace)); err != nil {
+			errs = append(errs, fmt.Errorf("unable to list %s objects: %w", kind, err))
+			continue
+		}
+
+		counts := make(map[string]*omerv1.PropagationStatus)
+		count := func(name string) *omerv1.PropagationStatus {
+			if counts[name] == nil {
+				counts[name] = &omerv1.PropagationStatus{Kind: kind}
+			}
+			return counts[name]
+		}
+		for i := range objectList.Items {
+			object := &objectList.Items[i]
+			object.SetGroupVersionKind(gvk)
+			if !object.DeletionTimestamp.IsZero() {
+				continue
+			}
+
+			var selectedBy []string
+			sources := make([]labelsync.Source, 0, len(namespaceLabels))
+			for _, namespaceLabel := range namespaceLabels {
+				source := labelsync.Source{
+					Name:              namespaceLabel.Name,
+					CreationTimestamp: namespaceLabel.CreationTimestamp.Time,
+					Deleting:          isNsLabelInDeletionState(namespaceLabel),
+					Suspended:         isPaused || namespaceLabel.Spec.Suspend,
+				}
+				if !source.Deleting && isPropagatedTo(namespaceLabel, kind, object) {
+					source.Labels = plan.Results[namespaceLabel.Name].Synced
+					selectedBy = append(selectedBy, namespaceLabel.Name)
+				}
+				sources = append(sources, source)
+			}
+			previous := getLabelOwners(object, propagatedLabelsAnnotation)
+			if len(selectedBy) == 0 && len(previous) == 0 {
+				continue
+			}
+			//an owner gone without its finalizer, e.g. force deleted, is deleting: its labels are removed from the
+			//object, the orphaned labels sweeper only looks at the namespaces
+			for _, owner := range orphanedOwners(previous, namespaceLabels) {
+				sources = append(sources, labelsync.Source{Name: owner, Deleting: true, Suspended: isPaused})
+			}
+			objectPlan := labelsync.NewPlan(sources, object.GetLabels(), previous, policy)
+			err := r.patchPropagatedLabels(ctx, object, previous, objectPlan)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("unable to label %s %s: %w", kind, object.Name, err))
+				//the labels left on the object keep the kind in the status of their owners, so they are retried
+				for _, owner := range previous {
+					count(owner)
+				}
+			}
+			for _, name := range selectedBy {
+				count(name).Selected++
+				if err == nil && len(objectPlan.Results[name].Skipped) == 0 {
+					count(name).Labeled++
+				}
+			}
+		}
+
+		for _, namespaceLabel := range namespaceLabels {
+			if isNsLabelInDeletionState(namespaceLabel) {
+				continue
+			}
+			isInSpec := namespaceLabel.Spec.PropagateTo != nil && slices.Contains(namespaceLabel.Spec.PropagateTo.Kinds, kind)
+			if isInSpec || counts[namespaceLabel.Name] != nil {
+				propagation[namespaceLabel.Name] = append(propagation[namespaceLabel.Name], *count(namespaceLabel.Name))
+			}
+		}
+	}
+	return propagation, utilerrors.NewAggregate(errs)
+}
+
+// the function return the owners of the propagated labels that are not nslabels of the namespace anymore
+func orphanedOwners(previous map[string]string, namespaceLabels []omerv1.NamespaceLabel) []string {
+	isOwner := make(map[string]bool)
+	for _, owner := range previous {
+		isOwner[owner] = true
+	}
+	for _, namespaceLabel := range namespaceLabels {
+		delete(isOwner, namespaceLabel.Name)
+	}
+	owners := maps.Keys(isOwner)
+	slices.Sort(owners)
+	return owners
+}
+
+// the function write the planned labels and their owners to the object with a metadata merge patch
+func (r *NamespaceLabelReconciler) patchPropagatedLabels(ctx context.Context, object *metav1.PartialObjectMetadata, previous map[string]string, plan labelsync.Plan) error {
+	if plan.IsNoop(previous) {
+		return nil
+	}
+	patch := client.MergeFromWithOptions(object.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	object.SetLabels(plan.Labels(object.GetLabels()))
+	setLabelOwners(object, propagatedLabelsAnnotation, plan.Managed)
+	return client.IgnoreNotFound(r.Patch(ctx, object, patch))
+}
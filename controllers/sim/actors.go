@@ -0,0 +1,167 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sim
+
+import (
+	"context"
+	"strconv"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	omerv1 "omer.io/namespacelabel/api/v1"
+)
+
+var (
+	// the small name and key spaces make the actors collide often
+	namespaceLabelNames = []string{"a", "b", "c", "d"}
+	desiredLabelKeys    = []string{"team", "env", "tier", "app"}
+	labelValues         = []string{"x", "y", "z"}
+	userLabelKeys       = []string{"user/owner", "user/cost-center"}
+)
+
+// actor changes the cluster the way something other than the reconciler would
+type actor interface {
+	act(ctx context.Context, h *Harness)
+}
+
+// the function draw the spec labels of a NamespaceLabel, sometimes with a protected key
+func (h *Harness) randomLabels() map[string]string {
+	labels := make(map[string]string)
+	for _, key := range desiredLabelKeys {
+		if h.rand.Intn(2) == 0 {
+			labels[key] = h.pick(labelValues)
+		}
+	}
+	if h.rand.Intn(5) == 0 {
+		labels[protectedLabelPrefix+"owner"] = h.pick(labelValues)
+	}
+	return labels
+}
+
+// namespaceLabelChurn creates, changes and deletes NamespaceLabels
+type namespaceLabelChurn struct{}
+
+func (a *namespaceLabelChurn) act(ctx context.Context, h *Harness) {
+	namespace := h.pick(h.namespaces)
+	name := h.pick(namespaceLabelNames)
+	var nsLabel omerv1.NamespaceLabel
+	err := h.client.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, &nsLabel)
+	switch {
+	case apierrors.IsNotFound(err):
+		nsLabel = omerv1.NamespaceLabel{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         namespace,
+				CreationTimestamp: metav1.NewTime(h.clock),
+			},
+			Spec: omerv1.NamespaceLabelSpec{Labels: h.randomLabels()},
+		}
+		err = h.client.Create(ctx, &nsLabel)
+		h.tracef("create nslabel %s/%s %v: %v", namespace, name, nsLabel.Spec.Labels, err)
+	case err != nil:
+		return
+	case h.rand.Intn(2) == 0:
+		err = h.client.Delete(ctx, &nsLabel)
+		h.tracef("delete nslabel %s/%s: %v", namespace, name, err)
+	default:
+		nsLabel.Spec.Labels = h.randomLabels()
+		err = h.client.Update(ctx, &nsLabel)
+		h.tracef("update nslabel %s/%s %v: %v", namespace, name, nsLabel.Spec.Labels, err)
+	}
+	if err == nil {
+		h.enqueue(namespace)
+	}
+}
+
+// namespaceEditor is a user editing the namespace labels by hand. It owns the user labels,
+// and sometimes changes or removes a label the NamespaceLabels want
+type namespaceEditor struct{}
+
+func (a *namespaceEditor) act(ctx context.Context, h *Harness) {
+	var namespace v1.Namespace
+	if err := h.client.Get(ctx, types.NamespacedName{Name: h.pick(h.namespaces)}, &namespace); err != nil {
+		return
+	}
+	if namespace.Labels == nil {
+		namespace.Labels = make(map[string]string)
+	}
+	key, value := h.pick(userLabelKeys), h.pick(labelValues)
+	owned := true
+	if h.rand.Intn(3) == 0 {
+		key, owned = h.pick(desiredLabelKeys), false
+	}
+	if h.rand.Intn(3) == 0 {
+		delete(namespace.Labels, key)
+		value = ""
+	} else {
+		namespace.Labels[key] = value
+	}
+	err := h.client.Update(ctx, &namespace)
+	h.tracef("edit namespace %s %s=%q: %v", namespace.Name, key, value, err)
+	if err != nil {
+		return
+	}
+	if owned {
+		if value == "" {
+			delete(h.unmanaged[namespace.Name], key)
+		} else {
+			h.unmanaged[namespace.Name][key] = value
+		}
+	}
+	h.enqueue(namespace.Name)
+}
+
+// otherController reads a namespace and writes it back with a full update some steps later,
+// by then the copy is often stale
+type otherController struct {
+	stale map[string]*v1.Namespace
+	count int
+}
+
+const otherControllerLabel = "other.omer.io/revision"
+
+func (a *otherController) act(ctx context.Context, h *Harness) {
+	name := h.pick(h.namespaces)
+	namespace, isExist := a.stale[name]
+	if !isExist || h.rand.Intn(2) == 0 {
+		namespace = &v1.Namespace{}
+		if err := h.client.Get(ctx, types.NamespacedName{Name: name}, namespace); err == nil {
+			a.stale[name] = namespace
+		}
+		return
+	}
+	delete(a.stale, name)
+	a.count++
+	revision := strconv.Itoa(a.count)
+	if namespace.Labels == nil {
+		namespace.Labels = make(map[string]string)
+	}
+	namespace.Labels[otherControllerLabel] = revision
+	if namespace.Annotations == nil {
+		namespace.Annotations = make(map[string]string)
+	}
+	namespace.Annotations[otherControllerLabel] = revision
+	err := h.client.Update(ctx, namespace)
+	h.tracef("other controller update namespace %s: %v", name, err)
+	if err == nil {
+		h.unmanaged[name][otherControllerLabel] = revision
+		h.enqueue(name)
+	}
+}
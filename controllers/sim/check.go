@@ -0,0 +1,140 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	omerv1 "omer.io/namespacelabel/api/v1"
+)
+
+// the annotation the controllers package records the managed labels in
+const managedLabelsAnnotation = "namespacelabel.omer.io/managed-labels"
+
+// the finalizer the controllers package adds to the NamespaceLabels
+const nsLabelFinalizer = "namespacelabel.omer.io/finalizer"
+
+// the function check the settled state of a namespace:
+//   - no NamespaceLabel is left deleting, the finalizers were released
+//   - every NamespaceLabel reports all its labels, each one either synced or skipped, on a Synced condition
+//   - a synced label is in the namespace with its value and owned by its NamespaceLabel
+//   - every managed label is owned by a live NamespaceLabel that syncs it
+//   - protected labels are never written and the labels of the other actors are untouched
+func (h *Harness) check(ctx context.Context, name string) error {
+	var namespace v1.Namespace
+	if err := h.client.Get(ctx, types.NamespacedName{Name: name}, &namespace); err != nil {
+		return err
+	}
+	var namespaceLabelList omerv1.NamespaceLabelList
+	if err := h.client.List(ctx, &namespaceLabelList, client.InNamespace(name)); err != nil {
+		return err
+	}
+	managed := make(map[string]string)
+	if value, isExist := namespace.Annotations[managedLabelsAnnotation]; isExist {
+		if err := json.Unmarshal([]byte(value), &managed); err != nil {
+			return fmt.Errorf("namespace %s: unreadable managed labels annotation %q", name, value)
+		}
+	}
+
+	owners := make(map[string]omerv1.NamespaceLabel)
+	for _, nsLabel := range namespaceLabelList.Items {
+		if err := checkNamespaceLabel(nsLabel, namespace, managed); err != nil {
+			return fmt.Errorf("namespacelabel %s/%s: %w", name, nsLabel.Name, err)
+		}
+		owners[nsLabel.Name] = nsLabel
+	}
+
+	for key, owner := range managed {
+		nsLabel, isExist := owners[owner]
+		if !isExist {
+			return fmt.Errorf("namespace %s: label %s is managed by NamespaceLabel %s that does not exist", name, key, owner)
+		}
+		if _, isSynced := nsLabel.Status.SyncLabels[key]; !isSynced {
+			return fmt.Errorf("namespace %s: label %s is managed by NamespaceLabel %s that does not sync it", name, key, owner)
+		}
+	}
+	for key := range namespace.Labels {
+		if strings.HasPrefix(key, protectedLabelPrefix) {
+			return fmt.Errorf("namespace %s: protected label %s was written", name, key)
+		}
+	}
+	for key, value := range h.unmanaged[name] {
+		if namespace.Labels[key] != value {
+			return fmt.Errorf("namespace %s: label %s=%s of another actor is %q", name, key, value, namespace.Labels[key])
+		}
+	}
+	return nil
+}
+
+func checkNamespaceLabel(nsLabel omerv1.NamespaceLabel, namespace v1.Namespace, managed map[string]string) error {
+	if !nsLabel.DeletionTimestamp.IsZero() {
+		return fmt.Errorf("still deleting with finalizers %v", nsLabel.Finalizers)
+	}
+	if !controllerutil.ContainsFinalizer(&nsLabel, nsLabelFinalizer) {
+		return fmt.Errorf("finalizer is missing")
+	}
+
+	reported := make([]string, 0, len(nsLabel.Spec.Labels))
+	for key, value := range nsLabel.Status.SyncLabels {
+		if namespace.Labels[key] != value {
+			return fmt.Errorf("synced label %s=%s is %q in the namespace", key, value, namespace.Labels[key])
+		}
+		if managed[key] != nsLabel.Name {
+			return fmt.Errorf("synced label %s is managed by %q", key, managed[key])
+		}
+		reported = append(reported, key)
+	}
+	for key := range nsLabel.Status.UnSyncLabels {
+		reported = append(reported, key)
+	}
+	desired := make([]string, 0, len(nsLabel.Spec.Labels))
+	for key := range nsLabel.Spec.Labels {
+		desired = append(desired, key)
+	}
+	sort.Strings(reported)
+	sort.Strings(desired)
+	if strings.Join(reported, ",") != strings.Join(desired, ",") {
+		return fmt.Errorf("status reports labels %v, the spec has %v", reported, desired)
+	}
+
+	condition := meta.FindStatusCondition(nsLabel.Status.Conditions, omerv1.ConditionTypeSynced)
+	if condition == nil {
+		return fmt.Errorf("the Synced condition is missing")
+	}
+	if condition.ObservedGeneration != nsLabel.Generation || nsLabel.Status.ObservedGeneration != nsLabel.Generation {
+		return fmt.Errorf("generation %d was not observed", nsLabel.Generation)
+	}
+	synced := metav1.ConditionTrue
+	if len(nsLabel.Status.UnSyncLabels) > 0 {
+		synced = metav1.ConditionFalse
+	}
+	if condition.Status != synced {
+		return fmt.Errorf("the Synced condition is %s with labels %v not synced", condition.Status, nsLabel.Status.UnSyncLabels)
+	}
+	return nil
+}
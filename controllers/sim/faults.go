@@ -0,0 +1,114 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sim
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"reflect"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// FaultyClient wraps the client of the reconciler. It fails a share of the calls with
+// the errors a busy API server returns, and runs the Interleave hook before every call
+// so other actors can change the cluster in the middle of a reconcile.
+// Status writes are passed through, they still fail on conflicts with the other actors.
+type FaultyClient struct {
+	client.Client
+	// Rand decides which calls fail, the calls are deterministic for a seed
+	Rand *rand.Rand
+	// FaultRate is the share of calls that fail, 0 disables the fault injection
+	FaultRate float64
+	// Interleave runs before every call, when set
+	Interleave func()
+	// Writes counts the successful creates, updates, patches and deletes
+	Writes int
+}
+
+// the function return an injected error for the call, or nil to let it through
+func (c *FaultyClient) fault(verb string, obj interface{}, name string) error {
+	if c.Interleave != nil {
+		c.Interleave()
+	}
+	if c.FaultRate == 0 || c.Rand.Float64() >= c.FaultRate {
+		return nil
+	}
+	resource := schema.GroupResource{Resource: strings.ToLower(reflect.TypeOf(obj).Elem().Name())}
+	switch c.Rand.Intn(3) {
+	case 0:
+		return apierrors.NewConflict(resource, name, errors.New("injected conflict"))
+	case 1:
+		return apierrors.NewServerTimeout(resource, verb, 1)
+	default:
+		return apierrors.NewInternalError(errors.New("injected error"))
+	}
+}
+
+// the function count the successful writes, a reconcile without writes is settled
+func (c *FaultyClient) write(err error) error {
+	if err == nil {
+		c.Writes++
+	}
+	return err
+}
+
+func (c *FaultyClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	if err := c.fault("get", obj, key.Name); err != nil {
+		return err
+	}
+	return c.Client.Get(ctx, key, obj, opts...)
+}
+
+func (c *FaultyClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	if err := c.fault("list", list, ""); err != nil {
+		return err
+	}
+	return c.Client.List(ctx, list, opts...)
+}
+
+func (c *FaultyClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if err := c.fault("create", obj, obj.GetName()); err != nil {
+		return err
+	}
+	return c.write(c.Client.Create(ctx, obj, opts...))
+}
+
+func (c *FaultyClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if err := c.fault("update", obj, obj.GetName()); err != nil {
+		return err
+	}
+	return c.write(c.Client.Update(ctx, obj, opts...))
+}
+
+func (c *FaultyClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if err := c.fault("patch", obj, obj.GetName()); err != nil {
+		return err
+	}
+	return c.write(c.Client.Patch(ctx, obj, patch, opts...))
+}
+
+func (c *FaultyClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	if err := c.fault("delete", obj, obj.GetName()); err != nil {
+		return err
+	}
+	return c.write(c.Client.Delete(ctx, obj, opts...))
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package sim runs the NamespaceLabel reconciler against simulated hostile actors:
+// users editing namespace labels, other controllers doing full updates, NamespaceLabels
+// created and deleted rapidly, and API errors injected into the calls of the reconciler.
+// Every step is drawn from a seeded random source, so a failing run is replayed by its seed.
+// After the actors stop, the reconciler runs until it settles and the state is checked.
+package sim
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	configv1 "omer.io/namespacelabel/api/config/v1"
+	"omer.io/namespacelabel/controllers"
+)
+
+// the label prefix the simulated reconciler protects
+const protectedLabelPrefix = "protected.omer.io/"
+
+// the number of trace lines reported with a failure
+const traceTail = 30
+
+// Options configures a simulation
+type Options struct {
+	// Seed drives every random choice of the simulation
+	Seed int64
+	// Steps is the number of actions taken before the actors stop
+	Steps int
+	// Namespaces is the number of namespaces the actors work in
+	Namespaces int
+	// FaultRate is the share of the reconciler calls that fail
+	FaultRate float64
+	// InterleaveRate is the chance an actor acts before each call of the reconciler
+	InterleaveRate float64
+	// ConflictPolicy is passed to the reconciler
+	ConflictPolicy configv1.ConflictPolicy
+}
+
+// Harness holds the state of one simulation
+type Harness struct {
+	Options
+	client     client.Client
+	faulty     *FaultyClient
+	reconciler *controllers.NamespaceLabelReconciler
+	rand       *rand.Rand
+	actors     []actor
+	namespaces []string
+	// queue holds the namespaces with an event not reconciled yet, the way the watches would enqueue them
+	queue map[string]bool
+	// clock is the creation time given to the NamespaceLabels, one second per step
+	clock time.Time
+	step  int
+	// unmanaged are the labels written by the other actors, they must survive the simulation
+	unmanaged map[string]map[string]string
+	trace     []string
+}
+
+// New creates the namespaces of the simulation. The actors use c directly, the reconciler
+// uses it through a FaultyClient.
+func New(ctx context.Context, c client.Client, opts Options) (*Harness, error) {
+	random := rand.New(rand.NewSource(opts.Seed))
+	faulty := &FaultyClient{Client: c, Rand: random, FaultRate: opts.FaultRate}
+	h := &Harness{
+		Options: opts,
+		client:  c,
+		faulty:  faulty,
+		reconciler: &controllers.NamespaceLabelReconciler{
+			Client:                 faulty,
+			Scheme:                 c.Scheme(),
+			ProtectedLabelPrefixes: []string{protectedLabelPrefix},
+			ConflictPolicy:         opts.ConflictPolicy,
+		},
+		rand:      random,
+		actors:    []actor{&namespaceLabelChurn{}, &namespaceEditor{}, &otherController{stale: make(map[string]*v1.Namespace)}},
+		queue:     make(map[string]bool),
+		clock:     time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+		unmanaged: make(map[string]map[string]string),
+	}
+	for i := 0; i < opts.Namespaces; i++ {
+		namespace := v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("sim-%d-%d", opts.Seed, i)}}
+		if err := c.Create(ctx, &namespace); err != nil {
+			return nil, err
+		}
+		h.namespaces = append(h.namespaces, namespace.Name)
+		h.unmanaged[namespace.Name] = make(map[string]string)
+	}
+	return h, nil
+}
+
+// Run takes the steps of the simulation, lets the reconciler settle and checks the result
+func (h *Harness) Run(ctx context.Context) error {
+	ctx = ctrllog.IntoContext(ctx, logr.Discard())
+	h.faulty.Interleave = func() {
+		if h.rand.Float64() < h.InterleaveRate {
+			h.act(ctx)
+		}
+	}
+	for h.step = 0; h.step < h.Steps; h.step++ {
+		h.clock = h.clock.Add(time.Second)
+		if len(h.queue) > 0 && h.rand.Intn(2) == 0 {
+			h.reconcile(ctx, h.pick(h.queued()))
+			continue
+		}
+		h.act(ctx)
+	}
+
+	//the actors stop and the api server recovers
+	h.faulty.Interleave = nil
+	h.faulty.FaultRate = 0
+	if err := h.settle(ctx); err != nil {
+		return h.failure(err)
+	}
+	for _, namespace := range h.namespaces {
+		if err := h.check(ctx, namespace); err != nil {
+			return h.failure(err)
+		}
+	}
+	return nil
+}
+
+func (h *Harness) failure(err error) error {
+	tail := h.trace
+	if len(tail) > traceTail {
+		tail = tail[len(tail)-traceTail:]
+	}
+	return fmt.Errorf("seed %d: %w\nlast steps:\n%s", h.Seed, err, strings.Join(tail, "\n"))
+}
+
+func (h *Harness) tracef(format string, args ...interface{}) {
+	h.trace = append(h.trace, fmt.Sprintf("%4d ", h.step)+fmt.Sprintf(format, args...))
+}
+
+func (h *Harness) act(ctx context.Context) {
+	h.actors[h.rand.Intn(len(h.actors))].act(ctx, h)
+}
+
+func (h *Harness) pick(values []string) string {
+	return values[h.rand.Intn(len(values))]
+}
+
+func (h *Harness) enqueue(namespace string) {
+	h.queue[namespace] = true
+}
+
+// the queued namespaces, sorted so the simulation is deterministic
+func (h *Harness) queued() []string {
+	namespaces := make([]string, 0, len(h.queue))
+	for namespace := range h.queue {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// the function reconcile the namespace and requeue it like the controller would, on an error
+// or when the reconciler wrote something that its watches would see
+func (h *Harness) reconcile(ctx context.Context, namespace string) error {
+	delete(h.queue, namespace)
+	writes := h.faulty.Writes
+	_, err := h.reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: namespace}})
+	h.tracef("reconcile %s: writes %d, err %v", namespace, h.faulty.Writes-writes, err)
+	if err != nil || h.faulty.Writes != writes {
+		h.enqueue(namespace)
+	}
+	return err
+}
+
+// the function reconcile until no namespace is queued, the reconciler must converge
+func (h *Harness) settle(ctx context.Context) error {
+	for _, namespace := range h.namespaces {
+		h.enqueue(namespace)
+	}
+	for i := 0; len(h.queue) > 0; i++ {
+		if i > 10*len(h.namespaces) {
+			return fmt.Errorf("the reconciler did not settle, namespaces %v are still queued", h.queued())
+		}
+		if err := h.reconcile(ctx, h.queued()[0]); err != nil {
+			return fmt.Errorf("reconcile failed without injected faults: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sim
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+
+	configv1 "omer.io/namespacelabel/api/config/v1"
+	omerv1 "omer.io/namespacelabel/api/v1"
+)
+
+var (
+	simSeed  = flag.Int64("sim.seed", 0, "run only the simulation with this seed, to replay a failure")
+	simSeeds = flag.Int("sim.seeds", 20, "number of seeds simulated for every conflict policy")
+	simSteps = flag.Int("sim.steps", 400, "number of steps of every simulation")
+)
+
+func newScheme(t *testing.T) *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := omerv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return scheme
+}
+
+func seeds() []int64 {
+	if *simSeed != 0 {
+		return []int64{*simSeed}
+	}
+	seeds := make([]int64, 0, *simSeeds)
+	for seed := int64(1); seed <= int64(*simSeeds); seed++ {
+		seeds = append(seeds, seed)
+	}
+	return seeds
+}
+
+func simulate(t *testing.T, c client.Client, opts Options) {
+	ctx := context.Background()
+	h, err := New(ctx, c, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSimulation(t *testing.T) {
+	scheme := newScheme(t)
+	for _, policy := range []configv1.ConflictPolicy{configv1.ConflictPolicySkip, configv1.ConflictPolicyOverwrite} {
+		for _, seed := range seeds() {
+			opts := Options{
+				Seed:           seed,
+				Steps:          *simSteps,
+				Namespaces:     3,
+				FaultRate:      0.2,
+				InterleaveRate: 0.2,
+				ConflictPolicy: policy,
+			}
+			t.Run(fmt.Sprintf("%s/seed=%d", policy, seed), func(t *testing.T) {
+				simulate(t, fake.NewClientBuilder().WithScheme(scheme).Build(), opts)
+			})
+		}
+	}
+}
+
+// TestSimulationEnvtest runs a few simulations against a real api server, it needs the envtest assets
+func TestSimulationEnvtest(t *testing.T) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set")
+	}
+	testEnv := &envtest.Environment{
+		CRDDirectoryPaths:     []string{filepath.Join("..", "..", "config", "crd", "bases")},
+		ErrorIfCRDPathMissing: true,
+	}
+	cfg, err := testEnv.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := testEnv.Stop(); err != nil {
+			t.Error(err)
+		}
+	}()
+	c, err := client.New(cfg, client.Options{Scheme: newScheme(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	envtestSeeds := seeds()
+	if len(envtestSeeds) > 3 {
+		envtestSeeds = envtestSeeds[:3]
+	}
+	for _, seed := range envtestSeeds {
+		opts := Options{
+			Seed:           seed,
+			Steps:          *simSteps / 4,
+			Namespaces:     2,
+			FaultRate:      0.2,
+			InterleaveRate: 0.2,
+			ConflictPolicy: configv1.ConflictPolicySkip,
+		}
+		t.Run(fmt.Sprintf("seed=%d", seed), func(t *testing.T) {
+			simulate(t, c, opts)
+		})
+	}
+}
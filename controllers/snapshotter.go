@@ -0,0 +1,144 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	omerv1 "omer.io/namespacelabel/api/v1"
+	"omer.io/namespacelabel/pkg/sharding"
+	"omer.io/namespacelabel/pkg/snapshot"
+)
+
+//+kubebuilder:rbac:groups=omer.omer.io,resources=namespacelabelsnapshots,verbs=get;list;watch;create;delete
+
+// LabelSnapshotter periodically records the label state of every namespace in a NamespaceLabelSnapshot:
+// the specs of its NamespaceLabels and the labels managed in it. A snapshot is only taken when the state
+// changed since the last one, and only the Retain most recent snapshots of a namespace are kept.
+type LabelSnapshotter struct {
+	client.Client
+	// Interval is the time between two rounds of snapshots
+	Interval time.Duration
+	// Retain is the number of snapshots kept per namespace, the latest one is always kept
+	Retain int
+	// Shard restricts the snapshots to the namespaces owned by this replica when sharding is enabled
+	Shard *sharding.Coordinator
+	// Clock names the snapshots, the real clock when nil
+	Clock clock.PassiveClock
+}
+
+// NeedLeaderElection makes the snapshots taken only on the leader, like the controller itself
+func (s *LabelSnapshotter) NeedLeaderElection() bool {
+	return true
+}
+
+func (s *LabelSnapshotter) Start(ctx context.Context) error {
+	logger := ctrllog.FromContext(ctx).WithName("snapshotter")
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := s.Snapshot(ctx); err != nil {
+			logger.Error(err, "label snapshots failed")
+		}
+	}, s.Interval)
+	return nil
+}
+
+// Snapshot runs a single round over all namespaces
+func (s *LabelSnapshotter) Snapshot(ctx context.Context) error {
+	logger := ctrllog.FromContext(ctx).WithName("snapshotter")
+
+	var namespaceLabelList omerv1.NamespaceLabelList
+	if err := s.List(ctx, &namespaceLabelList); err != nil {
+		return err
+	}
+	namespaceLabels := make(map[string][]omerv1.NamespaceLabel)
+	for _, namespaceLabel := range namespaceLabelList.Items {
+		namespaceLabels[namespaceLabel.Namespace] = append(namespaceLabels[namespaceLabel.Namespace], namespaceLabel)
+	}
+	var snapshotList omerv1.NamespaceLabelSnapshotList
+	if err := s.List(ctx, &snapshotList); err != nil {
+		return err
+	}
+	snapshots := make(map[string][]omerv1.NamespaceLabelSnapshot)
+	for _, namespaceSnapshot := range snapshotList.Items {
+		snapshots[namespaceSnapshot.Namespace] = append(snapshots[namespaceSnapshot.Namespace], namespaceSnapshot)
+	}
+
+	var namespaceList v1.NamespaceList
+	if err := s.List(ctx, &namespaceList); err != nil {
+		return err
+	}
+	for i := range namespaceList.Items {
+		namespace := &namespaceList.Items[i]
+		if s.Shard != nil && !s.Shard.Owns(namespace.Name) {
+			continue
+		}
+		//nothing can be created in a terminating namespace
+		if isNamespaceInDeletionState(*namespace) {
+			continue
+		}
+		if err := s.snapshotNamespace(ctx, namespace, namespaceLabels[namespace.Name], snapshots[namespace.Name]); err != nil {
+			logger.Error(err, "unable to snapshot the namespace labels", "namespace", namespace.Name)
+		}
+	}
+	return nil
+}
+
+// the function take a snapshot of the namespace when its state changed since the latest one, then
+// delete the snapshots beyond the retained ones
+func (s *LabelSnapshotter) snapshotNamespace(ctx context.Context, namespace *v1.Namespace, namespaceLabels []omerv1.NamespaceLabel,
+	snapshots []omerv1.NamespaceLabelSnapshot) error {
+	spec := snapshot.New(namespace, getManagedLabels(namespace), namespaceLabels)
+	snapshot.Sort(snapshots)
+	isChanged := len(snapshots) == 0 || !snapshot.Equal(spec, snapshots[len(snapshots)-1].Spec)
+	if isChanged && !(len(snapshots) == 0 && snapshot.IsEmpty(spec)) {
+		namespaceSnapshot := omerv1.NamespaceLabelSnapshot{
+			ObjectMeta: metav1.ObjectMeta{Name: snapshot.Name(s.clock().Now()), Namespace: namespace.Name},
+			Spec:       spec,
+		}
+		if err := s.Create(ctx, &namespaceSnapshot); err != nil && !apierrors.IsAlreadyExists(err) {
+			return err
+		}
+		snapshots = append(snapshots, namespaceSnapshot)
+	}
+
+	retain := s.Retain
+	if retain < 1 {
+		retain = 1
+	}
+	for _, expired := range snapshot.Expired(snapshots, retain) {
+		if err := s.Delete(ctx, &expired); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *LabelSnapshotter) clock() clock.PassiveClock {
+	if s.Clock == nil {
+		return clock.RealClock{}
+	}
+	return s.Clock
+}
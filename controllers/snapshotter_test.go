@@ -0,0 +1,97 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	omerv1 "omer.io/namespacelabel/api/v1"
+	"omer.io/namespacelabel/pkg/snapshot"
+)
+
+var _ = Describe("Label snapshotter", func() {
+
+	Context("When the labels of a namespace change between snapshots", func() {
+		It("Should record every changed state and retain only the most recent snapshots", func() {
+			scheme := runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).Should(Succeed())
+			Expect(omerv1.AddToScheme(scheme)).Should(Succeed())
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+				&v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+					Name:        "ns",
+					Labels:      map[string]string{"team": "a"},
+					Annotations: map[string]string{managedLabelsAnnotation: `{"team":"a"}`},
+				}},
+				&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "unlabeled"}},
+				&omerv1.NamespaceLabel{
+					ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "ns"},
+					Spec:       omerv1.NamespaceLabelSpec{Labels: map[string]string{"team": "a"}},
+				},
+			).Build()
+			clock := clocktesting.NewFakePassiveClock(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+			s := &LabelSnapshotter{Client: c, Retain: 2, Clock: clock}
+			ctx := context.Background()
+			snapshots := func(namespace string) []string {
+				var snapshotList omerv1.NamespaceLabelSnapshotList
+				Expect(c.List(ctx, &snapshotList, client.InNamespace(namespace))).Should(Succeed())
+				var names []string
+				for _, namespaceSnapshot := range snapshotList.Items {
+					names = append(names, namespaceSnapshot.Name)
+				}
+				return names
+			}
+			snapshotAfter := func(d time.Duration) {
+				clock.SetTime(clock.Now().Add(d))
+				Expect(s.Snapshot(ctx)).Should(Succeed())
+			}
+			setTeam := func(team string) {
+				var namespaceLabel omerv1.NamespaceLabel
+				Expect(c.Get(ctx, types.NamespacedName{Namespace: "ns", Name: "a"}, &namespaceLabel)).Should(Succeed())
+				namespaceLabel.Spec.Labels["team"] = team
+				Expect(c.Update(ctx, &namespaceLabel)).Should(Succeed())
+			}
+
+			snapshotAfter(0)
+			first := snapshot.Name(clock.Now())
+			Expect(snapshots("ns")).Should(Equal([]string{first}))
+			Expect(snapshots("unlabeled")).Should(BeEmpty())
+
+			By("Snapshotting an unchanged state")
+			snapshotAfter(time.Hour)
+			Expect(snapshots("ns")).Should(HaveLen(1))
+
+			By("Snapshotting two changed states")
+			setTeam("b")
+			snapshotAfter(time.Hour)
+			setTeam("c")
+			snapshotAfter(time.Hour)
+			Expect(snapshots("ns")).Should(And(HaveLen(2), Not(ContainElement(first))))
+		})
+	})
+})
@@ -40,6 +40,7 @@ import (
 	"go.uber.org/zap"
 
 	omerv1 "omer.io/namespacelabel/api/v1"
+	"omer.io/namespacelabel/pkg/podsecurity"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -55,7 +56,10 @@ var cancel context.CancelFunc
 func TestAPIs(t *testing.T) {
 	RegisterFailHandler(Fail)
 
-	RunSpecs(t, "Controller Suite")
+	// every spec works in its own namespace, so they can run in any order
+	suiteConfig, reporterConfig := GinkgoConfiguration()
+	suiteConfig.RandomizeAllSpecs = true
+	RunSpecs(t, "Controller Suite", suiteConfig, reporterConfig)
 }
 
 var _ = BeforeSuite(func() {
@@ -96,8 +100,12 @@ var _ = BeforeSuite(func() {
 	logf.SetLogger(zapr.NewLogger(logger))
 
 	err = (&NamespaceLabelReconciler{
-		Client: k8sManager.GetClient(),
-		Scheme: k8sManager.GetScheme(),
+		Client:                 k8sManager.GetClient(),
+		Scheme:                 k8sManager.GetScheme(),
+		ProtectedLabelPrefixes: []string{protectedLabelPrefix},
+
+		ApprovalRequiredLabelPrefixes: []string{approvalRequiredLabelPrefix},
+		PodSecurity:                   podsecurity.ServerDryRun{Config: cfg},
 	}).SetupWithManager(k8sManager)
 	Expect(err).ToNot(HaveOccurred())
 
@@ -0,0 +1,93 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	omerv1 "omer.io/namespacelabel/api/v1"
+	"omer.io/namespacelabel/pkg/labelsync"
+)
+
+// the namespace annotation that suspends all the nslabels of the namespace, when set to "true"
+const pausedAnnotation = "namespacelabel.omer.io/paused"
+
+func isNamespacePaused(namespace *v1.Namespace) bool {
+	return namespace.Annotations[pausedAnnotation] == "true"
+}
+
+// the function return the reason the writes of the nslabel are suspended, empty when they are not.
+// the pause of the manager wins over the one of the namespace, which wins over the nslabel spec
+func (r *NamespaceLabelReconciler) suspendReason(namespace *v1.Namespace, namespaceLabel omerv1.NamespaceLabel) string {
+	switch {
+	case r.Paused:
+		return omerv1.ReasonManagerPaused
+	case isNamespacePaused(namespace):
+		return omerv1.ReasonNamespacePaused
+	case namespaceLabel.Spec.Suspend:
+		return omerv1.ReasonSuspended
+	default:
+		return ""
+	}
+}
+
+// the function return the Suspended condition of the nslabel, its message is the drift between the
+// spec and the namespace: the labels the plan skipped because the writes are suspended
+func suspendedCondition(namespaceLabel omerv1.NamespaceLabel, reason string, result labelsync.Result) metav1.Condition {
+	if reason == "" {
+		return metav1.Condition{
+			Type:               omerv1.ConditionTypeSuspended,
+			Status:             metav1.ConditionFalse,
+			Reason:             omerv1.ReasonNotSuspended,
+			Message:            "the labels are synced to the namespace",
+			ObservedGeneration: namespaceLabel.Generation,
+		}
+	}
+
+	var suspendedBy string
+	switch reason {
+	case omerv1.ReasonManagerPaused:
+		suspendedBy = "the controller is paused"
+	case omerv1.ReasonNamespacePaused:
+		suspendedBy = "the namespace is paused by the " + pausedAnnotation + " annotation"
+	default:
+		suspendedBy = "the NamespaceLabel is suspended"
+	}
+	var drifted []string
+	for key, skip := range result.Skipped {
+		if skip.Reason == labelsync.ReasonSuspended {
+			drifted = append(drifted, key)
+		}
+	}
+	sort.Strings(drifted)
+	message := suspendedBy + ", no label of the spec differs from the namespace"
+	if len(drifted) > 0 {
+		message = fmt.Sprintf("%s, labels differing from the namespace: %s", suspendedBy, strings.Join(drifted, ", "))
+	}
+	return metav1.Condition{
+		Type:               omerv1.ConditionTypeSuspended,
+		Status:             metav1.ConditionTrue,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: namespaceLabel.Generation,
+	}
+}
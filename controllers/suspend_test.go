@@ -0,0 +1,86 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	omerv1 "omer.io/namespacelabel/api/v1"
+)
+
+var _ = Describe("Suspended reconcile", func() {
+
+	DescribeTable("Should report the drift without writing the namespace while suspended",
+		func(isPaused bool, isSuspended bool, annotations map[string]string, reason string) {
+			scheme := runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).Should(Succeed())
+			Expect(omerv1.AddToScheme(scheme)).Should(Succeed())
+			namespaceAnnotations := map[string]string{managedLabelsAnnotation: `{"team":"a","old":"a"}`}
+			for key, value := range annotations {
+				namespaceAnnotations[key] = value
+			}
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+				&v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+					Name:        "ns",
+					Labels:      map[string]string{"team": "drift", "old": "v"},
+					Annotations: namespaceAnnotations,
+				}},
+				&omerv1.NamespaceLabel{
+					ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "ns"},
+					Spec:       omerv1.NamespaceLabelSpec{Labels: map[string]string{"team": "a"}, Suspend: isSuspended},
+				},
+			).Build()
+			r := &NamespaceLabelReconciler{Client: c, Scheme: scheme, Paused: isPaused}
+			ctx := context.Background()
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: "ns"}})
+			Expect(err).ShouldNot(HaveOccurred())
+
+			var namespace v1.Namespace
+			Expect(c.Get(ctx, types.NamespacedName{Name: "ns"}, &namespace)).Should(Succeed())
+			var namespaceLabel omerv1.NamespaceLabel
+			Expect(c.Get(ctx, types.NamespacedName{Namespace: "ns", Name: "a"}, &namespaceLabel)).Should(Succeed())
+			condition := meta.FindStatusCondition(namespaceLabel.Status.Conditions, omerv1.ConditionTypeSuspended)
+			if reason == "" {
+				Expect(namespace.Labels).Should(HaveKeyWithValue("team", "a"))
+				Expect(namespace.Labels).ShouldNot(HaveKey("old"))
+				Expect(condition).Should(BeNil())
+				return
+			}
+			Expect(namespace.Labels).Should(HaveKeyWithValue("team", "drift"))
+			Expect(namespace.Labels).Should(HaveKeyWithValue("old", "v"))
+			Expect(namespaceLabel.Status.UnSyncLabels).Should(HaveKeyWithValue("team", "a"))
+			Expect(condition).ShouldNot(BeNil())
+			Expect(condition.Status).Should(Equal(metav1.ConditionTrue))
+			Expect(condition.Reason).Should(Equal(reason))
+		},
+		Entry("suspended NamespaceLabel", false, true, nil, omerv1.ReasonSuspended),
+		Entry("paused namespace", false, false, map[string]string{pausedAnnotation: "true"}, omerv1.ReasonNamespacePaused),
+		Entry("paused manager", true, false, nil, omerv1.ReasonManagerPaused),
+		Entry("not suspended", false, false, map[string]string{pausedAnnotation: "false"}, ""),
+	)
+})
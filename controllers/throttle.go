@@ -0,0 +1,93 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	omerv1 "omer.io/namespacelabel/api/v1"
+	"omer.io/namespacelabel/pkg/throttle"
+)
+
+// the function set the Throttled condition of the nslabels of a namespace whose write is delayed,
+// the rest of their status is the result of the last sync and is kept
+func (r *NamespaceLabelReconciler) handleThrottledNamespaceLabels(ctx context.Context, namespaceLabels []omerv1.NamespaceLabel, reason throttle.Reason) error {
+	logger := ctrllog.FromContext(ctx)
+
+	var errs []error
+	for _, namespaceLabel := range namespaceLabels {
+		namespaceLabel := namespaceLabel
+		isFirstAttempt := true
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			if !isFirstAttempt {
+				if err := r.Get(ctx, client.ObjectKeyFromObject(&namespaceLabel), &namespaceLabel); err != nil {
+					return err
+				}
+			}
+			isFirstAttempt = false
+
+			status := *namespaceLabel.Status.DeepCopy()
+			meta.SetStatusCondition(&status.Conditions, throttledCondition(namespaceLabel, reason))
+			if equality.Semantic.DeepEqual(status, namespaceLabel.Status) {
+				return nil
+			}
+			patch := client.MergeFromWithOptions(namespaceLabel.DeepCopy(), client.MergeFromWithOptimisticLock{})
+			namespaceLabel.Status = status
+			return r.Status().Patch(ctx, &namespaceLabel, patch)
+		})
+		if err = client.IgnoreNotFound(err); err != nil {
+			logger.Error(err, "unable to update status of namespaceLabel", "namespaceLabel", namespaceLabel.Name)
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func throttledCondition(namespaceLabel omerv1.NamespaceLabel, reason throttle.Reason) metav1.Condition {
+	condition := metav1.Condition{
+		Type:               omerv1.ConditionTypeThrottled,
+		Status:             metav1.ConditionTrue,
+		Reason:             omerv1.ReasonRateLimited,
+		Message:            "the namespace write is over the write rate limit, it is retried later",
+		ObservedGeneration: namespaceLabel.Generation,
+	}
+	if reason == throttle.ReasonCircuitOpen {
+		condition.Reason = omerv1.ReasonCircuitOpen
+		condition.Message = "the namespace writes are paused after repeated write failures, they resume when the circuit breaker closes"
+	}
+	return condition
+}
+
+// the rate limiter of the failed reconciles, the default controller-runtime rate limiter with the
+// configured bounds of the per namespace exponential backoff
+func retryRateLimiter(baseDelay, maxDelay time.Duration) workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(baseDelay, maxDelay),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(10), 100)},
+	)
+}
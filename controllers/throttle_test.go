@@ -0,0 +1,98 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	clocktesting "k8s.io/utils/clock/testing"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	omerv1 "omer.io/namespacelabel/api/v1"
+	"omer.io/namespacelabel/pkg/throttle"
+)
+
+var _ = Describe("Namespace write throttling", func() {
+
+	Context("When a namespace is written more often than its write interval", func() {
+		It("Should requeue the write and report the Throttled condition until the interval passed", func() {
+			scheme := runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).Should(Succeed())
+			Expect(omerv1.AddToScheme(scheme)).Should(Succeed())
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+				&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns"}},
+				&omerv1.NamespaceLabel{
+					ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "ns"},
+					Spec:       omerv1.NamespaceLabelSpec{Labels: map[string]string{"team": "a"}},
+				},
+			).Build()
+			clock := clocktesting.NewFakePassiveClock(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+			r := &NamespaceLabelReconciler{Client: c, Scheme: scheme, Throttle: &throttle.Throttle{
+				NamespaceWriteInterval: time.Minute,
+				NamespaceWriteBurst:    1,
+				Clock:                  clock,
+			}}
+			ctx := context.Background()
+			request := ctrl.Request{NamespacedName: types.NamespacedName{Name: "ns"}}
+			expectReconcile := func(isRequeued bool, team string, throttled metav1.ConditionStatus) {
+				result, err := r.Reconcile(ctx, request)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(result.RequeueAfter > 0).Should(Equal(isRequeued))
+				var namespace v1.Namespace
+				Expect(c.Get(ctx, request.NamespacedName, &namespace)).Should(Succeed())
+				Expect(namespace.Labels["team"]).Should(Equal(team))
+				var namespaceLabel omerv1.NamespaceLabel
+				Expect(c.Get(ctx, types.NamespacedName{Namespace: "ns", Name: "a"}, &namespaceLabel)).Should(Succeed())
+				condition := meta.FindStatusCondition(namespaceLabel.Status.Conditions, omerv1.ConditionTypeThrottled)
+				if throttled == "" {
+					Expect(condition).Should(BeNil())
+				} else {
+					Expect(condition).ShouldNot(BeNil())
+					Expect(condition.Status).Should(Equal(throttled))
+				}
+			}
+			setTeam := func(team string) {
+				var namespaceLabel omerv1.NamespaceLabel
+				Expect(c.Get(ctx, client.ObjectKey{Namespace: "ns", Name: "a"}, &namespaceLabel)).Should(Succeed())
+				namespaceLabel.Spec.Labels["team"] = team
+				Expect(c.Update(ctx, &namespaceLabel)).Should(Succeed())
+			}
+
+			By("Writing the namespace with its burst, a synced namespace is not throttled")
+			expectReconcile(false, "a", "")
+			expectReconcile(false, "a", "")
+
+			By("Waiting for the write interval before the next write and keeping the synced labels")
+			setTeam("b")
+			expectReconcile(true, "a", metav1.ConditionTrue)
+
+			clock.SetTime(clock.Now().Add(time.Minute))
+			expectReconcile(false, "b", metav1.ConditionFalse)
+		})
+	})
+})
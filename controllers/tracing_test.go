@@ -0,0 +1,108 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	omerv1 "omer.io/namespacelabel/api/v1"
+	"omer.io/namespacelabel/pkg/tracing"
+)
+
+var _ = Describe("Reconcile tracing", func() {
+
+	Context("When a namespace is reconciled with a tracing client", func() {
+		It("Should trace the reconcile with its API calls and NamespaceLabels as child spans", func() {
+			exporter := tracetest.NewInMemoryExporter()
+			previous := otel.GetTracerProvider()
+			otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+			DeferCleanup(func() { otel.SetTracerProvider(previous) })
+
+			scheme := runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).Should(Succeed())
+			Expect(omerv1.AddToScheme(scheme)).Should(Succeed())
+			deletedAt := metav1.Now()
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+				&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns"}},
+				&omerv1.NamespaceLabel{
+					ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "ns"},
+					Spec:       omerv1.NamespaceLabelSpec{Labels: map[string]string{"team": "a"}},
+				},
+				&omerv1.NamespaceLabel{
+					ObjectMeta: metav1.ObjectMeta{Name: "b", Namespace: "ns", DeletionTimestamp: &deletedAt, Finalizers: []string{nsLabelFinalizer}},
+					Spec:       omerv1.NamespaceLabelSpec{Labels: map[string]string{"env": "b"}},
+				},
+			).Build()
+			r := &NamespaceLabelReconciler{Client: tracing.WrapClient(c), Scheme: scheme}
+			_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: "ns"}})
+			Expect(err).ShouldNot(HaveOccurred())
+
+			spans := exporter.GetSpans()
+			find := func(name string, attributes ...attribute.KeyValue) tracetest.SpanStub {
+				for _, span := range spans {
+					if span.Name == name && hasAttributes(span, attributes) {
+						return span
+					}
+				}
+				Fail(fmt.Sprintf("no span %s with %v in %d spans", name, attributes, len(spans)))
+				return tracetest.SpanStub{}
+			}
+			reconcile := find("Reconcile", tracing.NamespaceKey.String("ns"))
+			children := []tracetest.SpanStub{
+				find("Get Namespace", tracing.NameKey.String("ns")),
+				find("List NamespaceLabelList", tracing.NamespaceKey.String("ns")),
+				find("Patch Namespace", tracing.NameKey.String("ns")),
+				find("handleSyncNamespaceLabel", tracing.NamespaceKey.String("ns"), tracing.NamespaceLabelKey.String("a")),
+				find("cleanupNamespaceLabel", tracing.NamespaceKey.String("ns"), tracing.NamespaceLabelKey.String("b")),
+			}
+			for _, child := range children {
+				Expect(child.Parent.SpanID()).Should(Equal(reconcile.SpanContext.SpanID()), "span %s is not a child of the reconcile span", child.Name)
+			}
+			find("Patch NamespaceLabel", tracing.NamespaceKey.String("ns"), tracing.NameKey.String("b"))
+		})
+	})
+})
+
+func hasAttributes(span tracetest.SpanStub, attributes []attribute.KeyValue) bool {
+	for _, want := range attributes {
+		isFound := false
+		for _, got := range span.Attributes {
+			if got == want {
+				isFound = true
+			}
+		}
+		if !isFound {
+			return false
+		}
+	}
+	return true
+}
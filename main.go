@@ -17,29 +17,53 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"os"
+	"path/filepath"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/discovery"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	configv1alpha1 "k8s.io/component-base/config/v1alpha1"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/cluster"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/yaml"
 	//"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 
 	"github.com/go-logr/zapr"
 	ecszap "go.elastic.co/ecszap"
+	"go.opentelemetry.io/otel"
 	"go.uber.org/zap"
 
 	"strings"
 
+	configv1 "omer.io/namespacelabel/api/config/v1"
 	omerv1 "omer.io/namespacelabel/api/v1"
 	"omer.io/namespacelabel/controllers"
+	"omer.io/namespacelabel/pkg/health"
+	"omer.io/namespacelabel/pkg/hub"
+	"omer.io/namespacelabel/pkg/podsecurity"
+	"omer.io/namespacelabel/pkg/scope"
+	"omer.io/namespacelabel/pkg/sharding"
+	"omer.io/namespacelabel/pkg/throttle"
+	"omer.io/namespacelabel/pkg/tracing"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -52,20 +76,80 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(omerv1.AddToScheme(scheme))
+	utilruntime.Must(configv1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
 func main() {
+	var configFile string
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
 	var protectedLabels string
+	var protectedLabelPrefixes string
+	var approvalRequiredLabels string
+	var approvalRequiredLabelPrefixes string
+	var syncPeriod time.Duration
+	var maxConcurrentReconciles int
+	var watchNamespaces string
+	var namespaceSelector string
+	var printRBAC bool
+	var rbacServiceAccount string
+	var conflictPolicy string
+	var orphanLabelPolicy string
+	var orphanSweepInterval time.Duration
+	var snapshotInterval time.Duration
+	var enableSharding bool
+	var paused bool
+	var enableHub bool
+	var stuckThreshold time.Duration
+	var tracingOptions tracing.Options
+	flag.StringVar(&configFile, "config", "",
+		"The manager will load its initial configuration from this file. "+
+			"Flags set on the command line override the values in this file.")
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
 	flag.StringVar(&protectedLabels, "protectedLabels", "kubernetes.io", "list of protected labels")
+	flag.StringVar(&protectedLabelPrefixes, "protected-label-prefixes", "", "list of protected label key prefixes")
+	flag.StringVar(&approvalRequiredLabels, "approval-required-labels", "",
+		"list of label keys a NamespaceLabel only sets, changes or removes once a LabelChangeApproval approves its generation")
+	flag.StringVar(&approvalRequiredLabelPrefixes, "approval-required-label-prefixes", "", "list of label key prefixes that require approval")
+	flag.DurationVar(&syncPeriod, "sync-period", 0, "The minimum frequency at which watched resources are resynced.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1, "The number of namespaces reconciled in parallel, all the NamespaceLabels of a namespace are reconciled together.")
+	flag.StringVar(&watchNamespaces, "watch-namespaces", "", "list of namespaces to watch, all namespaces when empty")
+	flag.StringVar(&namespaceSelector, "namespace-selector", "",
+		"Label selector of the namespaces to watch, e.g. tenant=a, all namespaces when empty.")
+	flag.BoolVar(&printRBAC, "print-rbac", false,
+		"Print the RBAC the controller needs for its namespaces and exit, "+
+			"narrower than the default roles when --watch-namespaces is set.")
+	flag.StringVar(&rbacServiceAccount, "rbac-service-account", "projects-system/projects-controller-manager",
+		"The namespace/name of the service account the roles printed by --print-rbac are bound to.")
+	flag.StringVar(&conflictPolicy, "conflict-policy", string(configv1.ConflictPolicySkip),
+		"What to do with labels that already exist in the namespace, Skip or Overwrite.")
+	flag.StringVar(&orphanLabelPolicy, "orphan-label-policy", string(configv1.OrphanLabelPolicyReport),
+		"What to do with managed labels no NamespaceLabel claims anymore, Report or Remove.")
+	flag.DurationVar(&orphanSweepInterval, "orphan-sweep-interval", time.Hour,
+		"The time between two scans for orphaned labels, 0 disables the sweeper.")
+	flag.DurationVar(&snapshotInterval, "snapshot-interval", 0,
+		"The time between two snapshots of the labels of every namespace, 0 disables the snapshots.")
+	flag.BoolVar(&enableSharding, "sharding", false,
+		"Split the namespaces between the replicas of the controller manager instead of electing a leader, cannot be used with --hub.")
+	flag.BoolVar(&paused, "paused", false,
+		"Stop the writes to all the namespaces, the NamespaceLabels still report the labels that differ.")
+	flag.BoolVar(&enableHub, "hub", false,
+		"Propagate the FederatedNamespaceLabels of this cluster to the clusters registered by kubeconfig Secrets.")
+	flag.DurationVar(&stuckThreshold, "stuck-reconcile-threshold", 10*time.Minute,
+		"The liveness probe fails when a reconcile runs, or requests wait in the queue without progress, "+
+			"longer than this, 0 disables the check.")
+	flag.StringVar((*string)(&tracingOptions.Exporter), "tracing-exporter", string(tracing.ExporterNone),
+		"Where the spans of the reconciles and the API calls are sent, none, otlp or stdout.")
+	flag.StringVar(&tracingOptions.Endpoint, "tracing-endpoint", "",
+		"The host:port of the OTLP gRPC collector, OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4317 when empty.")
+	flag.BoolVar(&tracingOptions.Insecure, "tracing-insecure", false, "Send the spans to the OTLP collector without TLS.")
+	flag.Float64Var(&tracingOptions.SampleRatio, "tracing-sample-ratio", 1, "The part of the reconciles that are traced, between 0 and 1.")
 	flag.Parse()
 
 	encoderConfig := ecszap.NewDefaultEncoderConfig()
@@ -75,13 +159,122 @@ func main() {
 
 	//ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:                 scheme,
-		MetricsBindAddress:     metricsAddr,
-		Port:                   9443,
-		HealthProbeBindAddress: probeAddr,
-		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "29338d5e.omer.io",
+	managerConfig := configv1.ManagerConfig{}
+	if configFile != "" {
+		loader := ctrl.ConfigFile().AtPath(configFile).OfKind(&managerConfig)
+		if err := loader.InjectScheme(scheme); err != nil {
+			setupLog.Error(err, "unable to load the config file", "file", configFile)
+			os.Exit(1)
+		}
+		if _, err := loader.Complete(); err != nil {
+			setupLog.Error(err, "unable to load the config file", "file", configFile)
+			os.Exit(1)
+		}
+	}
+
+	//flags set on the command line win, the flag defaults only fill what the file left empty
+	setFlags := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+	useFlag := func(name string, unsetInFile bool) bool {
+		return setFlags[name] || unsetInFile
+	}
+
+	if useFlag("metrics-bind-address", managerConfig.Metrics.BindAddress == "") {
+		managerConfig.Metrics.BindAddress = metricsAddr
+	}
+	if useFlag("health-probe-bind-address", managerConfig.Health.HealthProbeBindAddress == "") {
+		managerConfig.Health.HealthProbeBindAddress = probeAddr
+	}
+	if useFlag("leader-elect", managerConfig.LeaderElection == nil || managerConfig.LeaderElection.LeaderElect == nil) {
+		if managerConfig.LeaderElection == nil {
+			managerConfig.LeaderElection = &configv1alpha1.LeaderElectionConfiguration{}
+		}
+		managerConfig.LeaderElection.LeaderElect = &enableLeaderElection
+	}
+	if managerConfig.LeaderElection.ResourceName == "" {
+		managerConfig.LeaderElection.ResourceName = "29338d5e.omer.io"
+	}
+	if setFlags["sync-period"] {
+		managerConfig.SyncPeriod = &metav1.Duration{Duration: syncPeriod}
+	}
+	if useFlag("protectedLabels", len(managerConfig.ProtectedLabels) == 0) {
+		managerConfig.ProtectedLabels = splitList(protectedLabels)
+	}
+	if useFlag("protected-label-prefixes", len(managerConfig.ProtectedLabelPrefixes) == 0) {
+		managerConfig.ProtectedLabelPrefixes = splitList(protectedLabelPrefixes)
+	}
+	if useFlag("approval-required-labels", len(managerConfig.ApprovalRequiredLabels) == 0) {
+		managerConfig.ApprovalRequiredLabels = splitList(approvalRequiredLabels)
+	}
+	if useFlag("approval-required-label-prefixes", len(managerConfig.ApprovalRequiredLabelPrefixes) == 0) {
+		managerConfig.ApprovalRequiredLabelPrefixes = splitList(approvalRequiredLabelPrefixes)
+	}
+	if useFlag("max-concurrent-reconciles", managerConfig.MaxConcurrentReconciles == 0) {
+		managerConfig.MaxConcurrentReconciles = maxConcurrentReconciles
+	}
+	if useFlag("watch-namespaces", len(managerConfig.WatchNamespaces) == 0) {
+		managerConfig.WatchNamespaces = splitList(watchNamespaces)
+	}
+	if setFlags["namespace-selector"] {
+		selector, err := metav1.ParseToLabelSelector(namespaceSelector)
+		if err != nil {
+			setupLog.Error(err, "invalid namespace selector", "selector", namespaceSelector)
+			os.Exit(1)
+		}
+		managerConfig.NamespaceSelector = selector
+	}
+	if useFlag("conflict-policy", managerConfig.Enforcement.ConflictPolicy == "") {
+		managerConfig.Enforcement.ConflictPolicy = configv1.ConflictPolicy(conflictPolicy)
+	}
+	if useFlag("orphan-label-policy", managerConfig.OrphanLabels.Policy == "") {
+		managerConfig.OrphanLabels.Policy = configv1.OrphanLabelPolicy(orphanLabelPolicy)
+	}
+	if useFlag("orphan-sweep-interval", managerConfig.OrphanLabels.SweepInterval == nil) {
+		managerConfig.OrphanLabels.SweepInterval = &metav1.Duration{Duration: orphanSweepInterval}
+	}
+	if useFlag("snapshot-interval", managerConfig.Snapshots.Interval == nil) {
+		managerConfig.Snapshots.Interval = &metav1.Duration{Duration: snapshotInterval}
+	}
+	if useFlag("stuck-reconcile-threshold", managerConfig.Liveness.StuckThreshold == nil) {
+		managerConfig.Liveness.StuckThreshold = &metav1.Duration{Duration: stuckThreshold}
+	}
+	if setFlags["paused"] {
+		managerConfig.Paused = paused
+	}
+	if setFlags["sharding"] {
+		managerConfig.Sharding.Enabled = enableSharding
+	}
+	if managerConfig.Sharding.LeaseNamespace == "" {
+		managerConfig.Sharding.LeaseNamespace = os.Getenv("POD_NAMESPACE")
+	}
+	if setFlags["hub"] {
+		managerConfig.Hub.Enabled = enableHub
+	}
+	if managerConfig.Hub.ClusterSecretNamespace == "" {
+		managerConfig.Hub.ClusterSecretNamespace = os.Getenv("POD_NAMESPACE")
+	}
+
+	managerConfig.Default()
+	if err := managerConfig.Validate(); err != nil {
+		setupLog.Error(err, "invalid manager configuration")
+		os.Exit(1)
+	}
+
+	namespaceScope := scope.Scope{
+		Namespaces: managerConfig.WatchNamespaces,
+		Selector:   managerConfig.NamespaceSelector,
+	}
+	if printRBAC {
+		if err := printScopeRBAC(namespaceScope, rbacServiceAccount); err != nil {
+			setupLog.Error(err, "unable to print the rbac")
+			os.Exit(1)
+		}
+		return
+	}
+
+	options, err := ctrl.Options{
+		Scheme: scheme,
+		Port:   9443,
 		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
 		// when the Manager ends. This requires the binary to immediately end when the
 		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
@@ -93,37 +286,270 @@ func main() {
 		// if you are doing or is intended to do any operation such as perform cleanups
 		// after the manager stops then its usage might be unsafe.
 		// LeaderElectionReleaseOnCancel: true,
-	})
+	}.AndFrom(&managerConfig)
+	if err != nil {
+		setupLog.Error(err, "unable to apply the manager configuration")
+		os.Exit(1)
+	}
+	if options.NewCache, err = namespaceScope.NewCache(); err != nil {
+		setupLog.Error(err, "unable to set up the namespace scope")
+		os.Exit(1)
+	}
+
+	restConfig := ctrl.GetConfigOrDie()
+	tracerProvider, err := tracing.NewTracerProvider(context.Background(), tracingOptions)
+	if err != nil {
+		setupLog.Error(err, "unable to set up tracing")
+		os.Exit(1)
+	}
+	if tracerProvider != nil {
+		otel.SetTracerProvider(tracerProvider)
+		tracing.WrapTransport(restConfig)
+		options.NewClient = newTracingClient
+	}
+
+	mgr, err := ctrl.NewManager(restConfig, options)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
-	//parse the protected list
-	protectedMap := strings.Split(protectedLabels, ",")
+	var shard *sharding.Coordinator
+	if managerConfig.Sharding.Enabled {
+		if shard, err = newShardCoordinator(mgr, managerConfig.Sharding); err != nil {
+			setupLog.Error(err, "unable to set up sharding")
+			os.Exit(1)
+		}
+	}
+
+	progress := &health.Progress{
+		Threshold:  managerConfig.Liveness.StuckThreshold.Duration,
+		QueueDepth: health.QueueDepth(ctrlmetrics.Registry, "namespacelabel"),
+	}
+	namespaceLabelReconciler := &controllers.NamespaceLabelReconciler{
+		Client:                  mgr.GetClient(),
+		Scheme:                  mgr.GetScheme(),
+		ProtectedLabels:         managerConfig.ProtectedLabels,
+		ProtectedLabelPrefixes:  managerConfig.ProtectedLabelPrefixes,
+		ConflictPolicy:          managerConfig.Enforcement.ConflictPolicy,
+		MaxConcurrentReconciles: managerConfig.MaxConcurrentReconciles,
+		Shard:                   shard,
+
+		ApprovalRequiredLabels:        managerConfig.ApprovalRequiredLabels,
+		ApprovalRequiredLabelPrefixes: managerConfig.ApprovalRequiredLabelPrefixes,
+		PodSecurity:                   podsecurity.ServerDryRun{Config: mgr.GetConfig()},
 
-	if err = (&controllers.NamespaceLabelReconciler{
-		Client:          mgr.GetClient(),
-		Scheme:          mgr.GetScheme(),
-		ProtectedLabels: protectedMap,
-	}).SetupWithManager(mgr); err != nil {
+		Paused:         managerConfig.Paused,
+		Throttle:       newThrottle(managerConfig.Throttling),
+		RetryBaseDelay: durationOf(managerConfig.Throttling.RetryBaseDelay),
+		RetryMaxDelay:  durationOf(managerConfig.Throttling.RetryMaxDelay),
+		Progress:       progress,
+	}
+	if err = namespaceLabelReconciler.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "NamespaceLabel")
 		os.Exit(1)
 	}
+	if managerConfig.OrphanLabels.SweepInterval.Duration > 0 {
+		if err = mgr.Add(&controllers.OrphanLabelSweeper{
+			Client:   mgr.GetClient(),
+			Recorder: mgr.GetEventRecorderFor("namespacelabel-orphan-sweeper"),
+			Interval: managerConfig.OrphanLabels.SweepInterval.Duration,
+			Policy:   managerConfig.OrphanLabels.Policy,
+			Shard:    shard,
+			Paused:   managerConfig.Paused,
+			Reader:   mgr.GetAPIReader(),
+		}); err != nil {
+			setupLog.Error(err, "unable to set up the orphaned labels sweeper")
+			os.Exit(1)
+		}
+	}
+	if managerConfig.Snapshots.Interval.Duration > 0 {
+		if err = mgr.Add(&controllers.LabelSnapshotter{
+			Client:   mgr.GetClient(),
+			Interval: managerConfig.Snapshots.Interval.Duration,
+			Retain:   int(managerConfig.Snapshots.Retain),
+			Shard:    shard,
+		}); err != nil {
+			setupLog.Error(err, "unable to set up the label snapshots")
+			os.Exit(1)
+		}
+	}
+	if managerConfig.Hub.Enabled {
+		if err = (&controllers.FederatedNamespaceLabelReconciler{
+			Client: mgr.GetClient(),
+			Scheme: mgr.GetScheme(),
+			Clusters: &hub.Registry{
+				Reader:    mgr.GetAPIReader(),
+				Namespace: managerConfig.Hub.ClusterSecretNamespace,
+				Factory: hub.KubeconfigClientFactory{
+					Scheme:  mgr.GetScheme(),
+					Timeout: managerConfig.Hub.ClusterTimeout.Duration,
+				},
+			},
+			ResyncPeriod:    managerConfig.Hub.ResyncPeriod.Duration,
+			WithdrawTimeout: managerConfig.Hub.WithdrawTimeout.Duration,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "FederatedNamespaceLabel")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
 	}
-	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
+	if err := mgr.AddHealthzCheck("reconcile-progress", progress.Check); err != nil {
+		setupLog.Error(err, "unable to set up health check")
+		os.Exit(1)
+	}
+	if err := addReadyzChecks(mgr, namespaceLabelReconciler, webhookCertFile(options.CertDir)); err != nil {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
 
 	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	err = mgr.Start(ctrl.SetupSignalHandler())
+	if tracerProvider != nil {
+		//flush the spans that are still batched
+		if err := tracerProvider.Shutdown(context.Background()); err != nil {
+			setupLog.Error(err, "unable to flush the spans")
+		}
+	}
+	if err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
 }
+
+// splitList parses a comma separated flag value, an empty value gives an empty list
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// newThrottle returns the limits of the namespace writes, nil when no limit is configured
+func newThrottle(config configv1.ThrottlingConfig) *throttle.Throttle {
+	if config.WritesPerSecond == 0 && config.NamespaceWriteInterval == nil && config.CircuitBreaker.MaxFailures == 0 {
+		return nil
+	}
+	return &throttle.Throttle{
+		WritesPerSecond:        float64(config.WritesPerSecond),
+		WriteBurst:             int(config.WriteBurst),
+		NamespaceWriteInterval: durationOf(config.NamespaceWriteInterval),
+		NamespaceWriteBurst:    int(config.NamespaceWriteBurst),
+		MaxFailures:            int(config.CircuitBreaker.MaxFailures),
+		FailureWindow:          durationOf(config.CircuitBreaker.FailureWindow),
+		OpenDuration:           durationOf(config.CircuitBreaker.OpenDuration),
+	}
+}
+
+func durationOf(duration *metav1.Duration) time.Duration {
+	if duration == nil {
+		return 0
+	}
+	return duration.Duration
+}
+
+// addReadyzChecks adds the checks the manager is ready after: the caches are synced, the NamespaceLabel
+// CRD is served and the protected labels are loaded. The webhook certificate is checked when certFile is set
+func addReadyzChecks(mgr ctrl.Manager, reconciler *controllers.NamespaceLabelReconciler, certFile string) error {
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(mgr.GetConfig())
+	if err != nil {
+		return err
+	}
+	checks := map[string]healthz.Checker{
+		"readyz":             healthz.Ping,
+		"cache-sync":         health.CacheSynced(mgr.GetCache(), time.Second),
+		"namespacelabel-crd": health.ResourceServed(discoveryClient, omerv1.GroupVersion, "namespacelabels"),
+		"protected-labels":   reconciler.CheckPolicy,
+	}
+	if certFile != "" {
+		checks["webhook-certificate"] = health.CertificateValid(certFile, nil)
+	}
+	for name, checker := range checks {
+		if err := mgr.AddReadyzCheck(name, checker); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// webhookCertFile returns the serving certificate of the webhook server, empty when the webhooks are
+// not enabled: the deployment only mounts a certificate with the webhook kustomize patches
+func webhookCertFile(certDir string) string {
+	if certDir == "" {
+		certDir = filepath.Join(os.TempDir(), "k8s-webhook-server", "serving-certs")
+	}
+	certFile := filepath.Join(certDir, "tls.crt")
+	if _, err := os.Stat(certFile); err != nil {
+		return ""
+	}
+	return certFile
+}
+
+// newTracingClient is the default client of the manager with a span around every call
+func newTracingClient(cache cache.Cache, config *rest.Config, options client.Options, uncachedObjects ...client.Object) (client.Client, error) {
+	c, err := cluster.DefaultNewClient(cache, config, options, uncachedObjects...)
+	if err != nil {
+		return nil, err
+	}
+	return tracing.WrapClient(c), nil
+}
+
+// newShardCoordinator adds the coordinator of the replica to the manager. The replica is named after its pod,
+// its Lease is read and written directly, the manager cache would watch every Lease of the cluster
+func newShardCoordinator(mgr ctrl.Manager, config configv1.ShardingConfig) (*sharding.Coordinator, error) {
+	identity := os.Getenv("POD_NAME")
+	if identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		identity = hostname
+	}
+	shard := &sharding.Coordinator{
+		Client:        mgr.GetClient(),
+		Reader:        mgr.GetAPIReader(),
+		Identity:      identity,
+		Namespace:     config.LeaseNamespace,
+		Group:         config.Group,
+		LeaseDuration: config.LeaseDuration.Duration,
+		RenewInterval: config.RenewInterval.Duration,
+		SettlePeriod:  config.SettlePeriod.Duration,
+		Namespaces: func(ctx context.Context) ([]string, error) {
+			var namespaceList corev1.NamespaceList
+			if err := mgr.GetClient().List(ctx, &namespaceList); err != nil {
+				return nil, err
+			}
+			names := make([]string, 0, len(namespaceList.Items))
+			for _, namespace := range namespaceList.Items {
+				names = append(names, namespace.Name)
+			}
+			return names, nil
+		},
+	}
+	return shard, mgr.Add(shard)
+}
+
+// printScopeRBAC writes the roles of the scope to stdout as yaml documents. They carry the names the
+// default kustomization gives to the manager role, applying them replaces the cluster wide rules
+func printScopeRBAC(namespaceScope scope.Scope, serviceAccount string) error {
+	namespace, name, isFound := strings.Cut(serviceAccount, "/")
+	if !isFound || namespace == "" || name == "" {
+		return fmt.Errorf("service account %q is not namespace/name", serviceAccount)
+	}
+	for _, object := range namespaceScope.RBAC("projects-manager-role", types.NamespacedName{Namespace: namespace, Name: name}) {
+		document, err := yaml.Marshal(object)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("---\n%s", document)
+	}
+	return nil
+}
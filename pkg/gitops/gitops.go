@@ -0,0 +1,304 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package gitops converts between the labels of live namespaces and NamespaceLabel manifests.
+// Export bootstraps the manifests from the labels the namespaces have today, Verify compares
+// a set of manifests to the namespaces and reports the drift. Both work on namespaces read
+// from a cluster or from a saved `kubectl get namespaces -o yaml` dump.
+package gitops
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+
+	omerv1 "omer.io/namespacelabel/api/v1"
+	"omer.io/namespacelabel/pkg/labelsync"
+)
+
+// DriftReason explains why a label of a manifest does not match the namespace
+type DriftReason string
+
+const (
+	// DriftNamespaceNotFound means the namespace of the manifest does not exist
+	DriftNamespaceNotFound DriftReason = "NamespaceNotFound"
+	// DriftLabelMissing means the namespace does not have the label
+	DriftLabelMissing DriftReason = "LabelMissing"
+	// DriftValueDiffers means the namespace has the label with another value
+	DriftValueDiffers DriftReason = "ValueDiffers"
+	// DriftConflict means two manifests of the namespace want different values for the label
+	DriftConflict DriftReason = "Conflict"
+	// DriftProtected means the label is protected, the controller will never write it
+	DriftProtected DriftReason = "Protected"
+	// DriftUnmanaged means the namespace has a label no manifest declares, only reported when asked for
+	DriftUnmanaged DriftReason = "Unmanaged"
+)
+
+// Drift is one label that differs between the manifests and a namespace
+type Drift struct {
+	Namespace string
+	Key       string
+	Reason    DriftReason
+	// Want is the value of the manifests, Got the value of the namespace
+	Want string
+	Got  string
+}
+
+// ReadNamespaces decodes namespaces from yaml or json, a List of namespaces like `kubectl get
+// namespaces -o yaml` prints, single Namespace documents or both separated by ---
+func ReadNamespaces(r io.Reader) ([]corev1.Namespace, error) {
+	var namespaces []corev1.Namespace
+	err := readDocuments(r, func(document []byte) error {
+		var typeMeta metav1.TypeMeta
+		if err := yaml.Unmarshal(document, &typeMeta); err != nil {
+			return err
+		}
+		switch typeMeta.Kind {
+		case "List", "NamespaceList":
+			var list corev1.NamespaceList
+			if err := yaml.Unmarshal(document, &list); err != nil {
+				return err
+			}
+			namespaces = append(namespaces, list.Items...)
+		case "Namespace":
+			var namespace corev1.Namespace
+			if err := yaml.Unmarshal(document, &namespace); err != nil {
+				return err
+			}
+			namespaces = append(namespaces, namespace)
+		default:
+			return fmt.Errorf("unexpected kind %q, expected Namespace or List", typeMeta.Kind)
+		}
+		return nil
+	})
+	return namespaces, err
+}
+
+// ReadNamespaceLabels reads the NamespaceLabels of every yaml and json file under dir,
+// the documents of other kinds are ignored
+func ReadNamespaceLabels(dir string) ([]omerv1.NamespaceLabel, error) {
+	var namespaceLabels []omerv1.NamespaceLabel
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		switch filepath.Ext(path) {
+		case ".yaml", ".yml", ".json":
+		default:
+			return nil
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		err = readDocuments(file, func(document []byte) error {
+			var typeMeta metav1.TypeMeta
+			if err := yaml.Unmarshal(document, &typeMeta); err != nil {
+				return err
+			}
+			if typeMeta.Kind != "NamespaceLabel" || typeMeta.GroupVersionKind().Group != omerv1.GroupVersion.Group {
+				return nil
+			}
+			var namespaceLabel omerv1.NamespaceLabel
+			if err := yaml.UnmarshalStrict(document, &namespaceLabel); err != nil {
+				return err
+			}
+			if namespaceLabel.Namespace == "" || namespaceLabel.Name == "" {
+				return errors.New("NamespaceLabel without name or namespace")
+			}
+			namespaceLabels = append(namespaceLabels, namespaceLabel)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		return nil
+	})
+	return namespaceLabels, err
+}
+
+// the function call handle for every non empty document of the stream
+func readDocuments(r io.Reader, handle func(document []byte) error) error {
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(r))
+	for {
+		document, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(bytes.TrimSpace(document)) == 0 {
+			continue
+		}
+		if err := handle(document); err != nil {
+			return err
+		}
+	}
+}
+
+// Export returns one NamespaceLabel named name per namespace, holding the labels of the namespace
+// that are not protected by the policy. Namespaces without such labels are left out
+func Export(namespaces []corev1.Namespace, policy labelsync.Policy, name string) []omerv1.NamespaceLabel {
+	var namespaceLabels []omerv1.NamespaceLabel
+	for _, namespace := range namespaces {
+		labels := make(map[string]string)
+		for key, value := range namespace.Labels {
+			if !policy.IsProtected(key) {
+				labels[key] = value
+			}
+		}
+		if len(labels) == 0 {
+			continue
+		}
+		namespaceLabels = append(namespaceLabels, omerv1.NamespaceLabel{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: omerv1.GroupVersion.String(),
+				Kind:       "NamespaceLabel",
+			},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace.Name},
+			Spec:       omerv1.NamespaceLabelSpec{Labels: labels},
+		})
+	}
+	sort.Slice(namespaceLabels, func(i, j int) bool { return namespaceLabels[i].Namespace < namespaceLabels[j].Namespace })
+	return namespaceLabels
+}
+
+// Verify compares the labels of the NamespaceLabels to the namespaces. With unmanaged, the labels of
+// every namespace that no manifest declares and the policy does not protect are reported too, in the
+// namespaces without any NamespaceLabel as well
+func Verify(namespaceLabels []omerv1.NamespaceLabel, namespaces []corev1.Namespace, policy labelsync.Policy, unmanaged bool) []Drift {
+	byName := make(map[string]corev1.Namespace, len(namespaces))
+	for _, namespace := range namespaces {
+		byName[namespace.Name] = namespace
+	}
+	//the distinct wanted values of every label of every namespace, a key wanted with two values is a conflict
+	wanted := make(map[string]map[string][]string)
+	for _, namespaceLabel := range namespaceLabels {
+		if wanted[namespaceLabel.Namespace] == nil {
+			wanted[namespaceLabel.Namespace] = make(map[string][]string)
+		}
+		for key, value := range namespaceLabel.Spec.Labels {
+			values := wanted[namespaceLabel.Namespace][key]
+			if !slices.Contains(values, value) {
+				wanted[namespaceLabel.Namespace][key] = append(values, value)
+			}
+		}
+	}
+
+	var drifts []Drift
+	for namespaceName, labels := range wanted {
+		namespace, isFound := byName[namespaceName]
+		if !isFound {
+			drifts = append(drifts, Drift{Namespace: namespaceName, Reason: DriftNamespaceNotFound})
+			continue
+		}
+		for key, values := range labels {
+			got, isSet := namespace.Labels[key]
+			drift := Drift{Namespace: namespaceName, Key: key, Want: values[0], Got: got}
+			switch {
+			case policy.IsProtected(key):
+				drift.Reason = DriftProtected
+			case len(values) > 1:
+				drift.Reason = DriftConflict
+				sort.Strings(values)
+				drift.Want = strings.Join(values, ",")
+			case !isSet:
+				drift.Reason = DriftLabelMissing
+			case got != values[0]:
+				drift.Reason = DriftValueDiffers
+			default:
+				continue
+			}
+			drifts = append(drifts, drift)
+		}
+	}
+
+	if unmanaged {
+		for _, namespace := range namespaces {
+			for key, value := range namespace.Labels {
+				if _, isWanted := wanted[namespace.Name][key]; !isWanted && !policy.IsProtected(key) {
+					drifts = append(drifts, Drift{Namespace: namespace.Name, Key: key, Reason: DriftUnmanaged, Got: value})
+				}
+			}
+		}
+	}
+
+	sort.Slice(drifts, func(i, j int) bool {
+		if drifts[i].Namespace != drifts[j].Namespace {
+			return drifts[i].Namespace < drifts[j].Namespace
+		}
+		return drifts[i].Key < drifts[j].Key
+	})
+	return drifts
+}
+
+// Marshal returns the NamespaceLabels as yaml documents, without the status and the server set metadata
+func Marshal(namespaceLabels []omerv1.NamespaceLabel) ([]byte, error) {
+	var out bytes.Buffer
+	for _, namespaceLabel := range namespaceLabels {
+		object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&namespaceLabel)
+		if err != nil {
+			return nil, err
+		}
+		delete(object, "status")
+		if metadata, isMap := object["metadata"].(map[string]interface{}); isMap {
+			delete(metadata, "creationTimestamp")
+		}
+		document, err := yaml.Marshal(object)
+		if err != nil {
+			return nil, err
+		}
+		out.WriteString("---\n")
+		out.Write(document)
+	}
+	return out.Bytes(), nil
+}
+
+// WriteDir writes the NamespaceLabels to dir, one <namespace>.yaml file per namespace
+func WriteDir(dir string, namespaceLabels []omerv1.NamespaceLabel) error {
+	byNamespace := make(map[string][]omerv1.NamespaceLabel)
+	for _, namespaceLabel := range namespaceLabels {
+		byNamespace[namespaceLabel.Namespace] = append(byNamespace[namespaceLabel.Namespace], namespaceLabel)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for namespace, items := range byNamespace {
+		content, err := Marshal(items)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, namespace+".yaml"), content, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
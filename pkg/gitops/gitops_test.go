@@ -0,0 +1,238 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitops
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	omerv1 "omer.io/namespacelabel/api/v1"
+	"omer.io/namespacelabel/pkg/labelsync"
+)
+
+const namespaceDump = `apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Namespace
+  metadata:
+    name: team-a
+    labels:
+      kubernetes.io/metadata.name: team-a
+      team: a
+      cost-center: "1234"
+- apiVersion: v1
+  kind: Namespace
+  metadata:
+    name: kube-system
+    labels:
+      kubernetes.io/metadata.name: kube-system
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: team-b
+  labels:
+    kubernetes.io/metadata.name: team-b
+    team: b
+`
+
+var policy = labelsync.Policy{ProtectedLabelPrefixes: []string{"kubernetes.io/"}}
+
+func namespace(name string, labels map[string]string) corev1.Namespace {
+	return corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func namespaceLabel(namespace, name string, labels map[string]string) omerv1.NamespaceLabel {
+	return omerv1.NamespaceLabel{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec:       omerv1.NamespaceLabelSpec{Labels: labels},
+	}
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	namespaces, err := ReadNamespaces(strings.NewReader(namespaceDump))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(namespaces) != 3 {
+		t.Fatalf("expected 3 namespaces, got %d", len(namespaces))
+	}
+
+	exported := Export(namespaces, policy, "imported")
+	want := []omerv1.NamespaceLabel{
+		namespaceLabel("team-a", "imported", map[string]string{"team": "a", "cost-center": "1234"}),
+		namespaceLabel("team-b", "imported", map[string]string{"team": "b"}),
+	}
+	if len(exported) != len(want) {
+		t.Fatalf("expected %d NamespaceLabels, got %d", len(want), len(exported))
+	}
+	for i := range want {
+		if exported[i].Namespace != want[i].Namespace || !reflect.DeepEqual(exported[i].Spec, want[i].Spec) {
+			t.Errorf("expected %s %v, got %s %v", want[i].Namespace, want[i].Spec.Labels, exported[i].Namespace, exported[i].Spec.Labels)
+		}
+	}
+
+	dir := t.TempDir()
+	if err := WriteDir(dir, exported); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "team-a.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "status") || strings.Contains(string(content), "creationTimestamp") {
+		t.Errorf("expected no status and server set metadata in the manifest:\n%s", content)
+	}
+
+	//the exported manifests match the namespaces they were exported from
+	manifests, err := ReadNamespaceLabels(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifests) != 2 {
+		t.Fatalf("expected 2 manifests, got %d", len(manifests))
+	}
+	if drifts := Verify(manifests, namespaces, policy, true); len(drifts) != 0 {
+		t.Errorf("expected no drift, got %v", drifts)
+	}
+}
+
+func TestReadNamespaceLabelsIgnoresOtherKinds(t *testing.T) {
+	dir := t.TempDir()
+	manifest := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: other
+---
+apiVersion: omer.omer.io/v1
+kind: NamespaceLabel
+metadata:
+  name: a
+  namespace: team-a
+spec:
+  labels:
+    team: a
+`
+	if err := os.WriteFile(filepath.Join(dir, "team-a.yml"), []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte("# labels"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	namespaceLabels, err := ReadNamespaceLabels(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(namespaceLabels) != 1 || namespaceLabels[0].Spec.Labels["team"] != "a" {
+		t.Errorf("expected the NamespaceLabel a, got %v", namespaceLabels)
+	}
+
+	//a typo in a manifest is an error instead of a label that is silently dropped
+	typo := strings.Replace(manifest, "  labels:", "  label:", 1)
+	if err := os.WriteFile(filepath.Join(dir, "team-a.yml"), []byte(typo), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadNamespaceLabels(dir); err == nil {
+		t.Error("expected an error for the unknown field")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	namespaces := []corev1.Namespace{
+		namespace("team-a", map[string]string{"team": "a", "env": "dev", "extra": "x", "kubernetes.io/metadata.name": "team-a"}),
+		namespace("team-c", map[string]string{"owner": "c", "kubernetes.io/metadata.name": "team-c"}),
+	}
+	tests := []struct {
+		name            string
+		namespaceLabels []omerv1.NamespaceLabel
+		unmanaged       bool
+		want            []Drift
+	}{
+		{
+			name:            "matching labels",
+			namespaceLabels: []omerv1.NamespaceLabel{namespaceLabel("team-a", "a", map[string]string{"team": "a"})},
+		},
+		{
+			name: "missing namespace",
+			namespaceLabels: []omerv1.NamespaceLabel{
+				namespaceLabel("team-b", "a", map[string]string{"team": "b"}),
+			},
+			want: []Drift{{Namespace: "team-b", Reason: DriftNamespaceNotFound}},
+		},
+		{
+			name: "missing label and different value",
+			namespaceLabels: []omerv1.NamespaceLabel{
+				namespaceLabel("team-a", "a", map[string]string{"team": "b", "tier": "gold"}),
+			},
+			want: []Drift{
+				{Namespace: "team-a", Key: "team", Reason: DriftValueDiffers, Want: "b", Got: "a"},
+				{Namespace: "team-a", Key: "tier", Reason: DriftLabelMissing, Want: "gold"},
+			},
+		},
+		{
+			name: "two manifests want different values",
+			namespaceLabels: []omerv1.NamespaceLabel{
+				namespaceLabel("team-a", "a", map[string]string{"team": "a", "env": "dev"}),
+				namespaceLabel("team-a", "b", map[string]string{"team": "b", "env": "dev"}),
+			},
+			want: []Drift{{Namespace: "team-a", Key: "team", Reason: DriftConflict, Want: "a,b", Got: "a"}},
+		},
+		{
+			name: "conflict lists every value once",
+			namespaceLabels: []omerv1.NamespaceLabel{
+				namespaceLabel("team-a", "a", map[string]string{"team": "b"}),
+				namespaceLabel("team-a", "b", map[string]string{"team": "a"}),
+				namespaceLabel("team-a", "c", map[string]string{"team": "a"}),
+				namespaceLabel("team-a", "d", map[string]string{"team": "b"}),
+			},
+			want: []Drift{{Namespace: "team-a", Key: "team", Reason: DriftConflict, Want: "a,b", Got: "a"}},
+		},
+		{
+			name: "protected label",
+			namespaceLabels: []omerv1.NamespaceLabel{
+				namespaceLabel("team-a", "a", map[string]string{"kubernetes.io/metadata.name": "team-a"}),
+			},
+			want: []Drift{{Namespace: "team-a", Key: "kubernetes.io/metadata.name", Reason: DriftProtected, Want: "team-a", Got: "team-a"}},
+		},
+		{
+			name:            "unmanaged labels",
+			namespaceLabels: []omerv1.NamespaceLabel{namespaceLabel("team-a", "a", map[string]string{"team": "a"})},
+			unmanaged:       true,
+			want: []Drift{
+				{Namespace: "team-a", Key: "env", Reason: DriftUnmanaged, Got: "dev"},
+				{Namespace: "team-a", Key: "extra", Reason: DriftUnmanaged, Got: "x"},
+				{Namespace: "team-c", Key: "owner", Reason: DriftUnmanaged, Got: "c"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drifts := Verify(tt.namespaceLabels, namespaces, policy, tt.unmanaged)
+			if !reflect.DeepEqual(drifts, tt.want) {
+				t.Errorf("expected drifts %v, got %v", tt.want, drifts)
+			}
+		})
+	}
+}
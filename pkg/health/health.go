@@ -0,0 +1,95 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package health holds the readiness and liveness checks of the controller manager, served on its
+// health probe address next to the default ping.
+package health
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+)
+
+// CacheSynced is ready once the informers of the cache are synced, a probe waits for them at most timeout
+func CacheSynced(c cache.Cache, timeout time.Duration) healthz.Checker {
+	return func(req *http.Request) error {
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+		if !c.WaitForCacheSync(ctx) {
+			return errors.New("the caches are not synced")
+		}
+		return nil
+	}
+}
+
+// ResourceServed is ready once the API server serves the resource in the group version, e.g. the
+// NamespaceLabel CRD is installed and established
+func ResourceServed(client discovery.DiscoveryInterface, groupVersion schema.GroupVersion, resource string) healthz.Checker {
+	return func(_ *http.Request) error {
+		resources, err := client.ServerResourcesForGroupVersion(groupVersion.String())
+		if err != nil {
+			return fmt.Errorf("unable to discover %s: %w", groupVersion, err)
+		}
+		for _, apiResource := range resources.APIResources {
+			if apiResource.Name == resource {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s is not served in %s", resource, groupVersion)
+	}
+}
+
+// CertificateValid is ready while the PEM certificate in the file is within its validity period, the
+// webhook server fails every TLS handshake with an expired or missing certificate
+func CertificateValid(certFile string, passiveClock clock.PassiveClock) healthz.Checker {
+	if passiveClock == nil {
+		passiveClock = clock.RealClock{}
+	}
+	return func(_ *http.Request) error {
+		data, err := os.ReadFile(certFile)
+		if err != nil {
+			return err
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return fmt.Errorf("%s holds no PEM certificate", certFile)
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("%s: %w", certFile, err)
+		}
+		now := passiveClock.Now()
+		if now.Before(certificate.NotBefore) {
+			return fmt.Errorf("the certificate %s is not valid before %s", certFile, certificate.NotBefore.Format(time.RFC3339))
+		}
+		if now.After(certificate.NotAfter) {
+			return fmt.Errorf("the certificate %s expired at %s", certFile, certificate.NotAfter.Format(time.RFC3339))
+		}
+		return nil
+	}
+}
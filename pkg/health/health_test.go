@@ -0,0 +1,197 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	clienttesting "k8s.io/client-go/testing"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/cache/informertest"
+)
+
+func TestCacheSynced(t *testing.T) {
+	for _, synced := range []bool{true, false} {
+		synced := synced
+		check := CacheSynced(&informertest.FakeInformers{Synced: &synced}, time.Millisecond)
+		if err := check(&http.Request{}); (err == nil) != synced {
+			t.Errorf("synced %v: err = %v", synced, err)
+		}
+	}
+}
+
+func TestResourceServed(t *testing.T) {
+	groupVersion := schema.GroupVersion{Group: "omer.omer.io", Version: "v1"}
+	discovery := &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{Resources: []*metav1.APIResourceList{{
+		GroupVersion: groupVersion.String(),
+		APIResources: []metav1.APIResource{{Name: "namespacelabels"}, {Name: "namespacelabels/status"}},
+	}}}}
+	tests := []struct {
+		name         string
+		groupVersion schema.GroupVersion
+		resource     string
+		wantErr      bool
+	}{
+		{name: "served", groupVersion: groupVersion, resource: "namespacelabels"},
+		{name: "resource not served", groupVersion: groupVersion, resource: "namespacelabelrules", wantErr: true},
+		{name: "group version not served", groupVersion: schema.GroupVersion{Group: "omer.omer.io", Version: "v2"}, resource: "namespacelabels", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ResourceServed(discovery, tt.groupVersion, tt.resource)(&http.Request{})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want an error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCertificateValid(t *testing.T) {
+	notBefore := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	certFile := writeCertificate(t, notBefore, notBefore.Add(24*time.Hour))
+	tests := []struct {
+		name     string
+		certFile string
+		now      time.Time
+		wantErr  bool
+	}{
+		{name: "valid", certFile: certFile, now: notBefore.Add(time.Hour)},
+		{name: "not valid yet", certFile: certFile, now: notBefore.Add(-time.Hour), wantErr: true},
+		{name: "expired", certFile: certFile, now: notBefore.Add(25 * time.Hour), wantErr: true},
+		{name: "missing", certFile: filepath.Join(t.TempDir(), "tls.crt"), now: notBefore, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CertificateValid(tt.certFile, clocktesting.NewFakePassiveClock(tt.now))(&http.Request{})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want an error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// the function write a self signed PEM certificate valid between the times to a temporary file
+func writeCertificate(t *testing.T, notBefore, notAfter time.Time) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "webhook-service.projects-system.svc"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(t.TempDir(), "tls.crt")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile
+}
+
+func TestProgress(t *testing.T) {
+	clock := clocktesting.NewFakePassiveClock(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	depth := 0
+	progress := &Progress{
+		Threshold:  time.Minute,
+		QueueDepth: func() (int, error) { return depth, nil },
+		Clock:      clock,
+	}
+	check := func(wantErr bool) {
+		t.Helper()
+		if err := progress.Check(&http.Request{}); (err != nil) != wantErr {
+			t.Errorf("err = %v, want an error %v", err, wantErr)
+		}
+	}
+
+	//an idle controller is never stuck
+	check(false)
+	clock.SetTime(clock.Now().Add(time.Hour))
+	check(false)
+
+	//a reconcile that runs too long
+	done := progress.Start()
+	clock.SetTime(clock.Now().Add(59 * time.Second))
+	check(false)
+	clock.SetTime(clock.Now().Add(2 * time.Second))
+	check(true)
+	done()
+	check(false)
+
+	//requests that wait without any reconcile starting, counted from the first check that saw them
+	depth = 3
+	clock.SetTime(clock.Now().Add(time.Hour))
+	check(false)
+	clock.SetTime(clock.Now().Add(61 * time.Second))
+	check(true)
+
+	//a reconcile that starts and ends makes progress
+	progress.Start()()
+	check(false)
+	clock.SetTime(clock.Now().Add(30 * time.Second))
+	check(false)
+
+	//a drained queue resets the wait
+	depth = 0
+	check(false)
+	depth = 1
+	clock.SetTime(clock.Now().Add(time.Hour))
+	check(false)
+
+	//the check is disabled without a threshold
+	progress.Threshold = 0
+	clock.SetTime(clock.Now().Add(time.Hour))
+	check(false)
+}
+
+func TestQueueDepth(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	depth := prometheus.NewGaugeVec(prometheus.GaugeOpts{Subsystem: "workqueue", Name: "depth"}, []string{"name"})
+	registry.MustRegister(depth)
+	depth.WithLabelValues("namespacelabel").Set(4)
+	depth.WithLabelValues("federatednamespacelabel").Set(7)
+
+	for name, want := range map[string]int{"namespacelabel": 4, "federatednamespacelabel": 7, "missing": 0} {
+		got, err := QueueDepth(registry, name)()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("depth of %s = %d, want %d", name, got, want)
+		}
+	}
+}
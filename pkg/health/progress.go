@@ -0,0 +1,135 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/utils/clock"
+)
+
+// Progress follows the reconciles of a controller, its Check fails the liveness probe when the controller
+// is stuck: a reconcile runs longer than the threshold, or requests wait in the queue that long while no
+// reconcile starts or ends. A replica that runs no controller, e.g. not the leader, is never stuck
+type Progress struct {
+	// Threshold is the longest time without progress, zero never fails
+	Threshold time.Duration
+	// QueueDepth returns the number of requests waiting in the work queue, the queue is ignored when nil
+	QueueDepth func() (int, error)
+	// Clock is the real clock when nil
+	Clock clock.PassiveClock
+
+	mu           sync.Mutex
+	nextID       int
+	running      map[int]time.Time
+	lastProgress time.Time
+	waitingSince time.Time
+}
+
+// Start records the start of a reconcile, the returned function records its end
+func (p *Progress) Start() func() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.clock().Now()
+	if p.running == nil {
+		p.running = make(map[int]time.Time)
+	}
+	id := p.nextID
+	p.nextID++
+	p.running[id] = now
+	p.lastProgress = now
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		delete(p.running, id)
+		p.lastProgress = p.clock().Now()
+	}
+}
+
+// Check is the healthz.Checker of the progress
+func (p *Progress) Check(_ *http.Request) error {
+	if p.Threshold <= 0 {
+		return nil
+	}
+	depth := 0
+	if p.QueueDepth != nil {
+		var err error
+		if depth, err = p.QueueDepth(); err != nil {
+			return err
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.clock().Now()
+	for _, startedAt := range p.running {
+		if running := now.Sub(startedAt); running > p.Threshold {
+			return fmt.Errorf("a reconcile is running for %s", running.Round(time.Second))
+		}
+	}
+	if depth == 0 {
+		p.waitingSince = time.Time{}
+		return nil
+	}
+	//the wait is counted from the first check that saw the requests, or from the last progress after it
+	if p.waitingSince.IsZero() {
+		p.waitingSince = now
+	}
+	since := p.waitingSince
+	if p.lastProgress.After(since) {
+		since = p.lastProgress
+	}
+	if waiting := now.Sub(since); waiting > p.Threshold {
+		return fmt.Errorf("%d requests wait in the queue, no reconcile started or ended for %s", depth, waiting.Round(time.Second))
+	}
+	return nil
+}
+
+func (p *Progress) clock() clock.PassiveClock {
+	if p.Clock == nil {
+		return clock.RealClock{}
+	}
+	return p.Clock
+}
+
+// QueueDepth reads the depth of the named work queue from the workqueue_depth gauge controller-runtime
+// registers, zero while the queue does not exist
+func QueueDepth(gatherer prometheus.Gatherer, name string) func() (int, error) {
+	return func() (int, error) {
+		families, err := gatherer.Gather()
+		if err != nil {
+			return 0, err
+		}
+		for _, family := range families {
+			if family.GetName() != "workqueue_depth" {
+				continue
+			}
+			for _, metric := range family.GetMetric() {
+				for _, label := range metric.GetLabel() {
+					if label.GetName() == "name" && label.GetValue() == name {
+						return int(metric.GetGauge().GetValue()), nil
+					}
+				}
+			}
+		}
+		return 0, nil
+	}
+}
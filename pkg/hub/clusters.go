@@ -0,0 +1,218 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package hub holds the clusters a hub cluster propagates FederatedNamespaceLabels to.
+// Every cluster is registered by a Secret holding its kubeconfig, in a single namespace
+// of the hub, and is named after the Secret.
+package hub
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// ClusterSecretLabel marks the Secrets registering a cluster, its value must be "true"
+	ClusterSecretLabel = "namespacelabel.omer.io/cluster"
+	// KubeconfigKey is the key of the Secret data holding the kubeconfig of the cluster
+	KubeconfigKey = "kubeconfig"
+)
+
+const (
+	// DefaultTimeout bounds the requests of the KubeconfigClientFactory clients when no Timeout is set
+	DefaultTimeout = 30 * time.Second
+	// the first retry of a cluster whose client could not be built, doubled by every failure
+	retryBaseDelay = 5 * time.Second
+	retryMaxDelay  = 5 * time.Minute
+)
+
+// ClientFactory builds the client of a cluster from its kubeconfig, tests replace it
+// to hand out clients of fake or envtest clusters
+type ClientFactory interface {
+	NewClient(cluster string, kubeconfig []byte) (client.Client, error)
+}
+
+// KubeconfigClientFactory builds clients talking to the API server of the kubeconfig
+type KubeconfigClientFactory struct {
+	Scheme *runtime.Scheme
+	// Timeout bounds every request, so an unreachable cluster does not hold the reconcile, DefaultTimeout when zero
+	Timeout time.Duration
+}
+
+// NewClient implements ClientFactory
+func (f KubeconfigClientFactory) NewClient(_ string, kubeconfig []byte) (client.Client, error) {
+	config, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	config.Timeout = f.Timeout
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+	return client.New(config, client.Options{Scheme: f.Scheme})
+}
+
+// Cluster is a registered cluster, Err is set instead of Client when no client could be built from its Secret
+type Cluster struct {
+	Name   string
+	Client client.Client
+	Err    error
+}
+
+// Registry lists the registered clusters. The clients are kept between the calls and built
+// again when the Secret of their cluster changes. Building a client reaches the cluster, so
+// it runs without holding the registry, and a failure is kept for the same Secret until a
+// retry delay doubling with every failure has passed
+type Registry struct {
+	// Reader reads the Secrets, the manager cache would watch every Secret of the hub
+	Reader client.Reader
+	// Namespace holds the cluster Secrets
+	Namespace string
+	Factory   ClientFactory
+	// Clock times the retries of the clusters whose client could not be built, RealClock when nil
+	Clock clock.PassiveClock
+
+	mu      sync.Mutex
+	clients map[string]cachedClient
+}
+
+// cachedClient is the client built from a version of a Secret, or the error building it
+type cachedClient struct {
+	resourceVersion string
+	client          client.Client
+	err             error
+	failures        int
+	retryAt         time.Time
+}
+
+// Clusters returns the registered clusters sorted by name
+func (r *Registry) Clusters(ctx context.Context) ([]Cluster, error) {
+	var secretList corev1.SecretList
+	if err := r.Reader.List(ctx, &secretList, client.InNamespace(r.Namespace), client.MatchingLabels{ClusterSecretLabel: "true"}); err != nil {
+		return nil, err
+	}
+
+	clusters := make([]Cluster, len(secretList.Items))
+	var toBuild []int
+	now := r.clock().Now()
+	r.mu.Lock()
+	if r.clients == nil {
+		r.clients = map[string]cachedClient{}
+	}
+	registered := make(map[string]bool, len(secretList.Items))
+	for i, secret := range secretList.Items {
+		registered[secret.Name] = true
+		clusters[i] = Cluster{Name: secret.Name}
+		cached, isFound := r.clients[secret.Name]
+		switch {
+		case !isFound || cached.resourceVersion != secret.ResourceVersion:
+			toBuild = append(toBuild, i)
+		case cached.err == nil:
+			clusters[i].Client = cached.client
+		case now.Before(cached.retryAt):
+			clusters[i].Err = fmt.Errorf("%w, retrying at %s", cached.err, cached.retryAt.Format(time.RFC3339))
+		default:
+			toBuild = append(toBuild, i)
+		}
+	}
+	//forget the clients of the unregistered clusters
+	for name := range r.clients {
+		if !registered[name] {
+			delete(r.clients, name)
+		}
+	}
+	r.mu.Unlock()
+
+	//an unreachable cluster only delays the calls building its client
+	var wg sync.WaitGroup
+	built := make([]cachedClient, len(secretList.Items))
+	for _, i := range toBuild {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			built[i] = r.build(secretList.Items[i])
+		}(i)
+	}
+	wg.Wait()
+
+	r.mu.Lock()
+	for _, i := range toBuild {
+		secret := secretList.Items[i]
+		cached := built[i]
+		if cached.err != nil {
+			previous := r.clients[secret.Name]
+			if previous.err != nil && previous.resourceVersion == secret.ResourceVersion {
+				cached.failures = previous.failures
+			}
+			cached.failures++
+			cached.retryAt = now.Add(retryDelay(cached.failures))
+			clusters[i].Err = cached.err
+		}
+		clusters[i].Client = cached.client
+		if registered[secret.Name] {
+			r.clients[secret.Name] = cached
+		}
+	}
+	r.mu.Unlock()
+
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].Name < clusters[j].Name })
+	return clusters, nil
+}
+
+// the function build the client of the cluster of the secret
+func (r *Registry) build(secret corev1.Secret) cachedClient {
+	cached := cachedClient{resourceVersion: secret.ResourceVersion}
+	kubeconfig, isFound := secret.Data[KubeconfigKey]
+	if !isFound {
+		cached.err = fmt.Errorf("secret %s/%s has no %s key", secret.Namespace, secret.Name, KubeconfigKey)
+		return cached
+	}
+	clusterClient, err := r.Factory.NewClient(secret.Name, kubeconfig)
+	if err != nil {
+		cached.err = fmt.Errorf("unable to create the client of the cluster: %w", err)
+		return cached
+	}
+	cached.client = clusterClient
+	return cached
+}
+
+// the function return the delay before building again a client that failed the times given
+func retryDelay(failures int) time.Duration {
+	delay := retryBaseDelay
+	for i := 1; i < failures && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > retryMaxDelay {
+		return retryMaxDelay
+	}
+	return delay
+}
+
+func (r *Registry) clock() clock.PassiveClock {
+	if r.Clock == nil {
+		return clock.RealClock{}
+	}
+	return r.Clock
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hub
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// countingFactory hands out the hub client for every cluster and counts the clients built
+type countingFactory struct {
+	client client.Client
+	mu     sync.Mutex
+	built  map[string]int
+}
+
+func (f *countingFactory) NewClient(cluster string, _ []byte) (client.Client, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.built[cluster]++
+	if f.client == nil {
+		return nil, errors.New("cluster unreachable")
+	}
+	return f.client, nil
+}
+
+func clusterSecret(name string, labels map[string]string, data map[string][]byte) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "system", Labels: labels},
+		Data:       data,
+	}
+}
+
+func TestRegistryClusters(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	registered := map[string]string{ClusterSecretLabel: "true"}
+	kubeconfig := map[string][]byte{KubeconfigKey: []byte("kubeconfig")}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		clusterSecret("b", registered, kubeconfig),
+		clusterSecret("a", registered, kubeconfig),
+		clusterSecret("no-kubeconfig", registered, map[string][]byte{"config": []byte("kubeconfig")}),
+		clusterSecret("not-registered", nil, kubeconfig),
+	).Build()
+	factory := &countingFactory{client: c, built: map[string]int{}}
+	registry := &Registry{Reader: c, Namespace: "system", Factory: factory}
+
+	clusters, err := registry.Clusters(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, cluster := range clusters {
+		names = append(names, cluster.Name)
+	}
+	if len(names) != 3 || names[0] != "a" || names[1] != "b" || names[2] != "no-kubeconfig" {
+		t.Fatalf("expected the clusters a, b and no-kubeconfig, got %v", names)
+	}
+	if clusters[0].Client == nil || clusters[0].Err != nil {
+		t.Errorf("expected a client for cluster a, got error %v", clusters[0].Err)
+	}
+	if clusters[2].Client != nil || clusters[2].Err == nil {
+		t.Error("expected an error for the cluster without kubeconfig")
+	}
+
+	//the clients are reused until the secret changes
+	if _, err := registry.Clusters(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if factory.built["a"] != 1 || factory.built["b"] != 1 {
+		t.Errorf("expected the clients to be built once, got %v", factory.built)
+	}
+	var secret corev1.Secret
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "system", Name: "a"}, &secret); err != nil {
+		t.Fatal(err)
+	}
+	secret.Data[KubeconfigKey] = []byte("rotated")
+	if err := c.Update(ctx, &secret); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := registry.Clusters(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if factory.built["a"] != 2 || factory.built["b"] != 1 {
+		t.Errorf("expected only the client of the changed secret to be built again, got %v", factory.built)
+	}
+
+	//the client of an unregistered cluster is forgotten
+	if err := c.Delete(ctx, &secret); err != nil {
+		t.Fatal(err)
+	}
+	if clusters, err = registry.Clusters(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, isFound := registry.clients["a"]; len(clusters) != 2 || isFound {
+		t.Errorf("expected the cluster a to be forgotten, got %d clusters and %d clients", len(clusters), len(registry.clients))
+	}
+}
+
+func TestRegistryRetriesFailedClusters(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		clusterSecret("a", map[string]string{ClusterSecretLabel: "true"}, map[string][]byte{KubeconfigKey: []byte("kubeconfig")}),
+	).Build()
+	factory := &countingFactory{built: map[string]int{}}
+	clock := clocktesting.NewFakePassiveClock(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	registry := &Registry{Reader: c, Namespace: "system", Factory: factory, Clock: clock}
+
+	clusters := func() []Cluster {
+		clusters, err := registry.Clusters(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return clusters
+	}
+	if clusters := clusters(); len(clusters) != 1 || clusters[0].Err == nil {
+		t.Fatalf("expected the cluster a to fail, got %+v", clusters)
+	}
+
+	//the failure is kept until the retry delay passed
+	if clusters := clusters(); clusters[0].Err == nil || factory.built["a"] != 1 {
+		t.Errorf("expected the failure to be kept, got %v and %d clients built", clusters[0].Err, factory.built["a"])
+	}
+	clock.SetTime(clock.Now().Add(retryBaseDelay))
+	clusters()
+	if factory.built["a"] != 2 {
+		t.Errorf("expected the client to be built again after the delay, got %d clients built", factory.built["a"])
+	}
+
+	//the delay doubles with every failure
+	clock.SetTime(clock.Now().Add(retryBaseDelay))
+	clusters()
+	if factory.built["a"] != 2 {
+		t.Errorf("expected the second failure to double the delay, got %d clients built", factory.built["a"])
+	}
+
+	//a changed secret is tried at once
+	factory.client = c
+	var secret corev1.Secret
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "system", Name: "a"}, &secret); err != nil {
+		t.Fatal(err)
+	}
+	secret.Data[KubeconfigKey] = []byte("fixed")
+	if err := c.Update(ctx, &secret); err != nil {
+		t.Fatal(err)
+	}
+	if clusters := clusters(); clusters[0].Err != nil || clusters[0].Client == nil || factory.built["a"] != 3 {
+		t.Errorf("expected the changed secret to be built at once, got %v and %d clients built", clusters[0].Err, factory.built["a"])
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for failures, expected := range map[int]time.Duration{1: retryBaseDelay, 2: 2 * retryBaseDelay, 3: 4 * retryBaseDelay, 20: retryMaxDelay} {
+		if delay := retryDelay(failures); delay != expected {
+			t.Errorf("expected a delay of %s after %d failures, got %s", expected, failures, delay)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package labelsync computes how the labels of a namespace change to match the
+// NamespaceLabels targeting it. It has no side effects, the controller reads the
+// cluster state, asks for a Plan and writes the result.
+package labelsync
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Reason explains why a label of a Source was not synced
+type Reason string
+
+const (
+	// ReasonProtected means the label key matches the protected labels of the Policy
+	ReasonProtected Reason = "Protected"
+	// ReasonExistsInNamespace means the label is already in the namespace and no Source manages it
+	ReasonExistsInNamespace Reason = "ExistsInNamespace"
+	// ReasonClaimedByOther means an older Source already syncs the label
+	ReasonClaimedByOther Reason = "ClaimedByOther"
+	// ReasonPendingApproval means the label key requires approval and the Source is not approved
+	ReasonPendingApproval Reason = "PendingApproval"
+	// ReasonInvalid means the Validate function of the Policy rejected the label
+	ReasonInvalid Reason = "Invalid"
+	// ReasonSuspended means the Source is suspended and the label differs from the namespace
+	ReasonSuspended Reason = "Suspended"
+)
+
+// Policy holds the cluster wide rules applied to every Source
+type Policy struct {
+	// ProtectedLabels are label keys that are never written
+	ProtectedLabels []string
+	// ProtectedLabelPrefixes are key prefixes that are never written
+	ProtectedLabelPrefixes []string
+	// Overwrite takes over labels that already exist in the namespace instead of skipping them
+	Overwrite bool
+	// ApprovalRequiredLabels are label keys only an approved Source may set, change or remove. A deleting
+	// Source removes them without approval
+	ApprovalRequiredLabels []string
+	// ApprovalRequiredLabelPrefixes are key prefixes only an approved Source may set, change or remove
+	ApprovalRequiredLabelPrefixes []string
+	// Validate rejects the labels the namespace must not get, every label is valid when nil
+	Validate func(key, value string) error
+}
+
+// IsProtected returns true if the label key must not be written
+func (p Policy) IsProtected(key string) bool {
+	for _, protected := range p.ProtectedLabels {
+		if key == protected {
+			return true
+		}
+	}
+	for _, prefix := range p.ProtectedLabelPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// RequiresApproval returns true if setting, changing or removing the label key needs an approved Source
+func (p Policy) RequiresApproval(key string) bool {
+	for _, approvalRequired := range p.ApprovalRequiredLabels {
+		if key == approvalRequired {
+			return true
+		}
+	}
+	for _, prefix := range p.ApprovalRequiredLabelPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Source is one NamespaceLabel of the namespace
+type Source struct {
+	// Name identifies the Source, it is recorded as the owner of the labels it syncs
+	Name string
+	// Depth orders the Sources before their creation time, the lowest one wins a label claimed by
+	// several. The Sources of the namespace itself are 0, the ones inherited from its ancestors
+	// are their distance to the namespace
+	Depth int
+	// CreationTimestamp orders the Sources, the oldest one wins a label claimed by several
+	CreationTimestamp time.Time
+	// Labels are the desired labels of the Source
+	Labels map[string]string
+	// Deleting sources contribute no labels, the labels they managed are removed
+	Deleting bool
+	// Approved sources may set, change and remove the labels that require approval in the Policy
+	Approved bool
+	// Suspended sources change nothing in the namespace: the labels they manage keep their value and
+	// are not removed, even when the Source is deleting. Their other labels are skipped as Suspended
+	Suspended bool
+}
+
+// Skip is a label of a Source that is not synced
+type Skip struct {
+	Value  string
+	Reason Reason
+	// Owner is the Source syncing the label instead, set for ReasonClaimedByOther
+	Owner string
+	// Message details the reason, set for ReasonInvalid and the labels put on hold
+	Message string
+	// Removal is set for a label the Source does not have anymore and still manages, Value is the one
+	// of the namespace. Set for ReasonPendingApproval
+	Removal bool
+}
+
+// Result is the outcome of the Plan for one Source
+type Result struct {
+	// Synced are the labels of the Source written to the namespace
+	Synced map[string]string
+	// Skipped are the labels of the Source that are not written, and why
+	Skipped map[string]Skip
+}
+
+// Plan is the change to apply to the namespace labels
+type Plan struct {
+	// Apply are the labels to set, only the ones missing or with a different value
+	Apply map[string]string
+	// Remove are the managed label keys to delete, sorted
+	Remove []string
+	// Managed maps every label managed after the plan to the Source owning it
+	Managed map[string]string
+	// Results holds the outcome of every Source that is not deleting, by name
+	Results map[string]Result
+}
+
+// NewPlan computes the plan that brings the current namespace labels to the state desired
+// by the sources. previous maps the labels managed before this plan to their owner.
+func NewPlan(sources []Source, current map[string]string, previous map[string]string, policy Policy) Plan {
+	plan := Plan{
+		Apply:   make(map[string]string),
+		Managed: make(map[string]string),
+		Results: make(map[string]Result),
+	}
+
+	sorted := make([]Source, len(sources))
+	copy(sorted, sources)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Depth != sorted[j].Depth {
+			return sorted[i].Depth < sorted[j].Depth
+		}
+		if !sorted[i].CreationTimestamp.Equal(sorted[j].CreationTimestamp) {
+			return sorted[i].CreationTimestamp.Before(sorted[j].CreationTimestamp)
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	//stage 1: the label key is protected - result: skipped, Protected
+	//stage 2: the label is already synced by an older source - result: skipped, ClaimedByOther
+	//stage 3: the label is in the namespace and not managed by any source - result: skipped, ExistsInNamespace,
+	//         unless the policy overwrites existing labels
+	//stage 4: the label is rejected by the validation of the policy - result: skipped, Invalid
+	//stage 5: the label key requires approval, the source is not approved and does not already sync this
+	//         value - result: skipped, PendingApproval
+	//stage 6: the source is suspended and does not already sync this value - result: skipped, Suspended
+	//stage 7: otherwise - result: synced, applied when missing or different in the namespace
+	//a label skipped by stage 4, 5 or 6 that the source manages keeps its value in the namespace
+
+	desired := make(map[string]string)
+	for _, source := range sorted {
+		if source.Deleting {
+			continue
+		}
+		result := Result{
+			Synced:  make(map[string]string),
+			Skipped: make(map[string]Skip),
+		}
+		for _, key := range sortedKeys(source.Labels) {
+			value := source.Labels[key]
+			_, isInNamespace := current[key]
+			_, isManaged := previous[key]
+			owner, isClaimed := plan.Managed[key]
+			//the value the source synced before stays, until the new one can be synced
+			keep := func() {
+				if previous[key] == source.Name && isInNamespace {
+					desired[key] = current[key]
+					plan.Managed[key] = source.Name
+				}
+			}
+			switch {
+			case policy.IsProtected(key):
+				result.Skipped[key] = Skip{Value: value, Reason: ReasonProtected}
+			case isClaimed:
+				result.Skipped[key] = Skip{Value: value, Reason: ReasonClaimedByOther, Owner: owner}
+			case isInNamespace && !isManaged && !policy.Overwrite:
+				result.Skipped[key] = Skip{Value: value, Reason: ReasonExistsInNamespace}
+			case policy.Validate != nil && policy.Validate(key, value) != nil:
+				result.Skipped[key] = Skip{Value: value, Reason: ReasonInvalid, Message: policy.Validate(key, value).Error()}
+				keep()
+			case policy.RequiresApproval(key) && !source.Approved && (previous[key] != source.Name || current[key] != value):
+				result.Skipped[key] = Skip{Value: value, Reason: ReasonPendingApproval}
+				keep()
+			case source.Suspended && (previous[key] != source.Name || current[key] != value):
+				result.Skipped[key] = Skip{Value: value, Reason: ReasonSuspended}
+				//a managed label removed by hand stays managed too, the managed labels are not written either
+				if previous[key] == source.Name {
+					keep()
+					plan.Managed[key] = source.Name
+				}
+			default:
+				result.Synced[key] = value
+				desired[key] = value
+				plan.Managed[key] = source.Name
+			}
+		}
+		plan.Results[source.Name] = result
+	}
+
+	//stage 8: a managed label no source syncs anymore, and its owner still exists - result: removed, unless the
+	//         key requires approval and the owner is not approved nor deleting - result: kept, skipped as
+	//         PendingApproval with the value of the namespace
+	//stage 9: a managed label whose owner does not exist anymore or is suspended - result: kept as managed,
+	//         orphans are left to the orphaned labels sweeper
+	//stage 10: a managed label that became protected - result: left in the namespace, not managed anymore
+
+	existingSources := make(map[string]Source)
+	for _, source := range sources {
+		existingSources[source.Name] = source
+	}
+	for _, key := range sortedKeys(previous) {
+		if _, isDesired := desired[key]; isDesired {
+			continue
+		}
+		owner := previous[key]
+		source, isExist := existingSources[owner]
+		if !isExist || source.Suspended {
+			plan.Managed[key] = owner
+			continue
+		}
+		if policy.IsProtected(key) {
+			continue
+		}
+		value, isInNamespace := current[key]
+		if !isInNamespace {
+			continue
+		}
+		if policy.RequiresApproval(key) && !source.Approved && !source.Deleting {
+			plan.Managed[key] = owner
+			plan.Results[owner].Skipped[key] = Skip{Value: value, Reason: ReasonPendingApproval, Removal: true}
+			continue
+		}
+		plan.Remove = append(plan.Remove, key)
+	}
+
+	for key, value := range desired {
+		if currentValue, isInNamespace := current[key]; !isInNamespace || currentValue != value {
+			plan.Apply[key] = value
+		}
+	}
+
+	return plan
+}
+
+// Hold takes back the change the plan applies to the label key, the namespace keeps its current value.
+// The Source syncing the label gets it skipped with the reason, the checks run on the planned labels
+// use it to stop a change they find unsafe. current and previous are the ones of NewPlan
+func (p Plan) Hold(key string, reason Reason, message string, current, previous map[string]string) {
+	value, isApplied := p.Apply[key]
+	if !isApplied {
+		return
+	}
+	delete(p.Apply, key)
+	owner := p.Managed[key]
+	if result, isExist := p.Results[owner]; isExist {
+		delete(result.Synced, key)
+		result.Skipped[key] = Skip{Value: value, Reason: reason, Message: message}
+	}
+
+	_, isInNamespace := current[key]
+	if previousOwner, isManaged := previous[key]; isManaged && isInNamespace {
+		p.Managed[key] = previousOwner
+	} else {
+		delete(p.Managed, key)
+	}
+}
+
+// Labels returns the namespace labels after the plan is applied to current, current is not modified
+func (p Plan) Labels(current map[string]string) map[string]string {
+	labels := make(map[string]string, len(current)+len(p.Apply))
+	for key, value := range current {
+		labels[key] = value
+	}
+	for _, key := range p.Remove {
+		delete(labels, key)
+	}
+	for key, value := range p.Apply {
+		labels[key] = value
+	}
+	return labels
+}
+
+// IsNoop returns true if the plan changes neither the labels nor the managed labels
+func (p Plan) IsNoop(previous map[string]string) bool {
+	if len(p.Apply) != 0 || len(p.Remove) != 0 || len(p.Managed) != len(previous) {
+		return false
+	}
+	for key, owner := range p.Managed {
+		if previousOwner, isExist := previous[key]; !isExist || previousOwner != owner {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedKeys(labels map[string]string) []string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package labelsync
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fuzzInput reads the choices of a scenario from the fuzzer bytes, zero once they run out
+type fuzzInput struct {
+	data []byte
+}
+
+func (in *fuzzInput) next(n int) int {
+	if len(in.data) == 0 {
+		return 0
+	}
+	b := in.data[0]
+	in.data = in.data[1:]
+	return int(b) % n
+}
+
+func (in *fuzzInput) labels() map[string]string {
+	labels := make(map[string]string)
+	for _, key := range scenarioKeys {
+		if choice := in.next(len(scenarioValues) + 1); choice > 0 {
+			labels[key] = scenarioValues[choice-1]
+		}
+	}
+	return labels
+}
+
+// decodeScenario builds sources over a small set of keys from the fuzzer bytes.
+// the namespace starts with unmanaged labels only, the state before the operator adopts it
+func decodeScenario(data []byte, overwrite bool) (sources []Source, original map[string]string, policy Policy) {
+	in := &fuzzInput{data: data}
+	original = in.labels()
+	for _, name := range scenarioNames {
+		if in.next(3) == 0 {
+			continue
+		}
+		sources = append(sources, Source{
+			Name:              name,
+			CreationTimestamp: older.Add(time.Duration(in.next(3)) * time.Minute),
+			Labels:            in.labels(),
+		})
+	}
+	policy = Policy{ProtectedLabelPrefixes: []string{"kubernetes.io/"}, Overwrite: overwrite}
+	return sources, original, policy
+}
+
+// converge runs plans like the controller does, until the namespace stops changing
+func converge(t *testing.T, sources []Source, labels map[string]string, managed map[string]string, policy Policy) (map[string]string, map[string]string) {
+	for i := 0; i < 3; i++ {
+		plan := NewPlan(sources, labels, managed, policy)
+		if plan.IsNoop(managed) {
+			return labels, managed
+		}
+		labels, managed = plan.Labels(labels), plan.Managed
+	}
+	t.Fatalf("plans did not converge, labels %v, managed %v", labels, managed)
+	return nil, nil
+}
+
+func FuzzPlanInvariants(f *testing.F) {
+	f.Add([]byte{}, false)
+	f.Add([]byte{1, 2, 0, 1, 2, 1, 0, 1, 1, 2, 1, 0, 2, 2, 1, 2, 1, 1, 0, 1, 2, 2}, false)
+	f.Add([]byte{2, 2, 2, 2, 2, 1, 0, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1}, true)
+	f.Add([]byte{0, 1, 0, 1, 0, 2, 2, 1, 0, 1, 0, 1, 1, 1, 2, 0, 2, 0, 2, 1, 1}, true)
+
+	f.Fuzz(func(t *testing.T, data []byte, overwrite bool) {
+		sources, original, policy := decodeScenario(data, overwrite)
+		plan := NewPlan(sources, original, nil, policy)
+
+		//never writes protected keys
+		for key := range plan.Apply {
+			if policy.IsProtected(key) {
+				t.Fatalf("protected label %s is applied", key)
+			}
+		}
+
+		//never removes unmanaged labels, and only overwrites them when the policy allows it
+		if len(plan.Remove) != 0 {
+			t.Fatalf("labels %v are removed from a namespace without managed labels", plan.Remove)
+		}
+		for key := range plan.Apply {
+			if _, isExist := original[key]; isExist && !policy.Overwrite {
+				t.Fatalf("unmanaged label %s is overwritten", key)
+			}
+		}
+
+		//idempotent on the second run
+		labels, managed := plan.Labels(original), plan.Managed
+		second := NewPlan(sources, labels, managed, policy)
+		if !second.IsNoop(managed) {
+			t.Fatalf("second plan is not a noop: apply %v, remove %v", second.Apply, second.Remove)
+		}
+		if !reflect.DeepEqual(second.Results, plan.Results) {
+			t.Fatalf("second plan changed the results: %v, was %v", second.Results, plan.Results)
+		}
+
+		//the order the sources are created and reconciled in does not change the namespace labels
+		shuffled := make([]Source, len(sources))
+		copy(shuffled, sources)
+		rand.New(rand.NewSource(int64(len(data)))).Shuffle(len(shuffled), func(i, j int) {
+			shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+		})
+		incrementalLabels, incrementalManaged := original, map[string]string(nil)
+		for i := range shuffled {
+			incrementalLabels, incrementalManaged = converge(t, shuffled[:i+1], incrementalLabels, incrementalManaged, policy)
+		}
+		if !reflect.DeepEqual(incrementalLabels, labels) {
+			t.Fatalf("creating the sources one by one gave %v, all together gave %v", incrementalLabels, labels)
+		}
+
+		//deleting every source restores the labels from before the adoption,
+		//labels taken over with the overwrite policy are removed with their owner
+		deleting := make([]Source, len(sources))
+		for i, source := range sources {
+			source.Deleting = true
+			deleting[i] = source
+		}
+		final, finalManaged := converge(t, deleting, labels, managed, policy)
+		if len(finalManaged) != 0 {
+			t.Fatalf("labels %v are still managed after every source is deleted", finalManaged)
+		}
+		if !policy.Overwrite && !reflect.DeepEqual(final, original) {
+			t.Fatalf("deleting the sources left %v, the namespace had %v", final, original)
+		}
+		for key, value := range final {
+			if original[key] != value {
+				t.Fatalf("label %s=%s is left after every source is deleted", key, value)
+			}
+		}
+	})
+}
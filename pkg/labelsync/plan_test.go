@@ -0,0 +1,607 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package labelsync
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+var (
+	older = time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer = older.Add(time.Hour)
+)
+
+func TestNewPlan(t *testing.T) {
+	tests := []struct {
+		name     string
+		sources  []Source
+		current  map[string]string
+		previous map[string]string
+		policy   Policy
+		want     Plan
+	}{
+		{
+			name:    "stage 1: protected label is skipped",
+			sources: []Source{{Name: "a", Labels: map[string]string{"kubernetes.io/x": "1", "k": "v"}}},
+			policy:  Policy{ProtectedLabelPrefixes: []string{"kubernetes.io/"}},
+			want: Plan{
+				Apply:   map[string]string{"k": "v"},
+				Managed: map[string]string{"k": "a"},
+				Results: map[string]Result{"a": {
+					Synced:  map[string]string{"k": "v"},
+					Skipped: map[string]Skip{"kubernetes.io/x": {Value: "1", Reason: ReasonProtected}},
+				}},
+			},
+		},
+		{
+			name: "stage 2: label claimed by an older source is skipped",
+			sources: []Source{
+				{Name: "b", CreationTimestamp: newer, Labels: map[string]string{"k": "b"}},
+				{Name: "a", CreationTimestamp: older, Labels: map[string]string{"k": "a"}},
+			},
+			want: Plan{
+				Apply:   map[string]string{"k": "a"},
+				Managed: map[string]string{"k": "a"},
+				Results: map[string]Result{
+					"a": {Synced: map[string]string{"k": "a"}, Skipped: map[string]Skip{}},
+					"b": {Synced: map[string]string{}, Skipped: map[string]Skip{"k": {Value: "b", Reason: ReasonClaimedByOther, Owner: "a"}}},
+				},
+			},
+		},
+		{
+			name: "stage 2: label claimed by a source with a lower depth is skipped",
+			sources: []Source{
+				{Name: "parent/a", Depth: 1, CreationTimestamp: older, Labels: map[string]string{"k": "parent"}},
+				{Name: "b", CreationTimestamp: newer, Labels: map[string]string{"k": "b"}},
+			},
+			want: Plan{
+				Apply:   map[string]string{"k": "b"},
+				Managed: map[string]string{"k": "b"},
+				Results: map[string]Result{
+					"b":        {Synced: map[string]string{"k": "b"}, Skipped: map[string]Skip{}},
+					"parent/a": {Synced: map[string]string{}, Skipped: map[string]Skip{"k": {Value: "parent", Reason: ReasonClaimedByOther, Owner: "b"}}},
+				},
+			},
+		},
+		{
+			name: "stage 2: sources created together are ordered by name",
+			sources: []Source{
+				{Name: "b", Labels: map[string]string{"k": "b"}},
+				{Name: "a", Labels: map[string]string{"k": "a"}},
+			},
+			want: Plan{
+				Apply:   map[string]string{"k": "a"},
+				Managed: map[string]string{"k": "a"},
+				Results: map[string]Result{
+					"a": {Synced: map[string]string{"k": "a"}, Skipped: map[string]Skip{}},
+					"b": {Synced: map[string]string{}, Skipped: map[string]Skip{"k": {Value: "b", Reason: ReasonClaimedByOther, Owner: "a"}}},
+				},
+			},
+		},
+		{
+			name:    "stage 3: unmanaged label in the namespace is skipped",
+			sources: []Source{{Name: "a", Labels: map[string]string{"k": "v"}}},
+			current: map[string]string{"k": "user"},
+			want: Plan{
+				Apply:   map[string]string{},
+				Managed: map[string]string{},
+				Results: map[string]Result{"a": {
+					Synced:  map[string]string{},
+					Skipped: map[string]Skip{"k": {Value: "v", Reason: ReasonExistsInNamespace}},
+				}},
+			},
+		},
+		{
+			name:    "stage 3: unmanaged label in the namespace is taken over with overwrite",
+			sources: []Source{{Name: "a", Labels: map[string]string{"k": "v"}}},
+			current: map[string]string{"k": "user"},
+			policy:  Policy{Overwrite: true},
+			want: Plan{
+				Apply:   map[string]string{"k": "v"},
+				Managed: map[string]string{"k": "a"},
+				Results: map[string]Result{"a": {Synced: map[string]string{"k": "v"}, Skipped: map[string]Skip{}}},
+			},
+		},
+		{
+			name:     "stage 4: invalid label is skipped and keeps its synced value",
+			sources:  []Source{{Name: "a", Labels: map[string]string{"level": "bad", "new": "bad", "k": "v"}}},
+			current:  map[string]string{"level": "good"},
+			previous: map[string]string{"level": "a"},
+			policy: Policy{Validate: func(key, value string) error {
+				if value == "bad" {
+					return errors.New("bad value")
+				}
+				return nil
+			}},
+			want: Plan{
+				Apply:   map[string]string{"k": "v"},
+				Managed: map[string]string{"level": "a", "k": "a"},
+				Results: map[string]Result{"a": {
+					Synced: map[string]string{"k": "v"},
+					Skipped: map[string]Skip{
+						"level": {Value: "bad", Reason: ReasonInvalid, Message: "bad value"},
+						"new":   {Value: "bad", Reason: ReasonInvalid, Message: "bad value"},
+					},
+				}},
+			},
+		},
+		{
+			name:    "stage 5: new label requiring approval is pending",
+			sources: []Source{{Name: "a", Labels: map[string]string{"secure": "v", "k": "v"}}},
+			policy:  Policy{ApprovalRequiredLabels: []string{"secure"}},
+			want: Plan{
+				Apply:   map[string]string{"k": "v"},
+				Managed: map[string]string{"k": "a"},
+				Results: map[string]Result{"a": {
+					Synced:  map[string]string{"k": "v"},
+					Skipped: map[string]Skip{"secure": {Value: "v", Reason: ReasonPendingApproval}},
+				}},
+			},
+		},
+		{
+			name:     "stage 5: changed label requiring approval keeps its synced value",
+			sources:  []Source{{Name: "a", Labels: map[string]string{"secure/k": "new"}}},
+			current:  map[string]string{"secure/k": "old"},
+			previous: map[string]string{"secure/k": "a"},
+			policy:   Policy{ApprovalRequiredLabelPrefixes: []string{"secure/"}},
+			want: Plan{
+				Apply:   map[string]string{},
+				Managed: map[string]string{"secure/k": "a"},
+				Results: map[string]Result{"a": {
+					Synced:  map[string]string{},
+					Skipped: map[string]Skip{"secure/k": {Value: "new", Reason: ReasonPendingApproval}},
+				}},
+			},
+		},
+		{
+			name:     "stage 5: approved source changes a label requiring approval",
+			sources:  []Source{{Name: "a", Approved: true, Labels: map[string]string{"secure": "new"}}},
+			current:  map[string]string{"secure": "old"},
+			previous: map[string]string{"secure": "a"},
+			policy:   Policy{ApprovalRequiredLabels: []string{"secure"}},
+			want: Plan{
+				Apply:   map[string]string{"secure": "new"},
+				Managed: map[string]string{"secure": "a"},
+				Results: map[string]Result{"a": {Synced: map[string]string{"secure": "new"}, Skipped: map[string]Skip{}}},
+			},
+		},
+		{
+			name:     "stage 5: label requiring approval already synced with the value stays synced",
+			sources:  []Source{{Name: "a", Labels: map[string]string{"secure": "v"}}},
+			current:  map[string]string{"secure": "v"},
+			previous: map[string]string{"secure": "a"},
+			policy:   Policy{ApprovalRequiredLabels: []string{"secure"}},
+			want: Plan{
+				Apply:   map[string]string{},
+				Managed: map[string]string{"secure": "a"},
+				Results: map[string]Result{"a": {Synced: map[string]string{"secure": "v"}, Skipped: map[string]Skip{}}},
+			},
+		},
+		{
+			name:     "stage 6: suspended source keeps its drifted and removed labels",
+			sources:  []Source{{Name: "a", Suspended: true, Labels: map[string]string{"k": "v", "gone": "v", "new": "v", "same": "v"}}},
+			current:  map[string]string{"k": "drift", "same": "v"},
+			previous: map[string]string{"k": "a", "gone": "a", "same": "a"},
+			want: Plan{
+				Apply:   map[string]string{},
+				Managed: map[string]string{"k": "a", "gone": "a", "same": "a"},
+				Results: map[string]Result{"a": {
+					Synced: map[string]string{"same": "v"},
+					Skipped: map[string]Skip{
+						"k":    {Value: "v", Reason: ReasonSuspended},
+						"gone": {Value: "v", Reason: ReasonSuspended},
+						"new":  {Value: "v", Reason: ReasonSuspended},
+					},
+				}},
+			},
+		},
+		{
+			name:     "stage 6: unsuspended source syncs its labels",
+			sources:  []Source{{Name: "a", Labels: map[string]string{"k": "v", "new": "v"}}},
+			current:  map[string]string{"k": "drift"},
+			previous: map[string]string{"k": "a"},
+			want: Plan{
+				Apply:   map[string]string{"k": "v", "new": "v"},
+				Managed: map[string]string{"k": "a", "new": "a"},
+				Results: map[string]Result{"a": {Synced: map[string]string{"k": "v", "new": "v"}, Skipped: map[string]Skip{}}},
+			},
+		},
+		{
+			name:     "stage 7: managed label with a drifted value is applied again",
+			sources:  []Source{{Name: "a", Labels: map[string]string{"k": "v"}}},
+			current:  map[string]string{"k": "drift"},
+			previous: map[string]string{"k": "a"},
+			want: Plan{
+				Apply:   map[string]string{"k": "v"},
+				Managed: map[string]string{"k": "a"},
+				Results: map[string]Result{"a": {Synced: map[string]string{"k": "v"}, Skipped: map[string]Skip{}}},
+			},
+		},
+		{
+			name:     "stage 7: synced label is not applied again",
+			sources:  []Source{{Name: "a", Labels: map[string]string{"k": "v"}}},
+			current:  map[string]string{"k": "v"},
+			previous: map[string]string{"k": "a"},
+			want: Plan{
+				Apply:   map[string]string{},
+				Managed: map[string]string{"k": "a"},
+				Results: map[string]Result{"a": {Synced: map[string]string{"k": "v"}, Skipped: map[string]Skip{}}},
+			},
+		},
+		{
+			name:     "stage 7: label managed by another source moves to the remaining claimer",
+			sources:  []Source{{Name: "b", Labels: map[string]string{"k": "b"}}, {Name: "a", Deleting: true, Labels: map[string]string{"k": "a"}}},
+			current:  map[string]string{"k": "a"},
+			previous: map[string]string{"k": "a"},
+			want: Plan{
+				Apply:   map[string]string{"k": "b"},
+				Managed: map[string]string{"k": "b"},
+				Results: map[string]Result{"b": {Synced: map[string]string{"k": "b"}, Skipped: map[string]Skip{}}},
+			},
+		},
+		{
+			name:     "stage 8: label removed from the spec is removed from the namespace",
+			sources:  []Source{{Name: "a", Labels: map[string]string{}}},
+			current:  map[string]string{"k": "v", "user": "u"},
+			previous: map[string]string{"k": "a"},
+			want: Plan{
+				Apply:   map[string]string{},
+				Remove:  []string{"k"},
+				Managed: map[string]string{},
+				Results: map[string]Result{"a": {Synced: map[string]string{}, Skipped: map[string]Skip{}}},
+			},
+		},
+		{
+			name:     "stage 8: labels of a deleting source are removed",
+			sources:  []Source{{Name: "a", Deleting: true, Labels: map[string]string{"k": "v"}}},
+			current:  map[string]string{"k": "v"},
+			previous: map[string]string{"k": "a"},
+			want: Plan{
+				Apply:   map[string]string{},
+				Remove:  []string{"k"},
+				Managed: map[string]string{},
+				Results: map[string]Result{},
+			},
+		},
+		{
+			name:     "stage 8: managed label already gone from the namespace is forgotten",
+			sources:  []Source{{Name: "a"}},
+			previous: map[string]string{"k": "a"},
+			want: Plan{
+				Apply:   map[string]string{},
+				Managed: map[string]string{},
+				Results: map[string]Result{"a": {Synced: map[string]string{}, Skipped: map[string]Skip{}}},
+			},
+		},
+		{
+			name:     "stage 8: removed label requiring approval is kept until approved",
+			sources:  []Source{{Name: "a", Labels: map[string]string{}}},
+			current:  map[string]string{"secure": "v"},
+			previous: map[string]string{"secure": "a"},
+			policy:   Policy{ApprovalRequiredLabels: []string{"secure"}},
+			want: Plan{
+				Apply:   map[string]string{},
+				Managed: map[string]string{"secure": "a"},
+				Results: map[string]Result{"a": {
+					Synced:  map[string]string{},
+					Skipped: map[string]Skip{"secure": {Value: "v", Reason: ReasonPendingApproval, Removal: true}},
+				}},
+			},
+		},
+		{
+			name:     "stage 8: approved source removes a label requiring approval",
+			sources:  []Source{{Name: "a", Approved: true, Labels: map[string]string{}}},
+			current:  map[string]string{"secure": "v"},
+			previous: map[string]string{"secure": "a"},
+			policy:   Policy{ApprovalRequiredLabels: []string{"secure"}},
+			want: Plan{
+				Apply:   map[string]string{},
+				Remove:  []string{"secure"},
+				Managed: map[string]string{},
+				Results: map[string]Result{"a": {Synced: map[string]string{}, Skipped: map[string]Skip{}}},
+			},
+		},
+		{
+			name:     "stage 8: deleting source removes a label requiring approval",
+			sources:  []Source{{Name: "a", Deleting: true, Labels: map[string]string{"secure": "v"}}},
+			current:  map[string]string{"secure": "v"},
+			previous: map[string]string{"secure": "a"},
+			policy:   Policy{ApprovalRequiredLabels: []string{"secure"}},
+			want: Plan{
+				Apply:   map[string]string{},
+				Remove:  []string{"secure"},
+				Managed: map[string]string{},
+				Results: map[string]Result{},
+			},
+		},
+		{
+			name:     "stage 9: label of a source that does not exist is kept for the sweeper",
+			current:  map[string]string{"k": "v"},
+			previous: map[string]string{"k": "gone"},
+			want: Plan{
+				Apply:   map[string]string{},
+				Managed: map[string]string{"k": "gone"},
+				Results: map[string]Result{},
+			},
+		},
+		{
+			name:     "stage 9: orphaned label can be claimed by a source",
+			sources:  []Source{{Name: "a", Labels: map[string]string{"k": "a"}}},
+			current:  map[string]string{"k": "v"},
+			previous: map[string]string{"k": "gone"},
+			want: Plan{
+				Apply:   map[string]string{"k": "a"},
+				Managed: map[string]string{"k": "a"},
+				Results: map[string]Result{"a": {Synced: map[string]string{"k": "a"}, Skipped: map[string]Skip{}}},
+			},
+		},
+		{
+			name:     "stage 9: labels of a suspended source are not removed",
+			sources:  []Source{{Name: "a", Suspended: true, Labels: map[string]string{}}, {Name: "b", Deleting: true, Suspended: true}},
+			current:  map[string]string{"k": "v", "l": "v"},
+			previous: map[string]string{"k": "a", "l": "b"},
+			want: Plan{
+				Apply:   map[string]string{},
+				Managed: map[string]string{"k": "a", "l": "b"},
+				Results: map[string]Result{"a": {Synced: map[string]string{}, Skipped: map[string]Skip{}}},
+			},
+		},
+		{
+			name:     "stage 10: managed label that became protected is left alone",
+			sources:  []Source{{Name: "a", Labels: map[string]string{"k": "v"}}},
+			current:  map[string]string{"k": "v"},
+			previous: map[string]string{"k": "a"},
+			policy:   Policy{ProtectedLabels: []string{"k"}},
+			want: Plan{
+				Apply:   map[string]string{},
+				Managed: map[string]string{},
+				Results: map[string]Result{"a": {
+					Synced:  map[string]string{},
+					Skipped: map[string]Skip{"k": {Value: "v", Reason: ReasonProtected}},
+				}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewPlan(tt.sources, tt.current, tt.previous, tt.policy)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewPlan() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanHold(t *testing.T) {
+	sources := []Source{
+		{Name: "a", CreationTimestamp: older, Labels: map[string]string{"level": "strict", "new": "v", "k": "v"}},
+		{Name: "b", CreationTimestamp: newer, Labels: map[string]string{"moved": "b"}},
+	}
+	current := map[string]string{"level": "loose", "moved": "a"}
+	previous := map[string]string{"level": "a", "moved": "a"}
+	plan := NewPlan(sources, current, previous, Policy{})
+	for _, key := range []string{"level", "new", "moved"} {
+		plan.Hold(key, "Unsafe", "would break", current, previous)
+	}
+	//a label the plan does not change has nothing to hold
+	plan.Hold("unknown", "Unsafe", "", current, previous)
+
+	want := Plan{
+		Apply: map[string]string{"k": "v"},
+		//the label keeps its owner until the change is not held anymore
+		Managed: map[string]string{"level": "a", "moved": "a", "k": "a"},
+		Results: map[string]Result{
+			"a": {Synced: map[string]string{"k": "v"}, Skipped: map[string]Skip{
+				"level": {Value: "strict", Reason: "Unsafe", Message: "would break"},
+				"new":   {Value: "v", Reason: "Unsafe", Message: "would break"},
+			}},
+			"b": {Synced: map[string]string{}, Skipped: map[string]Skip{
+				"moved": {Value: "b", Reason: "Unsafe", Message: "would break"},
+			}},
+		},
+	}
+	if !reflect.DeepEqual(plan, want) {
+		t.Errorf("Hold() = %+v, want %+v", plan, want)
+	}
+}
+
+func TestPlanLabels(t *testing.T) {
+	current := map[string]string{"keep": "1", "remove": "2", "change": "3"}
+	plan := Plan{
+		Apply:  map[string]string{"change": "4", "add": "5"},
+		Remove: []string{"remove"},
+	}
+	want := map[string]string{"keep": "1", "change": "4", "add": "5"}
+	if got := plan.Labels(current); !reflect.DeepEqual(got, want) {
+		t.Errorf("Labels() = %v, want %v", got, want)
+	}
+	if _, isExist := current["add"]; isExist {
+		t.Error("Labels() modified the current labels")
+	}
+}
+
+// scenario is a random input of NewPlan over a small set of keys, so sources collide often
+type scenario struct {
+	Sources  []Source
+	Current  map[string]string
+	Previous map[string]string
+	Policy   Policy
+}
+
+var (
+	scenarioKeys   = []string{"a", "b", "c", "d", "kubernetes.io/x"}
+	scenarioValues = []string{"1", "2"}
+	scenarioNames  = []string{"x", "y", "z"}
+)
+
+func randomLabels(rand *rand.Rand, keys []string, values []string) map[string]string {
+	labels := make(map[string]string)
+	for _, key := range keys {
+		if rand.Intn(2) == 0 {
+			labels[key] = values[rand.Intn(len(values))]
+		}
+	}
+	return labels
+}
+
+// Generate implements quick.Generator
+func (scenario) Generate(rand *rand.Rand, _ int) reflect.Value {
+	s := scenario{
+		Current:  randomLabels(rand, scenarioKeys, scenarioValues),
+		Previous: make(map[string]string),
+		Policy: Policy{
+			ProtectedLabelPrefixes: []string{"kubernetes.io/"},
+			Overwrite:              rand.Intn(2) == 0,
+			ApprovalRequiredLabels: []string{"c"},
+			Validate: func(key, value string) error {
+				if key == "d" && value == "2" {
+					return errors.New("invalid")
+				}
+				return nil
+			},
+		},
+	}
+	for _, name := range scenarioNames {
+		if rand.Intn(3) == 0 {
+			continue
+		}
+		s.Sources = append(s.Sources, Source{
+			Name:              name,
+			CreationTimestamp: older.Add(time.Duration(rand.Intn(3)) * time.Minute),
+			Labels:            randomLabels(rand, scenarioKeys, scenarioValues),
+			Deleting:          rand.Intn(4) == 0,
+			Approved:          rand.Intn(2) == 0,
+			Suspended:         rand.Intn(4) == 0,
+		})
+	}
+	for key := range s.Current {
+		if rand.Intn(2) == 0 {
+			s.Previous[key] = append(scenarioNames, "gone")[rand.Intn(len(scenarioNames)+1)]
+		}
+	}
+	return reflect.ValueOf(s)
+}
+
+func TestPlanProperties(t *testing.T) {
+	properties := map[string]func(scenario) bool{
+		"every label of a live source is either synced or skipped": func(s scenario) bool {
+			plan := NewPlan(s.Sources, s.Current, s.Previous, s.Policy)
+			for _, source := range s.Sources {
+				if source.Deleting {
+					continue
+				}
+				result := plan.Results[source.Name]
+				//the removals waiting for approval are the only skipped labels the source does not have
+				removals := 0
+				for key, skip := range result.Skipped {
+					if _, isExist := source.Labels[key]; isExist == skip.Removal {
+						return false
+					}
+					if skip.Removal {
+						removals++
+					}
+				}
+				if len(result.Synced)+len(result.Skipped)-removals != len(source.Labels) {
+					return false
+				}
+				for key := range source.Labels {
+					_, isSynced := result.Synced[key]
+					_, isSkipped := result.Skipped[key]
+					if isSynced == isSkipped {
+						return false
+					}
+				}
+			}
+			return true
+		},
+		"a label is synced by at most one source": func(s scenario) bool {
+			plan := NewPlan(s.Sources, s.Current, s.Previous, s.Policy)
+			owners := make(map[string]string)
+			for name, result := range plan.Results {
+				for key := range result.Synced {
+					if _, isExist := owners[key]; isExist {
+						return false
+					}
+					owners[key] = name
+				}
+			}
+			for key, owner := range owners {
+				if plan.Managed[key] != owner {
+					return false
+				}
+			}
+			return true
+		},
+		"applied and removed labels do not overlap": func(s scenario) bool {
+			plan := NewPlan(s.Sources, s.Current, s.Previous, s.Policy)
+			for _, key := range plan.Remove {
+				if _, isApplied := plan.Apply[key]; isApplied {
+					return false
+				}
+				if _, isManaged := plan.Managed[key]; isManaged {
+					return false
+				}
+			}
+			return true
+		},
+		"labels managed by a suspended source are never removed": func(s scenario) bool {
+			plan := NewPlan(s.Sources, s.Current, s.Previous, s.Policy)
+			for _, source := range s.Sources {
+				if !source.Suspended {
+					continue
+				}
+				for key, owner := range s.Previous {
+					if _, isManaged := plan.Managed[key]; owner == source.Name && !isManaged {
+						return false
+					}
+				}
+			}
+			return true
+		},
+		"deleting sources that are not suspended have no result and own no label": func(s scenario) bool {
+			plan := NewPlan(s.Sources, s.Current, s.Previous, s.Policy)
+			for _, source := range s.Sources {
+				if !source.Deleting || source.Suspended {
+					continue
+				}
+				if _, isExist := plan.Results[source.Name]; isExist {
+					return false
+				}
+				for _, owner := range plan.Managed {
+					if owner == source.Name {
+						return false
+					}
+				}
+			}
+			return true
+		},
+	}
+
+	for name, property := range properties {
+		t.Run(name, func(t *testing.T) {
+			if err := quick.Check(property, &quick.Config{MaxCount: 2000}); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
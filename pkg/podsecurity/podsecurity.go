@@ -0,0 +1,176 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package podsecurity validates the Pod Security Admission labels of a namespace and checks,
+// with a server dry-run, that a stricter enforce level does not reject the Pods already running in it.
+package podsecurity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// LabelPrefix is the domain of the Pod Security Admission labels
+const LabelPrefix = "pod-security.kubernetes.io/"
+
+// the labels of the enforce mode, the Pods violating its level are rejected
+const (
+	EnforceLabel        = LabelPrefix + "enforce"
+	EnforceVersionLabel = EnforceLabel + versionSuffix
+)
+
+// versionSuffix turns a mode label into the label pinning the version of its level
+const versionSuffix = "-version"
+
+// the levels of Pod Security Admission, from the least to the most restrictive
+const (
+	LevelPrivileged = "privileged"
+	LevelBaseline   = "baseline"
+	LevelRestricted = "restricted"
+)
+
+var modes = []string{"enforce", "audit", "warn"}
+
+var levelRanks = map[string]int{LevelPrivileged: 0, LevelBaseline: 1, LevelRestricted: 2}
+
+// the versions are latest or a kubernetes minor version, e.g. v1.25
+var versionPattern = regexp.MustCompile(`^(latest|v1\.(0|[1-9][0-9]*))$`)
+
+// Validate returns an error if the label is a Pod Security Admission label with an invalid value,
+// or an unknown key in its domain. The api server rejects the namespace updates carrying them
+func Validate(key, value string) error {
+	if !strings.HasPrefix(key, LabelPrefix) {
+		return nil
+	}
+	name := strings.TrimPrefix(key, LabelPrefix)
+	for _, mode := range modes {
+		switch name {
+		case mode:
+			if _, isLevel := levelRanks[value]; !isLevel {
+				return fmt.Errorf("level %q is not one of privileged, baseline or restricted", value)
+			}
+			return nil
+		case mode + versionSuffix:
+			if !versionPattern.MatchString(value) {
+				return fmt.Errorf("version %q is not latest or a version like v1.25", value)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not a Pod Security Admission label", key)
+}
+
+// IsStricter returns true if the enforce level of newLabels may reject Pods the level of oldLabels
+// admits: a more restrictive level, or another version of the same level. No level is privileged
+func IsStricter(oldLabels, newLabels map[string]string) bool {
+	oldLevel, newLevel := enforceLevel(oldLabels), enforceLevel(newLabels)
+	if levelRanks[newLevel] != levelRanks[oldLevel] {
+		return levelRanks[newLevel] > levelRanks[oldLevel]
+	}
+	return newLevel != LevelPrivileged && enforceVersion(oldLabels) != enforceVersion(newLabels)
+}
+
+func enforceLevel(labels map[string]string) string {
+	if level, isLevel := labels[EnforceLabel]; isLevel {
+		if _, isKnown := levelRanks[level]; isKnown {
+			return level
+		}
+	}
+	return LevelPrivileged
+}
+
+func enforceVersion(labels map[string]string) string {
+	if version, isExist := labels[EnforceVersionLabel]; isExist {
+		return version
+	}
+	return "latest"
+}
+
+// DryRunner returns the warnings of the api server for an update of the namespace labels, without
+// persisting it. Pod Security Admission warns about the existing Pods the new enforce level rejects
+type DryRunner interface {
+	DryRun(ctx context.Context, namespace *v1.Namespace, labels map[string]string) ([]string, error)
+}
+
+// ServerDryRun is the DryRunner sending a dry-run patch to the api server of Config
+type ServerDryRun struct {
+	Config *rest.Config
+}
+
+// warningCollector keeps the warnings of the requests of one client
+type warningCollector struct {
+	lock     sync.Mutex
+	warnings []string
+}
+
+func (c *warningCollector) HandleWarningHeader(code int, agent string, text string) {
+	if code != 299 || text == "" {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.warnings = append(c.warnings, text)
+}
+
+// DryRun patches the labels of the namespace with dryRun=All. A client is created for every call,
+// the warnings are handed to the handler of the client and the calls may run in parallel
+func (s ServerDryRun) DryRun(ctx context.Context, namespace *v1.Namespace, labels map[string]string) ([]string, error) {
+	collector := &warningCollector{}
+	config := rest.CopyConfig(s.Config)
+	config.WarningHandler = collector
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	patch, err := labelsPatch(namespace.Labels, labels)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := clientset.CoreV1().Namespaces().Patch(ctx, namespace.Name, types.MergePatchType, patch,
+		metav1.PatchOptions{DryRun: []string{metav1.DryRunAll}}); err != nil {
+		return nil, err
+	}
+	collector.lock.Lock()
+	defer collector.lock.Unlock()
+	return collector.warnings, nil
+}
+
+// the function return the merge patch changing the labels of the namespace from current to desired
+func labelsPatch(current, desired map[string]string) ([]byte, error) {
+	labels := make(map[string]interface{})
+	for key, value := range desired {
+		if currentValue, isExist := current[key]; !isExist || currentValue != value {
+			labels[key] = value
+		}
+	}
+	for key := range current {
+		if _, isExist := desired[key]; !isExist {
+			labels[key] = nil
+		}
+	}
+	return json.Marshal(map[string]interface{}{"metadata": map[string]interface{}{"labels": labels}})
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package podsecurity
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		key, value string
+		wantErr    bool
+	}{
+		{key: "team", value: "anything"},
+		{key: EnforceLabel, value: LevelRestricted},
+		{key: LabelPrefix + "warn", value: LevelBaseline},
+		{key: EnforceLabel, value: "strict", wantErr: true},
+		{key: EnforceVersionLabel, value: "latest"},
+		{key: LabelPrefix + "audit-version", value: "v1.25"},
+		{key: EnforceVersionLabel, value: "1.25", wantErr: true},
+		{key: EnforceVersionLabel, value: "v1.025", wantErr: true},
+		{key: LabelPrefix + "exempt", value: "true", wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := Validate(tt.key, tt.value); (err != nil) != tt.wantErr {
+			t.Errorf("Validate(%s, %s) error = %v, want error %v", tt.key, tt.value, err, tt.wantErr)
+		}
+	}
+}
+
+func TestIsStricter(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new map[string]string
+		want     bool
+	}{
+		{name: "no level to baseline", new: map[string]string{EnforceLabel: LevelBaseline}, want: true},
+		{name: "baseline to restricted", old: map[string]string{EnforceLabel: LevelBaseline}, new: map[string]string{EnforceLabel: LevelRestricted}, want: true},
+		{name: "restricted to baseline", old: map[string]string{EnforceLabel: LevelRestricted}, new: map[string]string{EnforceLabel: LevelBaseline}},
+		{name: "level removed", old: map[string]string{EnforceLabel: LevelRestricted}},
+		{name: "only audit changes", new: map[string]string{LabelPrefix + "audit": LevelRestricted}},
+		{
+			name: "version of the same level",
+			old:  map[string]string{EnforceLabel: LevelBaseline, EnforceVersionLabel: "v1.24"},
+			new:  map[string]string{EnforceLabel: LevelBaseline},
+			want: true,
+		},
+		{name: "version of privileged", new: map[string]string{EnforceLabel: LevelPrivileged, EnforceVersionLabel: "v1.24"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsStricter(tt.old, tt.new); got != tt.want {
+				t.Errorf("IsStricter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServerDryRun(t *testing.T) {
+	var gotQuery, gotPatch string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotQuery, gotPatch = r.URL.RawQuery, string(body)
+		w.Header().Add("Warning", `299 - "existing pods in namespace \"team\" violate the new PodSecurity enforce level \"restricted:latest\""`)
+		w.Header().Add("Warning", `299 - "web: privileged"`)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"team"}}`))
+	}))
+	defer server.Close()
+
+	namespace := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team", Labels: map[string]string{"old": "1", "team": "a"}}}
+	warnings, err := ServerDryRun{Config: &rest.Config{Host: server.URL}}.DryRun(context.Background(), namespace,
+		map[string]string{"team": "a", EnforceLabel: LevelRestricted})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`existing pods in namespace "team" violate the new PodSecurity enforce level "restricted:latest"`, "web: privileged"}
+	if !reflect.DeepEqual(warnings, want) {
+		t.Errorf("DryRun() = %q, want %q", warnings, want)
+	}
+	if gotQuery != "dryRun=All" {
+		t.Errorf("query = %q, want a dry-run", gotQuery)
+	}
+	if wantPatch := `{"metadata":{"labels":{"old":null,"pod-security.kubernetes.io/enforce":"restricted"}}}`; gotPatch != wantPatch {
+		t.Errorf("patch = %s, want %s", gotPatch, wantPatch)
+	}
+}
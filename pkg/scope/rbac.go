@@ -0,0 +1,169 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scope
+
+import (
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// the rules below are the ones of the kubebuilder rbac markers in the controllers package,
+// split between the cluster and the namespaces in scope
+
+var eventRule = rbacv1.PolicyRule{
+	APIGroups: []string{""},
+	Resources: []string{"events"},
+	Verbs:     []string{"create", "patch"},
+}
+
+var namespaceLabelRules = []rbacv1.PolicyRule{
+	{
+		APIGroups: []string{"omer.omer.io"},
+		Resources: []string{"namespacelabels"},
+		Verbs:     []string{"create", "delete", "get", "list", "patch", "update", "watch"},
+	},
+	{
+		APIGroups: []string{"omer.omer.io"},
+		Resources: []string{"namespacelabels/finalizers"},
+		Verbs:     []string{"update"},
+	},
+	{
+		APIGroups: []string{"omer.omer.io"},
+		Resources: []string{"namespacelabels/status"},
+		Verbs:     []string{"get", "patch", "update"},
+	},
+	{
+		APIGroups: []string{"omer.omer.io"},
+		Resources: []string{"labelchangeapprovals"},
+		Verbs:     []string{"get", "list", "watch"},
+	},
+	{
+		APIGroups: []string{"omer.omer.io"},
+		Resources: []string{"namespacelabelsnapshots"},
+		Verbs:     []string{"create", "delete", "get", "list", "watch"},
+	},
+	{
+		APIGroups: []string{"omer.omer.io"},
+		Resources: []string{"federatednamespacelabels"},
+		Verbs:     []string{"get", "list", "patch", "update", "watch"},
+	},
+	{
+		APIGroups: []string{"omer.omer.io"},
+		Resources: []string{"federatednamespacelabels/finalizers"},
+		Verbs:     []string{"update"},
+	},
+	{
+		APIGroups: []string{"omer.omer.io"},
+		Resources: []string{"federatednamespacelabels/status"},
+		Verbs:     []string{"get", "patch", "update"},
+	},
+}
+
+// the rules are cluster scoped, every namespace is matched against them
+var namespaceLabelRuleRule = rbacv1.PolicyRule{
+	APIGroups: []string{"omer.omer.io"},
+	Resources: []string{"namespacelabelrules"},
+	Verbs:     []string{"get", "list", "watch"},
+}
+
+// the objects of the namespaces the labels can be propagated to
+var propagationRules = []rbacv1.PolicyRule{
+	{
+		APIGroups: []string{"apps"},
+		Resources: []string{"deployments", "statefulsets"},
+		Verbs:     []string{"get", "list", "patch", "watch"},
+	},
+	{
+		APIGroups: []string{""},
+		Resources: []string{"podtemplates", "serviceaccounts", "services"},
+		Verbs:     []string{"get", "list", "patch", "watch"},
+	},
+}
+
+// RBAC returns the roles the controller needs to serve the scope, bound to the service account.
+// Labels of a namespace can change at any time, so a scope with only a selector gets the cluster
+// wide rules. A scope listing namespaces by name is narrower: the namespaces can only be changed
+// by name and the NamespaceLabels are only accessible in the listed namespaces. Listing and
+// watching namespaces stays cluster wide, the api server cannot restrict a list to some names
+func (s Scope) RBAC(name string, serviceAccount types.NamespacedName) []client.Object {
+	subjects := []rbacv1.Subject{{
+		Kind:      rbacv1.ServiceAccountKind,
+		Name:      serviceAccount.Name,
+		Namespace: serviceAccount.Namespace,
+	}}
+	clusterRole := &rbacv1.ClusterRole{
+		TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRole"},
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+	}
+	objects := []client.Object{
+		clusterRole,
+		&rbacv1.ClusterRoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRoleBinding"},
+			ObjectMeta: metav1.ObjectMeta{Name: name + "binding"},
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: name},
+			Subjects:   subjects,
+		},
+	}
+
+	if len(s.Namespaces) == 0 {
+		clusterRole.Rules = append([]rbacv1.PolicyRule{
+			eventRule,
+			namespaceLabelRuleRule,
+			{
+				APIGroups: []string{""},
+				Resources: []string{"namespaces"},
+				Verbs:     []string{"get", "list", "patch", "update", "watch"},
+			},
+		}, namespaceLabelRules...)
+		clusterRole.Rules = append(clusterRole.Rules, propagationRules...)
+		return objects
+	}
+
+	clusterRole.Rules = []rbacv1.PolicyRule{
+		eventRule,
+		namespaceLabelRuleRule,
+		{
+			APIGroups: []string{""},
+			Resources: []string{"namespaces"},
+			Verbs:     []string{"list", "watch"},
+		},
+		{
+			APIGroups:     []string{""},
+			Resources:     []string{"namespaces"},
+			ResourceNames: s.Namespaces,
+			Verbs:         []string{"get", "patch", "update"},
+		},
+	}
+	for _, namespace := range s.Namespaces {
+		objects = append(objects,
+			&rbacv1.Role{
+				TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "Role"},
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+				Rules:      append(append([]rbacv1.PolicyRule{}, namespaceLabelRules...), propagationRules...),
+			},
+			&rbacv1.RoleBinding{
+				TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "RoleBinding"},
+				ObjectMeta: metav1.ObjectMeta{Name: name + "binding", Namespace: namespace},
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: name},
+				Subjects:   subjects,
+			},
+		)
+	}
+	return objects
+}
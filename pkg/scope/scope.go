@@ -0,0 +1,87 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package scope restricts the controller to a part of the cluster, so several controller
+// instances can serve different tenants. A Scope selects namespaces by name and by label,
+// it builds the manager cache that only sees those namespaces and the RBAC the controller
+// needs to serve them.
+package scope
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+)
+
+// Scope is the set of namespaces a controller instance serves, a namespace is in scope
+// when it matches both the names and the selector
+type Scope struct {
+	// Namespaces are the names of the namespaces in scope, every namespace when empty
+	Namespaces []string
+	// Selector selects the namespaces in scope by their labels, every namespace when nil
+	Selector *metav1.LabelSelector
+}
+
+// IsClusterWide returns true when every namespace is in scope
+func (s Scope) IsClusterWide() bool {
+	return len(s.Namespaces) == 0 && s.Selector == nil
+}
+
+// NamespaceSelector returns the label selector matching the namespaces in scope. The names are
+// matched on the kubernetes.io/metadata.name label the api server sets on every namespace
+func (s Scope) NamespaceSelector() (labels.Selector, error) {
+	selector := labels.Everything()
+	if s.Selector != nil {
+		var err error
+		if selector, err = metav1.LabelSelectorAsSelector(s.Selector); err != nil {
+			return nil, err
+		}
+	}
+	if len(s.Namespaces) > 0 {
+		requirement, err := labels.NewRequirement(v1.LabelMetadataName, selection.In, s.Namespaces)
+		if err != nil {
+			return nil, err
+		}
+		selector = selector.Add(*requirement)
+	}
+	return selector, nil
+}
+
+// NewCache returns the function building the manager cache. The cache only holds the namespaces in
+// scope, so the controller never sees the others. When namespaces are listed by name the NamespaceLabels
+// are only watched in them, otherwise NamespaceLabels are watched in every namespace and the ones
+// outside the scope are ignored
+func (s Scope) NewCache() (cache.NewCacheFunc, error) {
+	selector, err := s.NamespaceSelector()
+	if err != nil {
+		return nil, err
+	}
+	return func(config *rest.Config, opts cache.Options) (cache.Cache, error) {
+		if s.IsClusterWide() {
+			return cache.New(config, opts)
+		}
+		opts.SelectorsByObject = cache.SelectorsByObject{
+			&v1.Namespace{}: {Label: selector},
+		}
+		if len(s.Namespaces) > 0 {
+			return cache.MultiNamespacedCacheBuilder(s.Namespaces)(config, opts)
+		}
+		return cache.New(config, opts)
+	}, nil
+}
@@ -0,0 +1,218 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scope
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"testing"
+
+	"golang.org/x/exp/maps"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+func TestNamespaceSelector(t *testing.T) {
+	namespace := func(name string, extra map[string]string) labels.Set {
+		set := labels.Set{"kubernetes.io/metadata.name": name}
+		for key, value := range extra {
+			set[key] = value
+		}
+		return set
+	}
+
+	tests := []struct {
+		name      string
+		scope     Scope
+		namespace labels.Set
+		want      bool
+	}{
+		{
+			name:      "cluster wide scope matches every namespace",
+			namespace: namespace("team-a", nil),
+			want:      true,
+		},
+		{
+			name:      "listed namespace",
+			scope:     Scope{Namespaces: []string{"team-a", "team-b"}},
+			namespace: namespace("team-b", nil),
+			want:      true,
+		},
+		{
+			name:      "namespace not listed",
+			scope:     Scope{Namespaces: []string{"team-a", "team-b"}},
+			namespace: namespace("team-c", nil),
+		},
+		{
+			name:      "namespace matching the selector",
+			scope:     Scope{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "a"}}},
+			namespace: namespace("team-c", map[string]string{"tenant": "a"}),
+			want:      true,
+		},
+		{
+			name:      "namespace not matching the selector",
+			scope:     Scope{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "a"}}},
+			namespace: namespace("team-c", map[string]string{"tenant": "b"}),
+		},
+		{
+			name: "listed namespace not matching the selector",
+			scope: Scope{
+				Namespaces: []string{"team-a"},
+				Selector:   &metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "a"}},
+			},
+			namespace: namespace("team-a", map[string]string{"tenant": "b"}),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector, err := tt.scope.NamespaceSelector()
+			if err != nil {
+				t.Fatalf("NamespaceSelector() error = %v", err)
+			}
+			if got := selector.Matches(tt.namespace); got != tt.want {
+				t.Errorf("selector %s matches %v = %v, want %v", selector, tt.namespace, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNamespaceSelectorInvalid(t *testing.T) {
+	s := Scope{Selector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+		{Key: "tenant", Operator: "Unknown"},
+	}}}
+	if _, err := s.NamespaceSelector(); err == nil {
+		t.Error("NamespaceSelector() expected an error for an unknown operator")
+	}
+	if _, err := s.NewCache(); err == nil {
+		t.Error("NewCache() expected an error for an unknown operator")
+	}
+}
+
+func TestRBAC(t *testing.T) {
+	serviceAccount := types.NamespacedName{Namespace: "system", Name: "controller-manager"}
+
+	t.Run("cluster wide scope", func(t *testing.T) {
+		objects := Scope{}.RBAC("manager-role", serviceAccount)
+		if len(objects) != 2 {
+			t.Fatalf("got %d objects, want the cluster role and its binding", len(objects))
+		}
+		clusterRole := objects[0].(*rbacv1.ClusterRole)
+		if len(clusterRole.Rules) != 3+len(namespaceLabelRules)+len(propagationRules) {
+			t.Errorf("cluster role has %d rules, want the namespace, event, rule, namespacelabel and propagation rules", len(clusterRole.Rules))
+		}
+	})
+
+	t.Run("listed namespaces", func(t *testing.T) {
+		objects := Scope{Namespaces: []string{"team-a", "team-b"}}.RBAC("manager-role", serviceAccount)
+		if len(objects) != 6 {
+			t.Fatalf("got %d objects, want the cluster role, a role per namespace and their bindings", len(objects))
+		}
+		for _, rule := range objects[0].(*rbacv1.ClusterRole).Rules {
+			for _, resource := range rule.Resources {
+				if resource == "namespaces" && len(rule.ResourceNames) == 0 && rule.Verbs[0] != "list" {
+					t.Errorf("cluster role can change every namespace: %v", rule)
+				}
+				if resource == "namespacelabels" || resource == "deployments" {
+					t.Errorf("cluster role grants %s in every namespace: %v", resource, rule)
+				}
+			}
+		}
+		for _, object := range objects[2:] {
+			if object.GetNamespace() != "team-a" && object.GetNamespace() != "team-b" {
+				t.Errorf("%T %s is not in a listed namespace", object, object.GetName())
+			}
+		}
+		binding := objects[3].(*rbacv1.RoleBinding)
+		if binding.RoleRef.Kind != "Role" || binding.Subjects[0].Name != serviceAccount.Name || binding.Subjects[0].Namespace != serviceAccount.Namespace {
+			t.Errorf("role binding %v does not bind the role to the service account", binding)
+		}
+	})
+}
+
+// the function return every group, resource and verb the rules grant, one entry each
+func grants(rules []rbacv1.PolicyRule) map[string]bool {
+	granted := make(map[string]bool)
+	for _, rule := range rules {
+		for _, group := range rule.APIGroups {
+			for _, resource := range rule.Resources {
+				for _, verb := range rule.Verbs {
+					granted[group+"/"+resource+"/"+verb] = true
+				}
+			}
+		}
+	}
+	return granted
+}
+
+// the rules written by hand must not drift from the ones controller-gen generates from the rbac markers
+func TestRBACMatchesMarkers(t *testing.T) {
+	file, err := os.Open("../../config/rbac/role.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var generated *rbacv1.ClusterRole
+	decoder := yaml.NewYAMLOrJSONDecoder(file, 4096)
+	for generated == nil {
+		var role rbacv1.ClusterRole
+		if err := decoder.Decode(&role); errors.Is(err, io.EOF) {
+			t.Fatal("config/rbac/role.yaml has no ClusterRole")
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if role.Kind == "ClusterRole" {
+			generated = &role
+		}
+	}
+	want := grants(generated.Rules)
+
+	compare := func(t *testing.T, got map[string]bool) {
+		for _, grant := range sortedGrants(want) {
+			if !got[grant] {
+				t.Errorf("missing %s", grant)
+			}
+		}
+		for _, grant := range sortedGrants(got) {
+			if !want[grant] {
+				t.Errorf("%s is not in config/rbac/role.yaml", grant)
+			}
+		}
+	}
+	serviceAccount := types.NamespacedName{Namespace: "system", Name: "controller-manager"}
+
+	t.Run("cluster wide scope", func(t *testing.T) {
+		objects := Scope{}.RBAC("manager-role", serviceAccount)
+		compare(t, grants(objects[0].(*rbacv1.ClusterRole).Rules))
+	})
+
+	t.Run("listed namespaces", func(t *testing.T) {
+		objects := Scope{Namespaces: []string{"team-a"}}.RBAC("manager-role", serviceAccount)
+		rules := append(objects[0].(*rbacv1.ClusterRole).Rules, objects[2].(*rbacv1.Role).Rules...)
+		compare(t, grants(rules))
+	})
+}
+
+func sortedGrants(granted map[string]bool) []string {
+	keys := maps.Keys(granted)
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,286 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sharding
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// GroupLabel is set on the Leases of the replicas, its value is the group sharing the namespaces
+const GroupLabel = "namespacelabel.omer.io/shard-group"
+
+// Coordinator keeps the Lease of the replica and decides which namespaces it owns.
+// A namespace is owned when the replica owns it on the current ring and on the stable ring,
+// the ring that was in effect before the last membership change. The stable ring follows the
+// current one after SettlePeriod without membership changes, so a namespace moves to another
+// replica only after the previous owner saw the change and finished its reconciles.
+// The replica owns nothing while it cannot renew its Lease, the others take over its
+// namespaces once the Lease expired and they settled.
+type Coordinator struct {
+	// Client writes the Lease of the replica
+	Client client.Client
+	// Reader lists the Leases of the group, it should read from the api server,
+	// a cache would watch the Leases of the whole cluster
+	Reader client.Reader
+	// Identity names the replica, it must be unique in the group
+	Identity string
+	// Namespace holds the Leases of the group
+	Namespace string
+	// Group names the replicas sharing the namespaces
+	Group string
+	// LeaseDuration is the time a replica is a member after its last renewal
+	LeaseDuration time.Duration
+	// RenewInterval is the time between two renewals, it must be well below LeaseDuration
+	RenewInterval time.Duration
+	// SettlePeriod is the time the ring must stay unchanged before namespaces move,
+	// it must be longer than RenewInterval plus the longest reconcile
+	SettlePeriod time.Duration
+	// VirtualNodes is the number of points per member on the ring, DefaultVirtualNodes when zero
+	VirtualNodes int
+	// Namespaces lists the namespaces of the cluster, the ones gained by the replica are sent to Events
+	Namespaces func(ctx context.Context) ([]string, error)
+	// Clock is the real clock when nil
+	Clock clock.Clock
+
+	mu sync.RWMutex
+	// current is the ring of the live members, stable the one namespaces are owned on
+	current, stable *Ring
+	changedAt       time.Time
+	renewedAt       time.Time
+	// observed holds the renew time last read on the Lease of every member and when it was read,
+	// the expiry is computed from the local clock so the clocks of the replicas may differ
+	observed map[string]observedLease
+
+	events chan event.GenericEvent
+}
+
+type observedLease struct {
+	renewTime  metav1.MicroTime
+	observedAt time.Time
+	duration   time.Duration
+}
+
+// NeedLeaderElection makes the coordinator run on every replica, sharding replaces leader election
+func (c *Coordinator) NeedLeaderElection() bool {
+	return false
+}
+
+// Events returns the channel the namespaces gained by the replica are sent to,
+// the controller watches it to reconcile them
+func (c *Coordinator) Events() <-chan event.GenericEvent {
+	return c.channel()
+}
+
+func (c *Coordinator) channel() chan event.GenericEvent {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.events == nil {
+		c.events = make(chan event.GenericEvent)
+	}
+	return c.events
+}
+
+// Owns returns true if the replica may reconcile the namespace
+func (c *Coordinator) Owns(namespace string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.clock().Since(c.renewedAt) >= c.LeaseDuration {
+		return false
+	}
+	return c.current.Owner(namespace) == c.Identity && c.stable.Owner(namespace) == c.Identity
+}
+
+// Start renews the Lease and follows the members every RenewInterval until the context is
+// cancelled, the Lease is then deleted so the other replicas take over sooner
+func (c *Coordinator) Start(ctx context.Context) error {
+	logger := ctrllog.FromContext(ctx).WithName("sharding")
+	c.channel()
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := c.Sync(ctx); err != nil {
+			logger.Error(err, "unable to sync the shard members")
+		}
+	}, c.RenewInterval)
+
+	releaseCtx, cancel := context.WithTimeout(context.Background(), c.RenewInterval)
+	defer cancel()
+	lease := &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Name: c.leaseName(), Namespace: c.Namespace}}
+	if err := c.Client.Delete(releaseCtx, lease); err != nil && !apierrors.IsNotFound(err) {
+		logger.Error(err, "unable to release the shard lease")
+	}
+	return nil
+}
+
+// Sync renews the Lease of the replica, reads the members and moves the stable ring
+// forward once the members settled
+func (c *Coordinator) Sync(ctx context.Context) error {
+	logger := ctrllog.FromContext(ctx).WithName("sharding")
+	//the renewal counts from before it was sent, the others may see it any time after
+	renewedAt := c.clock().Now()
+	renewErr := c.renew(ctx)
+
+	var leaseList coordinationv1.LeaseList
+	if err := c.Reader.List(ctx, &leaseList, client.InNamespace(c.Namespace), client.MatchingLabels{GroupLabel: c.Group}); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	now := c.clock().Now()
+	if renewErr == nil {
+		//a replica whose lease expired joins again, the others may have taken over its namespaces
+		if renewedAt.Sub(c.renewedAt) >= c.LeaseDuration {
+			c.current, c.stable = nil, nil
+		}
+		c.renewedAt = renewedAt
+	}
+	members := c.liveMembers(leaseList.Items, now)
+	ring := NewRing(members, c.virtualNodes())
+	if !ring.Equal(c.current) {
+		logger.Info("shard members changed", "members", members)
+		c.current = ring
+		c.changedAt = now
+	}
+	previous, isSettled := c.stable, false
+	if !c.current.Equal(c.stable) && now.Sub(c.changedAt) >= c.SettlePeriod {
+		logger.Info("shard members settled", "members", members)
+		c.stable, isSettled = c.current, true
+	}
+	c.mu.Unlock()
+
+	if isSettled {
+		c.sendGained(ctx, previous)
+	}
+	return renewErr
+}
+
+// the function create or renew the Lease of the replica
+func (c *Coordinator) renew(ctx context.Context) error {
+	now := metav1.NewMicroTime(c.clock().Now())
+	durationSeconds := int32(c.LeaseDuration / time.Second)
+	var lease coordinationv1.Lease
+	err := c.Reader.Get(ctx, types.NamespacedName{Name: c.leaseName(), Namespace: c.Namespace}, &lease)
+	if apierrors.IsNotFound(err) {
+		lease = coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      c.leaseName(),
+				Namespace: c.Namespace,
+				Labels:    map[string]string{GroupLabel: c.Group},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &c.Identity,
+				LeaseDurationSeconds: &durationSeconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		return c.Client.Create(ctx, &lease)
+	}
+	if err != nil {
+		return err
+	}
+	lease.Spec.HolderIdentity = &c.Identity
+	lease.Spec.LeaseDurationSeconds = &durationSeconds
+	lease.Spec.RenewTime = &now
+	return c.Client.Update(ctx, &lease)
+}
+
+// the function return the members whose Lease was renewed within its duration, by the local clock
+func (c *Coordinator) liveMembers(leases []coordinationv1.Lease, now time.Time) []string {
+	observed := make(map[string]observedLease, len(leases))
+	var members []string
+	for _, lease := range leases {
+		if lease.Spec.HolderIdentity == nil || lease.Spec.RenewTime == nil {
+			continue
+		}
+		identity := *lease.Spec.HolderIdentity
+		duration := c.LeaseDuration
+		if lease.Spec.LeaseDurationSeconds != nil {
+			duration = time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second
+		}
+		last, isExist := c.observed[identity]
+		if !isExist || !last.renewTime.Equal(lease.Spec.RenewTime) {
+			last = observedLease{renewTime: *lease.Spec.RenewTime, observedAt: now}
+		}
+		last.duration = duration
+		observed[identity] = last
+		if now.Sub(last.observedAt) < duration {
+			members = append(members, identity)
+		}
+	}
+	c.observed = observed
+	return members
+}
+
+// the function send the namespaces owned on the stable ring and not on the previous one,
+// nothing triggers their reconcile on this replica otherwise
+func (c *Coordinator) sendGained(ctx context.Context, previous *Ring) {
+	if c.Namespaces == nil {
+		return
+	}
+	logger := ctrllog.FromContext(ctx).WithName("sharding")
+	namespaces, err := c.Namespaces(ctx)
+	if err != nil {
+		logger.Error(err, "unable to list the namespaces gained by the shard")
+		return
+	}
+	var gained []event.GenericEvent
+	for _, namespace := range namespaces {
+		if c.Owns(namespace) && previous.Owner(namespace) != c.Identity {
+			gained = append(gained, event.GenericEvent{Object: &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}}})
+		}
+	}
+	events := c.channel()
+	go func() {
+		for _, e := range gained {
+			select {
+			case events <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (c *Coordinator) leaseName() string {
+	return c.Group + "-" + c.Identity
+}
+
+func (c *Coordinator) virtualNodes() int {
+	if c.VirtualNodes == 0 {
+		return DefaultVirtualNodes
+	}
+	return c.VirtualNodes
+}
+
+func (c *Coordinator) clock() clock.Clock {
+	if c.Clock == nil {
+		return clock.RealClock{}
+	}
+	return c.Clock
+}
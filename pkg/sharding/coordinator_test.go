@@ -0,0 +1,214 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sharding
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const (
+	leaseDuration = 15 * time.Second
+	renewInterval = 5 * time.Second
+	settlePeriod  = 30 * time.Second
+)
+
+func newFakeClient(t *testing.T) client.Client {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).Build()
+}
+
+func newCoordinator(c client.Client, clock *clocktesting.FakeClock, identity string, names []string) *Coordinator {
+	return &Coordinator{
+		Client:        c,
+		Reader:        c,
+		Identity:      identity,
+		Namespace:     "system",
+		Group:         "namespacelabel",
+		LeaseDuration: leaseDuration,
+		RenewInterval: renewInterval,
+		SettlePeriod:  settlePeriod,
+		Namespaces: func(ctx context.Context) ([]string, error) {
+			return names, nil
+		},
+		Clock: clock,
+	}
+}
+
+// the function check no namespace is owned by two replicas and return how many are owned
+func checkExclusive(t *testing.T, coordinators []*Coordinator, names []string) int {
+	t.Helper()
+	owned := 0
+	for _, namespace := range names {
+		var owners []string
+		for _, coordinator := range coordinators {
+			if coordinator.Owns(namespace) {
+				owners = append(owners, coordinator.Identity)
+			}
+		}
+		if len(owners) > 1 {
+			t.Fatalf("namespace %s is owned by %v", namespace, owners)
+		}
+		owned += len(owners)
+	}
+	return owned
+}
+
+func TestCoordinatorSettles(t *testing.T) {
+	ctx := context.Background()
+	c := newFakeClient(t)
+	clock := clocktesting.NewFakeClock(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	names := namespaces(100)
+	a := newCoordinator(c, clock, "a", names)
+	events := a.Events()
+
+	if err := a.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if owned := checkExclusive(t, []*Coordinator{a}, names); owned != 0 {
+		t.Fatalf("replica owns %d namespaces before settling", owned)
+	}
+
+	for elapsed := time.Duration(0); elapsed < settlePeriod; elapsed += renewInterval {
+		clock.Step(renewInterval)
+		if err := a.Sync(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if owned := checkExclusive(t, []*Coordinator{a}, names); owned != len(names) {
+		t.Fatalf("single replica owns %d of %d namespaces", owned, len(names))
+	}
+	for range names {
+		select {
+		case <-events:
+		case <-time.After(time.Second):
+			t.Fatal("the gained namespaces were not all sent")
+		}
+	}
+
+	//the replica owns nothing once it could not renew its lease for a lease duration,
+	//and settles again before owning namespaces when it renews again
+	clock.Step(leaseDuration)
+	if owned := checkExclusive(t, []*Coordinator{a}, names); owned != 0 {
+		t.Fatalf("replica owns %d namespaces without a lease", owned)
+	}
+	if err := a.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if owned := checkExclusive(t, []*Coordinator{a}, names); owned != 0 {
+		t.Fatalf("replica owns %d namespaces right after its lease expired", owned)
+	}
+}
+
+func TestCoordinatorJoinAndLeave(t *testing.T) {
+	ctx := context.Background()
+	c := newFakeClient(t)
+	clock := clocktesting.NewFakeClock(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	names := namespaces(200)
+	a := newCoordinator(c, clock, "a", names)
+	b := newCoordinator(c, clock, "b", names)
+
+	tick := func(coordinators ...*Coordinator) {
+		clock.Step(renewInterval)
+		for _, coordinator := range coordinators {
+			if err := coordinator.Sync(ctx); err != nil {
+				t.Fatal(err)
+			}
+		}
+		checkExclusive(t, []*Coordinator{a, b}, names)
+	}
+
+	for i := 0; i < 10; i++ {
+		tick(a)
+	}
+	if owned := checkExclusive(t, []*Coordinator{a, b}, names); owned != len(names) {
+		t.Fatalf("%d of %d namespaces are owned", owned, len(names))
+	}
+
+	//b joins, a gives up the namespaces of b at once and b takes them after settling
+	for i := 0; i < 10; i++ {
+		tick(a, b)
+	}
+	if owned := checkExclusive(t, []*Coordinator{a, b}, names); owned != len(names) {
+		t.Fatalf("%d of %d namespaces are owned after b joined", owned, len(names))
+	}
+	bOwned := 0
+	for _, namespace := range names {
+		if b.Owns(namespace) {
+			bOwned++
+		}
+	}
+	if bOwned == 0 || bOwned == len(names) {
+		t.Fatalf("b owns %d of %d namespaces", bOwned, len(names))
+	}
+
+	//b stops renewing, a takes its namespaces once the lease expired and a settled
+	for i := 0; i < 20; i++ {
+		tick(a)
+	}
+	for _, namespace := range names {
+		if !a.Owns(namespace) {
+			t.Fatalf("namespace %s is not owned by a after b left", namespace)
+		}
+	}
+}
+
+// TestCoordinatorChaos joins, stops and partitions replicas at random, a partitioned replica
+// keeps answering Owns without reaching the api server. No namespace may ever have two owners
+func TestCoordinatorChaos(t *testing.T) {
+	ctx := context.Background()
+	for seed := int64(1); seed <= 10; seed++ {
+		random := rand.New(rand.NewSource(seed))
+		c := newFakeClient(t)
+		clock := clocktesting.NewFakeClock(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+		names := namespaces(50)
+		identities := []string{"a", "b", "c", "d"}
+		coordinators := make([]*Coordinator, len(identities))
+		syncing := make([]bool, len(identities))
+		for i, identity := range identities {
+			coordinators[i] = newCoordinator(c, clock, identity, names)
+			coordinators[i].Namespaces = nil
+		}
+
+		for step := 0; step < 200; step++ {
+			if random.Intn(10) == 0 {
+				i := random.Intn(len(identities))
+				syncing[i] = !syncing[i]
+			}
+			clock.Step(renewInterval)
+			for i, coordinator := range coordinators {
+				if syncing[i] {
+					if err := coordinator.Sync(ctx); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			checkExclusive(t, coordinators, names)
+		}
+	}
+}
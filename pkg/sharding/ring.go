@@ -0,0 +1,108 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package sharding splits the namespaces between the replicas of the manager. Every replica
+// renews a Lease, the live Leases are the members of a consistent hash ring and a replica
+// only reconciles the namespaces the ring gives to it. A replica waits a settle period before
+// taking over namespaces from another one, so two replicas never write the same namespace.
+package sharding
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// DefaultVirtualNodes is the number of points every member has on the ring,
+// more points spread the namespaces more evenly between the members
+const DefaultVirtualNodes = 128
+
+// Ring is a consistent hash ring, adding or removing a member only moves
+// the keys of that member
+type Ring struct {
+	members []string
+	points  []uint64
+	owners  map[uint64]string
+}
+
+// NewRing returns the ring of the members, with virtualNodes points per member
+func NewRing(members []string, virtualNodes int) *Ring {
+	r := &Ring{owners: make(map[uint64]string)}
+	r.members = append(r.members, members...)
+	sort.Strings(r.members)
+	for _, member := range r.members {
+		for i := 0; i < virtualNodes; i++ {
+			point := hash(member + "#" + strconv.Itoa(i))
+			//on a collision the smallest member keeps the point, the same on every replica
+			if _, isExist := r.owners[point]; isExist {
+				continue
+			}
+			r.owners[point] = member
+			r.points = append(r.points, point)
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// Members returns the sorted members of the ring
+func (r *Ring) Members() []string {
+	return r.members
+}
+
+// Owner returns the member owning the key, the first point clockwise from the key hash.
+// It returns an empty string when the ring has no members
+func (r *Ring) Owner(key string) string {
+	if r == nil || len(r.points) == 0 {
+		return ""
+	}
+	h := hash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
+
+// Equal returns true if both rings have the same members
+func (r *Ring) Equal(other *Ring) bool {
+	if r == nil || other == nil {
+		return r == other
+	}
+	if len(r.members) != len(other.members) {
+		return false
+	}
+	for i := range r.members {
+		if r.members[i] != other.members[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// the function hash the key with fnv and spread the result with the splitmix64 finalizer,
+// fnv alone puts similar keys like the virtual nodes of a member close to each other
+func hash(key string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
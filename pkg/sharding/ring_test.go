@@ -0,0 +1,97 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sharding
+
+import (
+	"fmt"
+	"testing"
+)
+
+func namespaces(n int) []string {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("namespace-%d", i)
+	}
+	return names
+}
+
+func TestRingOwner(t *testing.T) {
+	if owner := NewRing(nil, DefaultVirtualNodes).Owner("a"); owner != "" {
+		t.Errorf("empty ring owner = %q, want none", owner)
+	}
+	var nilRing *Ring
+	if owner := nilRing.Owner("a"); owner != "" {
+		t.Errorf("nil ring owner = %q, want none", owner)
+	}
+
+	//every replica builds the same ring whatever the order it lists the members in
+	r1 := NewRing([]string{"a", "b", "c"}, DefaultVirtualNodes)
+	r2 := NewRing([]string{"c", "a", "b"}, DefaultVirtualNodes)
+	for _, namespace := range namespaces(1000) {
+		if r1.Owner(namespace) != r2.Owner(namespace) {
+			t.Fatalf("namespace %s is owned by %s and %s", namespace, r1.Owner(namespace), r2.Owner(namespace))
+		}
+	}
+}
+
+func TestRingBalance(t *testing.T) {
+	members := []string{"manager-0", "manager-1", "manager-2", "manager-3"}
+	ring := NewRing(members, DefaultVirtualNodes)
+	owned := make(map[string]int)
+	const total = 10000
+	for _, namespace := range namespaces(total) {
+		owned[ring.Owner(namespace)]++
+	}
+	for _, member := range members {
+		share := float64(owned[member]) / total
+		if share < 0.15 || share > 0.35 {
+			t.Errorf("member %s owns %.2f of the namespaces, want about 0.25", member, share)
+		}
+	}
+}
+
+func TestRingMovement(t *testing.T) {
+	before := NewRing([]string{"a", "b", "c"}, DefaultVirtualNodes)
+	after := NewRing([]string{"a", "b", "c", "d"}, DefaultVirtualNodes)
+	moved := 0
+	for _, namespace := range namespaces(10000) {
+		if before.Owner(namespace) == after.Owner(namespace) {
+			continue
+		}
+		//a joining member only takes namespaces, it never moves them between the others
+		if after.Owner(namespace) != "d" {
+			t.Fatalf("namespace %s moved from %s to %s", namespace, before.Owner(namespace), after.Owner(namespace))
+		}
+		moved++
+	}
+	if moved == 0 || moved > 4000 {
+		t.Errorf("%d of 10000 namespaces moved, want about a quarter", moved)
+	}
+}
+
+func TestRingEqual(t *testing.T) {
+	if !NewRing([]string{"a", "b"}, 1).Equal(NewRing([]string{"b", "a"}, 1)) {
+		t.Error("rings with the same members are not equal")
+	}
+	if NewRing([]string{"a", "b"}, 1).Equal(NewRing([]string{"a"}, 1)) {
+		t.Error("rings with different members are equal")
+	}
+	var nilRing *Ring
+	if nilRing.Equal(NewRing(nil, 1)) {
+		t.Error("nil ring is equal to an empty ring")
+	}
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package snapshot records the label state of a namespace in NamespaceLabelSnapshots and computes the
+// rollback of the NamespaceLabels of the namespace to one of them. The inherited labels and the labels
+// derived by the rules are recorded too, but a rollback only rewrites the NamespaceLabels of the namespace.
+package snapshot
+
+import (
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	omerv1 "omer.io/namespacelabel/api/v1"
+)
+
+// NamePrefix starts the names of the snapshots, followed by the time they are taken at
+const NamePrefix = "snapshot-"
+
+// Name returns the name of the snapshot taken at the time
+func Name(takenAt time.Time) string {
+	return NamePrefix + takenAt.UTC().Format("20060102-150405")
+}
+
+// New returns the label state of the namespace. managedLabels maps the labels managed in the namespace
+// to their owner, the NamespaceLabels being deleted are left out
+func New(namespace *corev1.Namespace, managedLabels map[string]string, namespaceLabels []omerv1.NamespaceLabel) omerv1.NamespaceLabelSnapshotSpec {
+	var spec omerv1.NamespaceLabelSnapshotSpec
+	for _, namespaceLabel := range namespaceLabels {
+		if !namespaceLabel.DeletionTimestamp.IsZero() {
+			continue
+		}
+		state := omerv1.NamespaceLabelState{Name: namespaceLabel.Name}
+		if len(namespaceLabel.Spec.Labels) > 0 {
+			state.Labels = make(map[string]string, len(namespaceLabel.Spec.Labels))
+			for key, value := range namespaceLabel.Spec.Labels {
+				state.Labels[key] = value
+			}
+		}
+		spec.NamespaceLabels = append(spec.NamespaceLabels, state)
+	}
+	sort.Slice(spec.NamespaceLabels, func(i, j int) bool { return spec.NamespaceLabels[i].Name < spec.NamespaceLabels[j].Name })
+
+	for key, owner := range managedLabels {
+		value, isInNamespace := namespace.Labels[key]
+		if !isInNamespace {
+			continue
+		}
+		spec.ManagedLabels = append(spec.ManagedLabels, omerv1.ManagedLabel{Key: key, Value: value, Owner: owner})
+	}
+	sort.Slice(spec.ManagedLabels, func(i, j int) bool { return spec.ManagedLabels[i].Key < spec.ManagedLabels[j].Key })
+	return spec
+}
+
+// IsEmpty returns true if the state holds no NamespaceLabel and no managed label, the namespaces
+// the controller never labeled get no snapshot
+func IsEmpty(spec omerv1.NamespaceLabelSnapshotSpec) bool {
+	return len(spec.NamespaceLabels) == 0 && len(spec.ManagedLabels) == 0
+}
+
+// Equal returns true if the two states are the same, a snapshot is only taken when the state changed
+func Equal(a, b omerv1.NamespaceLabelSnapshotSpec) bool {
+	return equality.Semantic.DeepEqual(a, b)
+}
+
+// Sort orders the snapshots from the oldest to the newest
+func Sort(snapshots []omerv1.NamespaceLabelSnapshot) {
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		if !snapshots[i].CreationTimestamp.Equal(&snapshots[j].CreationTimestamp) {
+			return snapshots[i].CreationTimestamp.Before(&snapshots[j].CreationTimestamp)
+		}
+		return snapshots[i].Name < snapshots[j].Name
+	})
+}
+
+// Expired returns the snapshots beyond the retain most recent ones, oldest first. snapshots are sorted
+func Expired(snapshots []omerv1.NamespaceLabelSnapshot, retain int) []omerv1.NamespaceLabelSnapshot {
+	if len(snapshots) <= retain {
+		return nil
+	}
+	return snapshots[:len(snapshots)-retain]
+}
+
+// At returns the snapshot describing the namespace at the time: the newest one taken at or before it,
+// nil when there is none. snapshots are sorted
+func At(snapshots []omerv1.NamespaceLabelSnapshot, at time.Time) *omerv1.NamespaceLabelSnapshot {
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if !snapshots[i].CreationTimestamp.Time.After(at) {
+			return &snapshots[i]
+		}
+	}
+	return nil
+}
+
+// Action is what a rollback does to a NamespaceLabel
+type Action string
+
+const (
+	// ActionUpdate rewrites the labels of the spec of an existing NamespaceLabel
+	ActionUpdate Action = "Update"
+	// ActionCreate creates a NamespaceLabel of the snapshot deleted since
+	ActionCreate Action = "Create"
+	// ActionDelete deletes a NamespaceLabel created after the snapshot, only when pruning
+	ActionDelete Action = "Delete"
+)
+
+// Change is one NamespaceLabel written by a rollback, the NamespaceLabel holds the spec to write
+type Change struct {
+	Action         Action
+	NamespaceLabel omerv1.NamespaceLabel
+	// Previous are the labels of the spec before the rollback, nil for ActionCreate
+	Previous map[string]string
+}
+
+// Rollback returns the changes that bring the NamespaceLabels of the namespace back to the snapshot.
+// The NamespaceLabels already matching it are left alone, the ones created after it are only
+// deleted with prune. The other fields of the specs are kept
+func Rollback(snapshot omerv1.NamespaceLabelSnapshot, namespaceLabels []omerv1.NamespaceLabel, prune bool) []Change {
+	existing := make(map[string]omerv1.NamespaceLabel, len(namespaceLabels))
+	for _, namespaceLabel := range namespaceLabels {
+		existing[namespaceLabel.Name] = namespaceLabel
+	}
+
+	var changes []Change
+	inSnapshot := make(map[string]bool, len(snapshot.Spec.NamespaceLabels))
+	for _, state := range snapshot.Spec.NamespaceLabels {
+		inSnapshot[state.Name] = true
+		namespaceLabel, isExist := existing[state.Name]
+		if !isExist {
+			changes = append(changes, Change{
+				Action: ActionCreate,
+				NamespaceLabel: omerv1.NamespaceLabel{
+					ObjectMeta: metav1.ObjectMeta{Name: state.Name, Namespace: snapshot.Namespace},
+					Spec:       omerv1.NamespaceLabelSpec{Labels: state.Labels},
+				},
+			})
+			continue
+		}
+		if equality.Semantic.DeepEqual(namespaceLabel.Spec.Labels, state.Labels) ||
+			(len(namespaceLabel.Spec.Labels) == 0 && len(state.Labels) == 0) {
+			continue
+		}
+		previous := namespaceLabel.Spec.Labels
+		namespaceLabel = *namespaceLabel.DeepCopy()
+		namespaceLabel.Spec.Labels = state.Labels
+		changes = append(changes, Change{Action: ActionUpdate, NamespaceLabel: namespaceLabel, Previous: previous})
+	}
+	if prune {
+		for _, namespaceLabel := range namespaceLabels {
+			if !inSnapshot[namespaceLabel.Name] {
+				changes = append(changes, Change{Action: ActionDelete, NamespaceLabel: namespaceLabel, Previous: namespaceLabel.Spec.Labels})
+			}
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].NamespaceLabel.Name < changes[j].NamespaceLabel.Name })
+	return changes
+}
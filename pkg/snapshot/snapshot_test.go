@@ -0,0 +1,132 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package snapshot
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	omerv1 "omer.io/namespacelabel/api/v1"
+)
+
+var takenAt = time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func namespaceLabel(name string, labels map[string]string) omerv1.NamespaceLabel {
+	return omerv1.NamespaceLabel{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns"},
+		Spec:       omerv1.NamespaceLabelSpec{Labels: labels},
+	}
+}
+
+func snapshotAt(hours int) omerv1.NamespaceLabelSnapshot {
+	at := takenAt.Add(time.Duration(hours) * time.Hour)
+	return omerv1.NamespaceLabelSnapshot{ObjectMeta: metav1.ObjectMeta{Name: Name(at), Namespace: "ns", CreationTimestamp: metav1.NewTime(at)}}
+}
+
+func TestNew(t *testing.T) {
+	now := metav1.Now()
+	deleting := namespaceLabel("deleting", map[string]string{"x": "x"})
+	deleting.DeletionTimestamp = &now
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns", Labels: map[string]string{"team": "a", "env": "prod", "user": "u"}}}
+
+	spec := New(namespace, map[string]string{"team": "b", "env": "team/a", "gone": "b"}, []omerv1.NamespaceLabel{
+		namespaceLabel("b", map[string]string{"team": "a"}),
+		namespaceLabel("a", nil),
+		deleting,
+	})
+	want := omerv1.NamespaceLabelSnapshotSpec{
+		NamespaceLabels: []omerv1.NamespaceLabelState{{Name: "a"}, {Name: "b", Labels: map[string]string{"team": "a"}}},
+		ManagedLabels: []omerv1.ManagedLabel{
+			{Key: "env", Value: "prod", Owner: "team/a"},
+			{Key: "team", Value: "a", Owner: "b"},
+		},
+	}
+	if !reflect.DeepEqual(spec, want) {
+		t.Errorf("New() = %+v, want %+v", spec, want)
+	}
+	if IsEmpty(spec) || !IsEmpty(New(namespace, nil, nil)) {
+		t.Errorf("IsEmpty() is wrong")
+	}
+	if !Equal(spec, New(namespace, map[string]string{"team": "b", "env": "team/a"}, []omerv1.NamespaceLabel{
+		namespaceLabel("a", map[string]string{}),
+		namespaceLabel("b", map[string]string{"team": "a"}),
+	})) {
+		t.Errorf("Equal() = false for the same state")
+	}
+}
+
+func TestSelect(t *testing.T) {
+	snapshots := []omerv1.NamespaceLabelSnapshot{snapshotAt(2), snapshotAt(0), snapshotAt(1)}
+	Sort(snapshots)
+	if snapshots[0].Name != Name(takenAt) || snapshots[2].Name != Name(takenAt.Add(2*time.Hour)) {
+		t.Fatalf("Sort() = %v, want the oldest first", snapshots)
+	}
+
+	if got := At(snapshots, takenAt.Add(90*time.Minute)); got == nil || got.Name != snapshots[1].Name {
+		t.Errorf("At() = %v, want the snapshot of the first hour", got)
+	}
+	if got := At(snapshots, takenAt.Add(time.Hour)); got == nil || got.Name != snapshots[1].Name {
+		t.Errorf("At() = %v, want the snapshot taken at the time", got)
+	}
+	if got := At(snapshots, takenAt.Add(-time.Minute)); got != nil {
+		t.Errorf("At() = %v, want none before the first snapshot", got)
+	}
+
+	expired := Expired(snapshots, 2)
+	if len(expired) != 1 || expired[0].Name != snapshots[0].Name {
+		t.Errorf("Expired() = %v, want the oldest snapshot", expired)
+	}
+	if expired := Expired(snapshots, 3); len(expired) != 0 {
+		t.Errorf("Expired() = %v, want none", expired)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	snapshot := snapshotAt(0)
+	snapshot.Spec.NamespaceLabels = []omerv1.NamespaceLabelState{
+		{Name: "changed", Labels: map[string]string{"team": "a"}},
+		{Name: "deleted", Labels: map[string]string{"env": "prod"}},
+		{Name: "same", Labels: map[string]string{"cost": "1"}},
+	}
+	changed := namespaceLabel("changed", map[string]string{"team": "b", "new": "x"})
+	changed.Spec.Inheritable = []string{"team"}
+	namespaceLabels := []omerv1.NamespaceLabel{changed, namespaceLabel("same", map[string]string{"cost": "1"}), namespaceLabel("created", map[string]string{"x": "x"})}
+
+	changes := Rollback(snapshot, namespaceLabels, false)
+	if len(changes) != 2 {
+		t.Fatalf("Rollback() = %+v, want an update and a create", changes)
+	}
+	if changes[0].Action != ActionUpdate || !reflect.DeepEqual(changes[0].NamespaceLabel.Spec.Labels, map[string]string{"team": "a"}) ||
+		!reflect.DeepEqual(changes[0].NamespaceLabel.Spec.Inheritable, []string{"team"}) || changes[0].Previous["team"] != "b" {
+		t.Errorf("Rollback() update = %+v, want the labels of the snapshot and the rest of the spec", changes[0])
+	}
+	if changes[1].Action != ActionCreate || changes[1].NamespaceLabel.Name != "deleted" || changes[1].NamespaceLabel.Namespace != "ns" {
+		t.Errorf("Rollback() create = %+v, want the deleted NamespaceLabel", changes[1])
+	}
+	if namespaceLabels[0].Spec.Labels["team"] != "b" {
+		t.Errorf("Rollback() changed its input")
+	}
+
+	changes = Rollback(snapshot, namespaceLabels, true)
+	if len(changes) != 3 || changes[1].Action != ActionDelete || changes[1].NamespaceLabel.Name != "created" {
+		t.Errorf("Rollback() with prune = %+v, want the created NamespaceLabel deleted", changes)
+	}
+}
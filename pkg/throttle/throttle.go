@@ -0,0 +1,168 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package throttle limits the rate of the writes of the controller to the namespaces and stops them
+// while a circuit breaker is open, after a burst of failed writes.
+package throttle
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/utils/clock"
+)
+
+// Reason explains why a write is throttled
+type Reason string
+
+const (
+	// ReasonRateLimited means the write is over the global or the namespace rate
+	ReasonRateLimited Reason = "RateLimited"
+	// ReasonCircuitOpen means the recent writes failed too often, no write is done until the breaker closes
+	ReasonCircuitOpen Reason = "CircuitOpen"
+)
+
+// Throttle decides if the controller may write to a namespace now. It never blocks: a throttled write
+// is not done and the caller retries it after the returned delay. The zero value allows every write
+type Throttle struct {
+	// WritesPerSecond and WriteBurst limit the writes to all the namespaces, unlimited when zero
+	WritesPerSecond float64
+	WriteBurst      int
+	// NamespaceWriteInterval is the time between two writes to one namespace once its
+	// NamespaceWriteBurst is used, unlimited when zero
+	NamespaceWriteInterval time.Duration
+	NamespaceWriteBurst    int
+	// MaxFailures failed writes within FailureWindow open the circuit breaker for OpenDuration,
+	// the breaker never opens when zero
+	MaxFailures   int
+	FailureWindow time.Duration
+	OpenDuration  time.Duration
+	// Clock is the real clock when nil
+	Clock clock.PassiveClock
+
+	mu         sync.Mutex
+	global     *rate.Limiter
+	namespaces map[string]*namespaceLimiter
+	prunedAt   time.Time
+	failures   []time.Time
+	openUntil  time.Time
+}
+
+type namespaceLimiter struct {
+	*rate.Limiter
+	usedAt time.Time
+}
+
+// Allow returns zero when the write to the namespace may be done now, the write is counted against
+// the limits. Otherwise it returns the time to wait and why, and nothing is counted
+func (t *Throttle) Allow(namespace string) (time.Duration, Reason) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.clock().Now()
+
+	if now.Before(t.openUntil) {
+		return t.openUntil.Sub(now), ReasonCircuitOpen
+	}
+
+	var reservations []*rate.Reservation
+	if t.WritesPerSecond > 0 {
+		if t.global == nil {
+			t.global = rate.NewLimiter(rate.Limit(t.WritesPerSecond), max(t.WriteBurst, 1))
+		}
+		reservations = append(reservations, t.global.ReserveN(now, 1))
+	}
+	if t.NamespaceWriteInterval > 0 {
+		reservations = append(reservations, t.namespaceLimiter(namespace, now).ReserveN(now, 1))
+	}
+	var delay time.Duration
+	for _, reservation := range reservations {
+		if reservation.DelayFrom(now) > delay {
+			delay = reservation.DelayFrom(now)
+		}
+	}
+	if delay == 0 {
+		return 0, ""
+	}
+	for _, reservation := range reservations {
+		reservation.CancelAt(now)
+	}
+	return delay, ReasonRateLimited
+}
+
+// the function return the limiter of the namespace, the limiters unused for long enough to be full
+// again are dropped, so the map does not keep every namespace ever written
+func (t *Throttle) namespaceLimiter(namespace string, now time.Time) *namespaceLimiter {
+	burst := max(t.NamespaceWriteBurst, 1)
+	idle := t.NamespaceWriteInterval * time.Duration(burst)
+	if t.namespaces == nil {
+		t.namespaces = make(map[string]*namespaceLimiter)
+	}
+	if now.Sub(t.prunedAt) > idle {
+		for name, limiter := range t.namespaces {
+			if now.Sub(limiter.usedAt) > idle {
+				delete(t.namespaces, name)
+			}
+		}
+		t.prunedAt = now
+	}
+
+	limiter, isExist := t.namespaces[namespace]
+	if !isExist {
+		limiter = &namespaceLimiter{Limiter: rate.NewLimiter(rate.Every(t.NamespaceWriteInterval), burst)}
+		t.namespaces[namespace] = limiter
+	}
+	limiter.usedAt = now
+	return limiter
+}
+
+// Record counts the outcome of a write. A conflict or a missing object is the normal outcome of a
+// write racing another writer, only the other errors count as failures
+func (t *Throttle) Record(err error) {
+	if t.MaxFailures <= 0 || err == nil || apierrors.IsConflict(err) || apierrors.IsNotFound(err) {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.clock().Now()
+
+	failures := t.failures[:0]
+	for _, failure := range t.failures {
+		if now.Sub(failure) < t.FailureWindow {
+			failures = append(failures, failure)
+		}
+	}
+	t.failures = append(failures, now)
+	if len(t.failures) >= t.MaxFailures {
+		t.openUntil = now.Add(t.OpenDuration)
+		t.failures = nil
+	}
+}
+
+func (t *Throttle) clock() clock.PassiveClock {
+	if t.Clock == nil {
+		return clock.RealClock{}
+	}
+	return t.Clock
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package throttle
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestAllowNamespaceRate(t *testing.T) {
+	clock := clocktesting.NewFakeClock(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	throttle := &Throttle{NamespaceWriteInterval: 10 * time.Second, NamespaceWriteBurst: 2, Clock: clock}
+
+	for i := 0; i < 2; i++ {
+		if delay, _ := throttle.Allow("a"); delay != 0 {
+			t.Fatalf("write %d within the burst throttled for %v", i, delay)
+		}
+	}
+	delay, reason := throttle.Allow("a")
+	if delay != 10*time.Second || reason != ReasonRateLimited {
+		t.Errorf("Allow() = %v, %s, want the namespace rate limited for 10s", delay, reason)
+	}
+	if delay, _ := throttle.Allow("b"); delay != 0 {
+		t.Errorf("another namespace throttled for %v", delay)
+	}
+
+	//a throttled write is not counted, the namespace is allowed once the interval passed
+	clock.Step(10 * time.Second)
+	if delay, _ := throttle.Allow("a"); delay != 0 {
+		t.Errorf("write after the interval throttled for %v", delay)
+	}
+
+	//the limiters of idle namespaces are dropped
+	clock.Step(time.Minute)
+	throttle.Allow("c")
+	if _, isExist := throttle.namespaces["a"]; isExist || len(throttle.namespaces) != 1 {
+		t.Errorf("idle namespace limiters were kept: %v", throttle.namespaces)
+	}
+}
+
+func TestAllowGlobalRate(t *testing.T) {
+	clock := clocktesting.NewFakeClock(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	throttle := &Throttle{WritesPerSecond: 2, WriteBurst: 1, NamespaceWriteInterval: time.Minute, Clock: clock}
+
+	if delay, _ := throttle.Allow("a"); delay != 0 {
+		t.Fatalf("first write throttled for %v", delay)
+	}
+	if delay, reason := throttle.Allow("b"); delay != 500*time.Millisecond || reason != ReasonRateLimited {
+		t.Errorf("Allow() = %v, %s, want the global rate limited for 500ms", delay, reason)
+	}
+	//the namespace limiter of b was not charged by the throttled write
+	clock.Step(500 * time.Millisecond)
+	if delay, _ := throttle.Allow("b"); delay != 0 {
+		t.Errorf("write after the global delay throttled for %v", delay)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	clock := clocktesting.NewFakeClock(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	throttle := &Throttle{MaxFailures: 3, FailureWindow: time.Minute, OpenDuration: 5 * time.Minute, Clock: clock}
+	failure := errors.New("internal error")
+
+	//the conflicts and the failures out of the window do not open the breaker
+	throttle.Record(failure)
+	throttle.Record(apierrors.NewConflict(schema.GroupResource{Resource: "namespaces"}, "a", failure))
+	throttle.Record(nil)
+	clock.Step(2 * time.Minute)
+	throttle.Record(failure)
+	throttle.Record(failure)
+	if delay, _ := throttle.Allow("a"); delay != 0 {
+		t.Fatalf("breaker opened by %d failures", 2)
+	}
+
+	throttle.Record(failure)
+	delay, reason := throttle.Allow("a")
+	if delay != 5*time.Minute || reason != ReasonCircuitOpen {
+		t.Errorf("Allow() = %v, %s, want the circuit open for 5m", delay, reason)
+	}
+	clock.Step(5 * time.Minute)
+	if delay, _ := throttle.Allow("a"); delay != 0 {
+		t.Errorf("write after the breaker closed throttled for %v", delay)
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tracing sets up the OpenTelemetry tracer provider of the controller manager and starts the
+// spans of the reconciles and of the calls to the API server.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the instrumentation name of the spans of the controller
+const TracerName = "omer.io/namespacelabel"
+
+// the attributes the spans carry to find the traces of a namespace or a custom resource
+const (
+	NamespaceKey      = attribute.Key("k8s.namespace.name")
+	NameKey           = attribute.Key("k8s.object.name")
+	KindKey           = attribute.Key("k8s.object.kind")
+	NamespaceLabelKey = attribute.Key("namespacelabel.name")
+	GenerationKey     = attribute.Key("namespacelabel.generation")
+)
+
+// Exporter is where the spans are sent
+type Exporter string
+
+const (
+	// ExporterNone records no span
+	ExporterNone Exporter = "none"
+	// ExporterOTLP sends the spans to an OTLP gRPC collector
+	ExporterOTLP Exporter = "otlp"
+	// ExporterStdout writes the spans as json lines, for debugging
+	ExporterStdout Exporter = "stdout"
+)
+
+// Options configure the tracer provider
+type Options struct {
+	Exporter Exporter
+	// Endpoint is the host:port of the OTLP collector, OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4317 when empty
+	Endpoint string
+	// Insecure sends the spans to the collector without TLS
+	Insecure bool
+	// SampleRatio is the part of the reconciles that are traced, the child spans follow their parent
+	SampleRatio float64
+	// ServiceName is reported as the service.name resource attribute
+	ServiceName string
+	// Writer receives the spans of the stdout exporter
+	Writer io.Writer
+}
+
+// NewTracerProvider returns the tracer provider of the options, nil when no exporter is set. The caller
+// shuts it down on exit to flush the spans that are still batched
+func NewTracerProvider(ctx context.Context, options Options) (*sdktrace.TracerProvider, error) {
+	if options.SampleRatio < 0 || options.SampleRatio > 1 {
+		return nil, fmt.Errorf("tracing sample ratio %v is not between 0 and 1", options.SampleRatio)
+	}
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch options.Exporter {
+	case "", ExporterNone:
+		return nil, nil
+	case ExporterOTLP:
+		var otlpOptions []otlptracegrpc.Option
+		if options.Endpoint != "" {
+			otlpOptions = append(otlpOptions, otlptracegrpc.WithEndpoint(options.Endpoint))
+		}
+		if options.Insecure {
+			otlpOptions = append(otlpOptions, otlptracegrpc.WithInsecure())
+		}
+		exporter, err = otlptracegrpc.New(ctx, otlpOptions...)
+	case ExporterStdout:
+		var stdoutOptions []stdouttrace.Option
+		if options.Writer != nil {
+			stdoutOptions = append(stdoutOptions, stdouttrace.WithWriter(options.Writer))
+		}
+		exporter, err = stdouttrace.New(stdoutOptions...)
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q, expected none, otlp or stdout", options.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	serviceName := options.ServiceName
+	if serviceName == "" {
+		serviceName = "namespacelabel"
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(options.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName))),
+	), nil
+}
+
+// Start starts a span of the global tracer provider, it records nothing until a provider is installed
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(TracerName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// End ends the span, marking it failed when err is set
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// the function install a tracer provider that records the spans in memory until the test ends
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return exporter
+}
+
+func TestNewTracerProvider(t *testing.T) {
+	tests := []struct {
+		name         string
+		options      Options
+		wantProvider bool
+		wantErr      bool
+	}{
+		{name: "no exporter", options: Options{}},
+		{name: "none", options: Options{Exporter: ExporterNone}},
+		{name: "stdout", options: Options{Exporter: ExporterStdout, SampleRatio: 1}, wantProvider: true},
+		{name: "otlp", options: Options{Exporter: ExporterOTLP, Endpoint: "localhost:4317", Insecure: true, SampleRatio: 0.5}, wantProvider: true},
+		{name: "unknown exporter", options: Options{Exporter: "jaeger"}, wantErr: true},
+		{name: "sample ratio over 1", options: Options{Exporter: ExporterStdout, SampleRatio: 2}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.options.Writer = &bytes.Buffer{}
+			provider, err := NewTracerProvider(context.Background(), tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want an error %v", err, tt.wantErr)
+			}
+			if (provider != nil) != tt.wantProvider {
+				t.Fatalf("provider = %v, want a provider %v", provider, tt.wantProvider)
+			}
+			if provider != nil {
+				_ = provider.Shutdown(context.Background())
+			}
+		})
+	}
+}
+
+func TestStdoutExporter(t *testing.T) {
+	var out bytes.Buffer
+	provider, err := NewTracerProvider(context.Background(), Options{Exporter: ExporterStdout, SampleRatio: 1, Writer: &out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := provider.Tracer(TracerName).Start(context.Background(), "Reconcile")
+	span.End()
+	if err := provider.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `"Name":"Reconcile"`) || !strings.Contains(out.String(), `"namespacelabel"`) {
+		t.Errorf("stdout = %s, want the Reconcile span of the namespacelabel service", out.String())
+	}
+}
+
+func TestWrapClient(t *testing.T) {
+	exporter := recordSpans(t)
+	c := WrapClient(fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).Build())
+
+	var namespace v1.Namespace
+	if err := c.Get(context.Background(), types.NamespacedName{Name: "missing"}, &namespace); err == nil {
+		t.Fatal("got the missing namespace")
+	}
+	var namespaces v1.NamespaceList
+	if err := c.List(context.Background(), &namespaces); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	if spans[0].Name != "Get Namespace" || spans[0].Status.Code != codes.Error {
+		t.Errorf("span = %s with status %v, want a failed Get Namespace", spans[0].Name, spans[0].Status)
+	}
+	if !containsAttribute(spans[0], NameKey.String("missing")) || !containsAttribute(spans[0], KindKey.String("Namespace")) {
+		t.Errorf("span attributes = %v, want the kind and the name", spans[0].Attributes)
+	}
+	if spans[1].Name != "List NamespaceList" || spans[1].Status.Code == codes.Error {
+		t.Errorf("span = %s with status %v, want a List NamespaceList", spans[1].Name, spans[1].Status)
+	}
+}
+
+func TestWrapTransport(t *testing.T) {
+	exporter := recordSpans(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/status") {
+			w.WriteHeader(http.StatusConflict)
+		}
+	}))
+	defer server.Close()
+	config := &rest.Config{Host: server.URL}
+	WrapTransport(config)
+	httpClient, err := rest.HTTPClientFor(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/api/v1/namespaces/a", "/api/v1/namespaces/a/status"} {
+		request, _ := http.NewRequest(http.MethodPatch, server.URL+path, nil)
+		response, err := httpClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	if spans[0].Name != "HTTP PATCH" || spans[0].Status.Code == codes.Error {
+		t.Errorf("span = %s with status %v, want a successful HTTP PATCH", spans[0].Name, spans[0].Status)
+	}
+	if spans[1].Status.Code != codes.Error || !containsAttribute(spans[1], semconv.HTTPStatusCode(http.StatusConflict)) {
+		t.Errorf("span status = %v, attributes = %v, want the failed status write", spans[1].Status, spans[1].Attributes)
+	}
+}
+
+func containsAttribute(span tracetest.SpanStub, want attribute.KeyValue) bool {
+	for _, got := range span.Attributes {
+		if got == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"k8s.io/client-go/rest"
+)
+
+// WrapTransport makes the clients of the config start a span around every request to the API server,
+// the status writes included. The span is a child of the reconcile or client span of the request
+func WrapTransport(config *rest.Config) {
+	config.Wrap(func(next http.RoundTripper) http.RoundTripper {
+		return roundTripper{next: next}
+	})
+}
+
+type roundTripper struct {
+	next http.RoundTripper
+}
+
+func (rt roundTripper) RoundTrip(request *http.Request) (*http.Response, error) {
+	ctx, span := Start(request.Context(), "HTTP "+request.Method,
+		semconv.HTTPMethod(request.Method),
+		semconv.HTTPURL(request.URL.Redacted()),
+	)
+	response, err := rt.next.RoundTrip(request.WithContext(ctx))
+	if err == nil {
+		span.SetAttributes(semconv.HTTPStatusCode(response.StatusCode))
+		if response.StatusCode >= http.StatusBadRequest {
+			span.SetStatus(codes.Error, response.Status)
+		}
+	}
+	End(span, err)
+	return response, err
+}